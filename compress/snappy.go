@@ -0,0 +1,108 @@
+// Package compress implements the block compressors used by the columnar
+// outputs. Only the compression side is implemented, which is all a log
+// generator needs.
+package compress
+
+import (
+	"encoding/binary"
+)
+
+// The tags of the snappy elements
+const (
+	tagLiteral = 0x00
+	tagCopy1   = 0x01
+	tagCopy2   = 0x02
+)
+
+const (
+	// snappyMaxOffset is the maximum offset of a copy element with a 2-byte
+	// offset.
+	snappyMaxOffset = 1<<16 - 1
+	// minMatch is the minimum length of a match.
+	minMatch = 4
+	// hashLog is the size of the hash table in bits.
+	hashLog = 14
+)
+
+// Snappy compresses src in the snappy block format (not the framing format),
+// which is what Parquet expects for the SNAPPY codec.
+func Snappy(src []byte) []byte {
+	dst := make([]byte, binary.MaxVarintLen64, len(src)+len(src)/6+32)
+	n := binary.PutUvarint(dst, uint64(len(src)))
+	dst = dst[:n]
+
+	var table [1 << hashLog]int32
+	for i := range table {
+		table[i] = -1
+	}
+
+	lit := 0
+	for i := 0; i+minMatch <= len(src); {
+		h := hash4(load32(src, i))
+		cand := int(table[h])
+		table[h] = int32(i)
+
+		if cand < 0 || i-cand > snappyMaxOffset || load32(src, cand) != load32(src, i) {
+			i++
+			continue
+		}
+
+		m := minMatch
+		for i+m < len(src) && src[cand+m] == src[i+m] {
+			m++
+		}
+		dst = snappyLiteral(dst, src[lit:i])
+		dst = snappyCopy(dst, i-cand, m)
+		i += m
+		lit = i
+	}
+	return snappyLiteral(dst, src[lit:])
+}
+
+// snappyLiteral appends a literal element to dst.
+func snappyLiteral(dst []byte, lit []byte) []byte {
+	if len(lit) == 0 {
+		return dst
+	}
+
+	n := uint32(len(lit) - 1)
+	switch {
+	case n < 60:
+		dst = append(dst, byte(n)<<2|tagLiteral)
+	case n < 1<<8:
+		dst = append(dst, 60<<2|tagLiteral, byte(n))
+	case n < 1<<16:
+		dst = append(dst, 61<<2|tagLiteral, byte(n), byte(n>>8))
+	case n < 1<<24:
+		dst = append(dst, 62<<2|tagLiteral, byte(n), byte(n>>8), byte(n>>16))
+	default:
+		dst = append(dst, 63<<2|tagLiteral, byte(n), byte(n>>8), byte(n>>16), byte(n>>24))
+	}
+	return append(dst, lit...)
+}
+
+// snappyCopy appends the copy elements of a match to dst. A single copy
+// element is 64 bytes at most, so a long match is split into several ones.
+func snappyCopy(dst []byte, offset, length int) []byte {
+	for length >= 68 {
+		dst = append(dst, 63<<2|tagCopy2, byte(offset), byte(offset>>8))
+		length -= 64
+	}
+	if length > 64 {
+		// Leave at least 4 bytes for the last element.
+		dst = append(dst, 59<<2|tagCopy2, byte(offset), byte(offset>>8))
+		length -= 60
+	}
+	if length >= 12 || offset >= 2048 {
+		return append(dst, byte(length-1)<<2|tagCopy2, byte(offset), byte(offset>>8))
+	}
+	return append(dst, byte(offset>>8)<<5|byte(length-4)<<2|tagCopy1, byte(offset))
+}
+
+func load32(b []byte, i int) uint32 {
+	return binary.LittleEndian.Uint32(b[i : i+4])
+}
+
+func hash4(u uint32) uint32 {
+	return (u * 0x1e35a7bd) >> (32 - hashLog)
+}
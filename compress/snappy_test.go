@@ -0,0 +1,87 @@
+package compress
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math/rand"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+// unsnappy is a minimal snappy block decoder to verify the encoder.
+func unsnappy(src []byte) ([]byte, error) {
+	n, l := binary.Uvarint(src)
+	if l <= 0 {
+		return nil, errors.New("bad length")
+	}
+	src = src[l:]
+	dst := make([]byte, 0, n)
+
+	for len(src) > 0 {
+		tag := src[0]
+		switch tag & 0x03 {
+		case tagLiteral:
+			ln := int(tag >> 2)
+			src = src[1:]
+			if ln >= 60 {
+				nb := ln - 59
+				ln = 0
+				for i := 0; i < nb; i++ {
+					ln |= int(src[i]) << (8 * uint(i))
+				}
+				src = src[nb:]
+			}
+			ln++
+			dst = append(dst, src[:ln]...)
+			src = src[ln:]
+		case tagCopy1:
+			ln := int(tag>>2&0x07) + 4
+			off := int(tag>>5)<<8 | int(src[1])
+			src = src[2:]
+			for i := 0; i < ln; i++ {
+				dst = append(dst, dst[len(dst)-off])
+			}
+		case tagCopy2:
+			ln := int(tag>>2) + 1
+			off := int(binary.LittleEndian.Uint16(src[1:]))
+			src = src[3:]
+			for i := 0; i < ln; i++ {
+				dst = append(dst, dst[len(dst)-off])
+			}
+		default:
+			return nil, errors.New("unsupported tag")
+		}
+	}
+	if uint64(len(dst)) != n {
+		return nil, errors.New("length mismatch")
+	}
+	return dst, nil
+}
+
+func testInputs() map[string][]byte {
+	random := make([]byte, 100000)
+	rand.Read(random)
+
+	return map[string][]byte{
+		"empty":      {},
+		"short":      []byte("abc"),
+		"repetitive": bytes.Repeat([]byte("<2018-01-01> <INFO> <GuoJing> hello world\n"), 5000),
+		"long run":   bytes.Repeat([]byte{'a'}, 300000),
+		"random":     random,
+		"mixed":      append(append([]byte("prefix"), random[:5000]...), bytes.Repeat(random[:100], 100)...),
+	}
+}
+
+func TestSnappy(t *testing.T) {
+	for name, in := range testInputs() {
+		out := Snappy(in)
+		dec, err := unsnappy(out)
+		assert.Nil(t, err, name)
+		assert.Equal(t, in, dec, name)
+	}
+
+	in := testInputs()["repetitive"]
+	assert.True(t, len(Snappy(in)) < len(in)/10)
+}
@@ -0,0 +1,414 @@
+package compress
+
+import (
+	"encoding/binary"
+	"sort"
+)
+
+// The zstd encoder below produces standard zstd frames (RFC 8878) which can be
+// read by any zstd decoder. It finds matches with a simple hash table and
+// encodes the sequences with the predefined FSE distributions, while the
+// literals are stored uncompressed. The ratio is lower than the reference
+// implementation, but the output is still far smaller than the input for the
+// repetitive data a log generator produces.
+
+const (
+	zstdMagic       = 0xFD2FB528
+	zstdMaxBlock    = 1 << 17 // 128 KB
+	zstdBlockRaw    = 0
+	zstdBlockZstd   = 2
+	zstdMaxMatchLen = 65539 + 1<<16 - 1
+	zstdMaxOffset   = 1<<29 - 4
+)
+
+// Zstd compresses src into a single zstd frame.
+func Zstd(src []byte) []byte {
+	dst := make([]byte, 4, len(src)/2+32)
+	binary.LittleEndian.PutUint32(dst, zstdMagic)
+
+	// Single_Segment_flag is set so that the window is the whole content, and
+	// no Window_Descriptor is needed.
+	if uint64(len(src)) <= 0xFFFFFFFF {
+		dst = append(dst, 2<<6|1<<5, 0, 0, 0, 0)
+		binary.LittleEndian.PutUint32(dst[len(dst)-4:], uint32(len(src)))
+	} else {
+		dst = append(dst, 3<<6|1<<5, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.LittleEndian.PutUint64(dst[len(dst)-8:], uint64(len(src)))
+	}
+
+	if len(src) == 0 {
+		return appendBlockHeader(dst, true, zstdBlockRaw, 0)
+	}
+
+	m := newMatcher(src)
+	for start := 0; start < len(src); start += zstdMaxBlock {
+		end := start + zstdMaxBlock
+		if end > len(src) {
+			end = len(src)
+		}
+		last := end == len(src)
+
+		block := encodeBlock(src, start, end, m)
+		if block == nil || len(block) >= end-start {
+			dst = appendBlockHeader(dst, last, zstdBlockRaw, end-start)
+			dst = append(dst, src[start:end]...)
+			continue
+		}
+		dst = appendBlockHeader(dst, last, zstdBlockZstd, len(block))
+		dst = append(dst, block...)
+	}
+	return dst
+}
+
+func appendBlockHeader(dst []byte, last bool, typ int, size int) []byte {
+	h := uint32(size)<<3 | uint32(typ)<<1
+	if last {
+		h |= 1
+	}
+	return append(dst, byte(h), byte(h>>8), byte(h>>16))
+}
+
+// sequence is a run of literals followed by a match.
+type sequence struct {
+	litLen   int
+	offset   int
+	matchLen int
+}
+
+// matcher finds the matches in the whole input, so that a block may refer to
+// the data of the previous blocks.
+type matcher struct {
+	src   []byte
+	table [1 << hashLog]int32
+}
+
+func newMatcher(src []byte) *matcher {
+	m := &matcher{src: src}
+	for i := range m.table {
+		m.table[i] = -1
+	}
+	return m
+}
+
+// sequences returns the sequences of src[start:end] and the literals they
+// refer to, including the trailing ones.
+func (m *matcher) sequences(start, end int) ([]sequence, []byte) {
+	var seqs []sequence
+	var lits []byte
+
+	src := m.src
+	lit := start
+	for i := start; i+minMatch <= end; {
+		h := hash4(load32(src, i))
+		cand := int(m.table[h])
+		m.table[h] = int32(i)
+
+		if cand < 0 || i-cand > zstdMaxOffset || load32(src, cand) != load32(src, i) {
+			i++
+			continue
+		}
+
+		ml := minMatch
+		for i+ml < end && ml < zstdMaxMatchLen && src[cand+ml] == src[i+ml] {
+			ml++
+		}
+		seqs = append(seqs, sequence{litLen: i - lit, offset: i - cand, matchLen: ml})
+		lits = append(lits, src[lit:i]...)
+		i += ml
+		lit = i
+	}
+	lits = append(lits, src[lit:end]...)
+	return seqs, lits
+}
+
+// encodeBlock returns the content of a compressed block, or nil if the block
+// can't be compressed.
+func encodeBlock(src []byte, start, end int, m *matcher) []byte {
+	seqs, lits := m.sequences(start, end)
+	if len(seqs) == 0 {
+		return nil
+	}
+
+	// Literals section: Raw_Literals_Block
+	var b []byte
+	n := len(lits)
+	switch {
+	case n < 1<<5:
+		b = append(b, byte(n<<3))
+	case n < 1<<12:
+		b = append(b, byte(n<<4)|1<<2, byte(n>>4))
+	default:
+		b = append(b, byte(n<<4)|3<<2, byte(n>>4), byte(n>>12))
+	}
+	b = append(b, lits...)
+
+	// Sequences section header
+	ns := len(seqs)
+	switch {
+	case ns < 128:
+		b = append(b, byte(ns))
+	case ns < 0x7F00:
+		b = append(b, byte(ns>>8)+128, byte(ns))
+	default:
+		b = append(b, 255, byte(ns-0x7F00), byte((ns-0x7F00)>>8))
+	}
+	// Predefined_Mode for all the three symbol types
+	b = append(b, 0)
+
+	return append(b, encodeSequences(seqs)...)
+}
+
+// encodeSequences encodes the sequences into a backward bit stream, in the
+// reverse order of decoding.
+func encodeSequences(seqs []sequence) []byte {
+	codes := make([]seqCodes, len(seqs))
+	for i, s := range seqs {
+		codes[i] = newSeqCodes(s)
+	}
+
+	var bw bitWriter
+	last := len(seqs) - 1
+
+	ml := newFSEState(mlTable, codes[last].ml)
+	of := newFSEState(ofTable, codes[last].of)
+	ll := newFSEState(llTable, codes[last].ll)
+	codes[last].addExtraBits(&bw)
+
+	for i := last - 1; i >= 0; i-- {
+		of.encode(&bw, codes[i].of)
+		ml.encode(&bw, codes[i].ml)
+		ll.encode(&bw, codes[i].ll)
+		codes[i].addExtraBits(&bw)
+	}
+
+	ml.flush(&bw)
+	of.flush(&bw)
+	ll.flush(&bw)
+	return bw.close()
+}
+
+// seqCodes are the symbols and extra bits of a sequence.
+type seqCodes struct {
+	ll, ml, of             uint8
+	llExtra, mlExtra       uint32
+	ofExtra                uint32
+	llBits, mlBits, ofBits uint
+}
+
+func newSeqCodes(s sequence) seqCodes {
+	var c seqCodes
+
+	c.ll = uint8(sort.Search(len(llBase), func(i int) bool { return llBase[i] > uint32(s.litLen) }) - 1)
+	c.llBits = llBits[c.ll]
+	c.llExtra = uint32(s.litLen) - llBase[c.ll]
+
+	c.ml = uint8(sort.Search(len(mlBase), func(i int) bool { return mlBase[i] > uint32(s.matchLen) }) - 1)
+	c.mlBits = mlBits[c.ml]
+	c.mlExtra = uint32(s.matchLen) - mlBase[c.ml]
+
+	// Offset values 1-3 are the repeat offsets, which are never used here.
+	ov := uint32(s.offset) + 3
+	c.of = uint8(highBit(ov))
+	c.ofBits = uint(c.of)
+	c.ofExtra = ov - 1<<c.of
+	return c
+}
+
+func (c *seqCodes) addExtraBits(bw *bitWriter) {
+	bw.addBits(c.llExtra, c.llBits)
+	bw.addBits(c.mlExtra, c.mlBits)
+	bw.addBits(c.ofExtra, c.ofBits)
+}
+
+// The baselines and number of extra bits of the literal length codes.
+var (
+	llBase = []uint32{
+		0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15,
+		16, 18, 20, 22, 24, 28, 32, 40, 48, 64, 128, 256, 512, 1024, 2048, 4096,
+		8192, 16384, 32768, 65536,
+	}
+	llBits = []uint{
+		0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+		1, 1, 1, 1, 2, 2, 3, 3, 4, 6, 7, 8, 9, 10, 11, 12,
+		13, 14, 15, 16,
+	}
+)
+
+// The baselines and number of extra bits of the match length codes.
+var (
+	mlBase = []uint32{
+		3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18,
+		19, 20, 21, 22, 23, 24, 25, 26, 27, 28, 29, 30, 31, 32, 33, 34,
+		35, 37, 39, 41, 43, 47, 51, 59, 67, 83, 99, 131, 259, 515, 1027, 2051,
+		4099, 8195, 16387, 32771, 65539,
+	}
+	mlBits = []uint{
+		0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+		0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+		1, 1, 1, 1, 2, 2, 3, 3, 4, 4, 5, 7, 8, 9, 10, 11,
+		12, 13, 14, 15, 16,
+	}
+)
+
+// The predefined FSE distributions of the sequence codes.
+var (
+	llTable = newFSETable(6, []int16{
+		4, 3, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 1, 1, 1,
+		2, 2, 2, 2, 2, 2, 2, 2, 2, 3, 2, 1, 1, 1, 1, 1,
+		-1, -1, -1, -1,
+	})
+	mlTable = newFSETable(6, []int16{
+		1, 4, 3, 2, 2, 2, 2, 2, 2, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, -1, -1,
+		-1, -1, -1, -1, -1,
+	})
+	ofTable = newFSETable(5, []int16{
+		1, 1, 1, 1, 1, 1, 2, 2, 2, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, 1, 1, 1, 1, -1, -1, -1, -1, -1,
+	})
+)
+
+// fseTable is the encoding table of a normalized FSE distribution.
+type fseTable struct {
+	accuracyLog uint
+	states      []uint16
+	transforms  []fseTransform
+}
+
+type fseTransform struct {
+	deltaFindState int32
+	deltaNbBits    uint32
+}
+
+// newFSETable builds the encoding table of a normalized distribution, in which
+// -1 stands for a "less than 1" probability.
+func newFSETable(accuracyLog uint, norm []int16) *fseTable {
+	size := 1 << accuracyLog
+	mask := size - 1
+	high := size - 1
+
+	symbols := make([]uint8, size)
+	cumul := make([]int, len(norm)+1)
+	for s, c := range norm {
+		if c == -1 {
+			cumul[s+1] = cumul[s] + 1
+			symbols[high] = uint8(s)
+			high--
+		} else {
+			cumul[s+1] = cumul[s] + int(c)
+		}
+	}
+
+	// Spread the symbols the same way as the decoder does.
+	pos := 0
+	step := size>>1 + size>>3 + 3
+	for s, c := range norm {
+		for i := 0; i < int(c); i++ {
+			symbols[pos] = uint8(s)
+			pos = (pos + step) & mask
+			for pos > high {
+				pos = (pos + step) & mask
+			}
+		}
+	}
+
+	t := &fseTable{
+		accuracyLog: accuracyLog,
+		states:      make([]uint16, size),
+		transforms:  make([]fseTransform, len(norm)),
+	}
+	for u := 0; u < size; u++ {
+		s := symbols[u]
+		t.states[cumul[s]] = uint16(size + u)
+		cumul[s]++
+	}
+
+	total := int32(0)
+	for s, c := range norm {
+		switch c {
+		case 0:
+			continue
+		case -1, 1:
+			t.transforms[s] = fseTransform{
+				deltaFindState: total - 1,
+				deltaNbBits:    uint32(accuracyLog<<16) - uint32(size),
+			}
+			total++
+		default:
+			maxBitsOut := accuracyLog - uint(highBit(uint32(c-1)))
+			minStatePlus := uint32(c) << maxBitsOut
+			t.transforms[s] = fseTransform{
+				deltaFindState: total - int32(c),
+				deltaNbBits:    uint32(maxBitsOut<<16) - minStatePlus,
+			}
+			total += int32(c)
+		}
+	}
+	return t
+}
+
+// fseState is the state of an FSE encoder.
+type fseState struct {
+	t     *fseTable
+	state uint32
+}
+
+// newFSEState initializes the state with the first symbol to encode (the last
+// one to decode), which doesn't output any bits.
+func newFSEState(t *fseTable, symbol uint8) *fseState {
+	tt := t.transforms[symbol]
+	nbBitsOut := (tt.deltaNbBits + 1<<15) >> 16
+	v := nbBitsOut<<16 - tt.deltaNbBits
+	return &fseState{t: t, state: uint32(t.states[int32(v>>nbBitsOut)+tt.deltaFindState])}
+}
+
+func (s *fseState) encode(bw *bitWriter, symbol uint8) {
+	tt := s.t.transforms[symbol]
+	nbBitsOut := (s.state + tt.deltaNbBits) >> 16
+	bw.addBits(s.state, uint(nbBitsOut))
+	s.state = uint32(s.t.states[int32(s.state>>nbBitsOut)+tt.deltaFindState])
+}
+
+func (s *fseState) flush(bw *bitWriter) {
+	bw.addBits(s.state, s.t.accuracyLog)
+}
+
+// bitWriter writes a little-endian bit stream, which is read backward by the
+// decoder.
+type bitWriter struct {
+	out   []byte
+	bits  uint64
+	nbits uint
+}
+
+func (bw *bitWriter) addBits(v uint32, n uint) {
+	if n == 0 {
+		return
+	}
+	bw.bits |= uint64(v&(1<<n-1)) << bw.nbits
+	bw.nbits += n
+	for bw.nbits >= 8 {
+		bw.out = append(bw.out, byte(bw.bits))
+		bw.bits >>= 8
+		bw.nbits -= 8
+	}
+}
+
+// close adds the end mark and flushes the remaining bits.
+func (bw *bitWriter) close() []byte {
+	bw.addBits(1, 1)
+	if bw.nbits > 0 {
+		bw.out = append(bw.out, byte(bw.bits))
+	}
+	return bw.out
+}
+
+// highBit returns the position of the highest set bit.
+func highBit(v uint32) int {
+	n := -1
+	for ; v != 0; v >>= 1 {
+		n++
+	}
+	return n
+}
@@ -0,0 +1,58 @@
+package compress
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os/exec"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestZstdFrame(t *testing.T) {
+	out := Zstd(nil)
+	assert.Equal(t, uint32(zstdMagic), binary.LittleEndian.Uint32(out))
+	// a single empty raw block with the last block flag
+	assert.Equal(t, []byte{1, 0, 0}, out[len(out)-3:])
+
+	in := testInputs()["random"]
+	out = Zstd(in)
+	assert.Equal(t, uint32(len(in)), binary.LittleEndian.Uint32(out[5:]))
+	assert.True(t, len(out) > len(in))
+
+	in = testInputs()["repetitive"]
+	assert.True(t, len(Zstd(in)) < len(in)/10)
+}
+
+func TestHighBit(t *testing.T) {
+	assert.Equal(t, -1, highBit(0))
+	assert.Equal(t, 0, highBit(1))
+	assert.Equal(t, 4, highBit(31))
+	assert.Equal(t, 5, highBit(32))
+}
+
+func TestFSETable(t *testing.T) {
+	for _, ft := range []*fseTable{llTable, mlTable, ofTable} {
+		size := 1 << ft.accuracyLog
+		for _, s := range ft.states {
+			assert.True(t, int(s) >= size && int(s) < 2*size)
+		}
+	}
+}
+
+// TestZstdDecode verifies the frames with the zstd command line tool if it's
+// installed.
+func TestZstdDecode(t *testing.T) {
+	if _, err := exec.LookPath("zstd"); err != nil {
+		t.Skip("zstd not found")
+	}
+
+	for name, in := range testInputs() {
+		cmd := exec.Command("zstd", "-d", "-q", "-c")
+		cmd.Stdin = bytes.NewReader(Zstd(in))
+		out, err := cmd.Output()
+		assert.Nil(t, err, name)
+		assert.Equal(t, len(in), len(out), name)
+		assert.True(t, bytes.Equal(in, out), name)
+	}
+}
@@ -6,7 +6,10 @@ package config
 import (
 	"encoding/json"
 
+	"github.com/jiwen624/logspout/console"
+	"github.com/jiwen624/logspout/metrics"
 	"github.com/jiwen624/logspout/output"
+	"github.com/jiwen624/logspout/profile"
 	"github.com/pkg/errors"
 )
 
@@ -20,7 +23,9 @@ type SpoutConfig struct {
 	// instead to achieve the same outcome.
 	BurstMode bool `json:"burstMode"`
 
-	// UniformLoad means the workload is uniform
+	// UniformLoad means the workload is uniform, the delays are drawn from the
+	// uniform distribution rather than the truncated Gaussian unless the
+	// jitters are set.
 	UniformLoad bool `json:"uniformLoad"`
 
 	// Duration means how long the logspout will run for (in seconds)
@@ -31,19 +36,63 @@ type SpoutConfig struct {
 	// A zero value (or non-exist) means it will run infinitely
 	MaxEvents int `json:"maxEvents"`
 
+	// SplitTransactions lets the last transaction be cut to generate exactly
+	// MaxEvents in transaction mode. Otherwise the transactions are always
+	// whole, so fewer events are generated if MaxEvents is not a multiple of
+	// the number of logs of a transaction.
+	SplitTransactions bool `json:"splitTransactions"`
+
 	// ConsolePort specifies the port for management console. The default console
 	// is 10306
 	ConsolePort int `json:"consolePort"`
 
+	// Console defines the bind address, the authentication and the TLS of the
+	// management console, which can also be disabled here.
+	Console console.Config `json:"console"`
+
+	// StatsD pushes the metrics to a StatsD or DogStatsD server if it's set.
+	StatsD *metrics.StatsDConfig `json:"statsd"`
+
 	// Concurrency defines the number of workers to generate logs concurrently.
 	Concurrency int `json:"concurrency"`
 
+	// WorkerProfile scales Concurrency over time in the same way as
+	// LoadProfile scales the rate, e.g., a step profile adds the workers step
+	// by step to find the concurrency an output saturates at. At least one
+	// worker is kept running.
+	WorkerProfile *profile.Config `json:"workerProfile"`
+
 	// MinInterval is the minimum interval between two log entries.
 	MinInterval int `json:"minInterval"`
 
 	// MaxInterval is the maximum interval between two log entries.
 	MaxInterval int `json:"maxInterval"`
 
+	// EPS is the rate in events per second of all the workers, which overrides
+	// the intervals and the intra-transaction latency. 0 means unlimited.
+	EPS float64 `json:"eps"`
+
+	// BytesPerSecond is the rate in bytes of the rendered events per second of
+	// all the workers, which is an alternative to EPS when the volume matters
+	// rather than the number of events.
+	BytesPerSecond float64 `json:"bytesPerSecond"`
+
+	// GBPerDay is the same as BytesPerSecond in gigabytes (10^9 bytes) per day,
+	// which is how the log platforms are usually sized. At most one of EPS,
+	// BytesPerSecond and GBPerDay may be set.
+	GBPerDay float64 `json:"gbPerDay"`
+
+	// LoadProfile shapes the rate set by EPS, BytesPerSecond or GBPerDay over
+	// time, e.g., a ramp or a diurnal curve, see the profile package.
+	LoadProfile *profile.Config `json:"loadProfile"`
+
+	// Pacing defines how the events are kept on their schedule.
+	Pacing Pacing `json:"pacing"`
+
+	// Shutdown defines how the workers and the outputs are drained when
+	// logspout is shut down.
+	Shutdown Shutdown `json:"shutdown"`
+
 	// LogType defines the type of the logs, e.g., the application name.
 	LogType string `json:"logType"`
 
@@ -60,6 +109,14 @@ type SpoutConfig struct {
 	// I need a better name for it).
 	MaxIntraTransactionLatency int `json:"maxIntraTransactionLatency"`
 
+	// InterTransJitter is the arrival model of the delays between two
+	// transactions, which are in [MinInterval, MaxInterval].
+	InterTransJitter *Jitter `json:"interTransJitter"`
+
+	// IntraTransJitter is the arrival model of the delays between two logs in
+	// the same transaction, which are in [0, MaxIntraTransactionLatency).
+	IntraTransJitter *Jitter `json:"intraTransJitter"`
+
 	// Output defines the output destinations of the logs, which may be the console,
 	// files or some message queues
 	Output map[string]output.Wrapper `json:"output"`
@@ -75,6 +132,47 @@ type SpoutConfig struct {
 	Replacement json.RawMessage `json:"replacement"`
 }
 
+// Pacing defines how the events are kept on their schedule. The events paced
+// by a rate (EPS, BytesPerSecond or GBPerDay) are always on a schedule, while
+// the ones paced by the think times are only if Schedule is set.
+type Pacing struct {
+	// Schedule gives each event an intended time, which is the one of the
+	// previous event plus the think time, rather than sleeping for the think
+	// time after the previous event is written. So the time taken to generate
+	// and write the events doesn't lower the rate, and how late the events are
+	// is measured.
+	Schedule bool `json:"schedule"`
+
+	// MaxLag is how far in milliseconds the events may fall behind their
+	// schedule and still be caught up with, i.e., generated without delays
+	// until they are on schedule again. The schedule is moved forward when
+	// they fall further behind, and the lag given up is counted as skipped.
+	// It's 1000 by default, and -1 means never catching up.
+	MaxLag int `json:"maxLag"`
+}
+
+// Shutdown defines how logspout is shut down by a signal, through the console
+// or when the duration elapses. The workers are stopped first, then the events
+// buffered by the outputs are flushed before the outputs are closed.
+type Shutdown struct {
+	// DrainTimeout is how long in seconds the workers are waited for, after
+	// which the ones still running are abandoned. It's 30 by default, and -1
+	// means waiting until they exit.
+	DrainTimeout int `json:"drainTimeout"`
+
+	// FinishTransactions lets the workers finish the transactions they are
+	// in the middle of, otherwise they exit after the events being written.
+	FinishTransactions bool `json:"finishTransactions"`
+}
+
+// Jitter is the arrival model of the delays between the events and its
+// attributes, e.g., {"type": "pareto", "attrs": {"alpha": 1.5}}. The models
+// are defined in the spout package.
+type Jitter struct {
+	Type  string          `json:"type"`
+	Attrs json.RawMessage `json:"attrs"`
+}
+
 var (
 	errInputIsNil = errors.New("input data is nil")
 )
@@ -3,7 +3,6 @@ package console
 import (
 	"net/http"
 	"testing"
-	"time"
 
 	"github.com/jiwen624/logspout/metrics"
 
@@ -12,22 +11,19 @@ import (
 
 func TestStart(t *testing.T) {
 	// Test valid host
-	url := "localhost:10300"
-	Start(url)
-	time.Sleep(time.Millisecond * 10)
+	s, err := NewServer(Config{}, "127.0.0.1:0")
+	assert.Nil(t, err)
+	metrics.Register(s)
+	assert.Nil(t, s.Start())
+	defer s.Close()
 
-	nc := &http.Client{
-		Timeout: time.Second * 2,
-	}
-	rsp, err := nc.Get("http://" + url + metrics.EndPoint)
+	rsp, err := http.Get(s.String() + metrics.EndPoint)
 	assert.Nil(t, err)
+	defer rsp.Body.Close()
 	assert.Equal(t, 200, rsp.StatusCode)
 
 	// bad url
-	badUrl := "localhost:100"
-	Start(badUrl)
-	time.Sleep(time.Millisecond * 10)
-
-	rsp, err = nc.Get("http://" + badUrl + metrics.EndPoint)
-	assert.NotNil(t, err)
+	s, err = NewServer(Config{}, "localhost:-1")
+	assert.Nil(t, err)
+	assert.NotNil(t, s.Start())
 }
@@ -0,0 +1,309 @@
+package console
+
+import (
+	"net/http"
+)
+
+// DashboardEndPoint is where the web dashboard is served. The dashboard is a
+// single page embedded in the binary, which polls the metrics and the control
+// API of the console.
+const DashboardEndPoint = "/"
+
+// dashboardHandler serves the dashboard. As it's registered to "/", any path
+// not handled by the other handlers ends up here and is not found.
+func dashboardHandler(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != DashboardEndPoint {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Write([]byte(dashboardHTML))
+}
+
+// dashboardHTML is the dashboard page. It doesn't load anything from outside
+// so that it works without the internet access.
+const dashboardHTML = `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Logspout</title>
+<style>
+  body { font-family: -apple-system, "Segoe UI", Helvetica, Arial, sans-serif; margin: 0; background: #f5f6f8; color: #222; }
+  header { background: #24292e; color: #fff; padding: 12px 20px; display: flex; align-items: center; gap: 16px; }
+  header h1 { font-size: 18px; margin: 0; }
+  .badge { padding: 2px 10px; border-radius: 10px; font-size: 13px; background: #6a737d; }
+  .badge.running { background: #28a745; }
+  .badge.paused { background: #dbab09; }
+  .badge.stopped, .badge.closed, .badge.offline { background: #cb2431; }
+  main { padding: 16px 20px; display: grid; grid-template-columns: repeat(auto-fit, minmax(420px, 1fr)); gap: 16px; }
+  section { background: #fff; border: 1px solid #e1e4e8; border-radius: 6px; padding: 12px 16px; }
+  section.wide { grid-column: 1 / -1; }
+  h2 { font-size: 15px; margin: 0 0 10px; }
+  .stats { display: flex; gap: 24px; flex-wrap: wrap; }
+  .stat .v { font-size: 22px; font-weight: 600; }
+  .stat .k { font-size: 12px; color: #586069; }
+  table { border-collapse: collapse; width: 100%; font-size: 13px; }
+  th, td { text-align: right; padding: 4px 8px; border-bottom: 1px solid #eaecef; }
+  th:first-child, td:first-child { text-align: left; word-break: break-all; }
+  td.err { color: #cb2431; font-weight: 600; }
+  button { padding: 4px 12px; margin-right: 6px; cursor: pointer; }
+  input { width: 80px; padding: 3px; }
+  input.wide { width: 260px; }
+  label { font-size: 13px; margin-right: 10px; }
+  pre { font-size: 12px; max-height: 320px; overflow: auto; background: #f6f8fa; padding: 8px; margin: 0; }
+  #tail { height: 320px; white-space: pre-wrap; word-break: break-all; }
+  #message { font-size: 13px; color: #cb2431; margin-left: 8px; }
+</style>
+</head>
+<body>
+<header>
+  <h1>Logspout</h1>
+  <span id="state" class="badge">loading</span>
+  <span id="version"></span>
+</header>
+<main>
+  <section class="wide">
+    <div class="stats">
+      <div class="stat"><div class="v" id="rate">-</div><div class="k">events/s</div></div>
+      <div class="stat"><div class="v" id="configuredRate">-</div><div class="k">configured events/s</div></div>
+      <div class="stat"><div class="v" id="events">-</div><div class="k">events</div></div>
+      <div class="stat"><div class="v" id="errors">-</div><div class="k">output errors</div></div>
+      <div class="stat"><div class="v" id="workers">-</div><div class="k">workers</div></div>
+      <div class="stat"><div class="v" id="uptime">-</div><div class="k">uptime</div></div>
+    </div>
+  </section>
+
+  <section>
+    <h2>Controls</h2>
+    <p>
+      <button onclick="post('pause')">Pause</button>
+      <button onclick="post('resume')">Resume</button>
+      <button onclick="if (confirm('Stop the generation?')) post('stop')">Stop</button>
+    </p>
+    <p>
+      <label>Concurrency <input id="concurrency" type="number" min="1"></label>
+      <label>Min interval (ms) <input id="minInterval" type="number" min="0"></label>
+      <label>Max interval (ms) <input id="maxInterval" type="number" min="0"></label>
+      <label>EPS (0 = unlimited) <input id="eps" type="number" min="0" step="any"></label>
+      <label>Bytes/s (0 = unlimited) <input id="bytesPerSecond" type="number" min="0" step="any"></label>
+      <button onclick="apply()">Apply</button>
+      <span id="message"></span>
+    </p>
+  </section>
+
+  <section>
+    <h2>Workers</h2>
+    <table><thead><tr><th>Worker</th><th>Events/s</th></tr></thead><tbody id="workerRows"></tbody></table>
+  </section>
+
+  <section class="wide">
+    <h2>Outputs</h2>
+    <table>
+      <thead><tr><th>Output</th><th>Events/s</th><th>Events</th><th>Bytes</th><th>Errors</th><th>Retries</th><th>Drops</th><th>p99 latency</th></tr></thead>
+      <tbody id="outputRows"></tbody>
+    </table>
+  </section>
+
+  <section class="wide">
+    <h2>Live events</h2>
+    <p>
+      <label>Sample <input id="sample" type="number" min="0.0001" max="1" step="any" value="0.01"></label>
+      <label>Filters <input id="filters" class="wide" placeholder="e.g. seed=0&amp;user=bob"></label>
+      <button id="tailButton" onclick="toggleTail()">Start</button>
+    </p>
+    <pre id="tail"></pre>
+  </section>
+
+  <section class="wide">
+    <h2>Configuration</h2>
+    <pre id="config"></pre>
+  </section>
+</main>
+<script>
+var api = '/api/v1/';
+var last = {};
+var lastConfig = {};
+var tailSource = null;
+var maxTailLines = 200;
+
+function $(id) { return document.getElementById(id); }
+
+function text(id, v) { $(id).textContent = v; }
+
+function fmt(n) {
+  if (n >= 1e9) return (n / 1e9).toFixed(2) + 'G';
+  if (n >= 1e6) return (n / 1e6).toFixed(2) + 'M';
+  if (n >= 1e4) return (n / 1e3).toFixed(1) + 'k';
+  return Math.round(n).toString();
+}
+
+function duration(s) {
+  s = Math.floor(s);
+  var h = Math.floor(s / 3600), m = Math.floor(s % 3600 / 60);
+  return (h ? h + 'h ' : '') + (h || m ? m + 'm ' : '') + s % 60 + 's';
+}
+
+function latency(s) {
+  return s >= 1 ? s.toFixed(2) + 's' : (s * 1000).toFixed(2) + 'ms';
+}
+
+function cell(tr, v, cls) {
+  var td = document.createElement('td');
+  td.textContent = v;
+  if (cls) td.className = cls;
+  tr.appendChild(td);
+}
+
+function getJSON(path) {
+  return fetch(path, {cache: 'no-store'}).then(function (r) {
+    if (!r.ok) throw new Error(r.status + ' ' + r.statusText);
+    return r.json();
+  });
+}
+
+function send(method, path, body) {
+  text('message', '');
+  return fetch(path, {method: method, body: body ? JSON.stringify(body) : undefined})
+    .then(function (r) {
+      return r.json().then(function (v) {
+        if (!r.ok) throw new Error(v.error || r.statusText);
+        return v;
+      });
+    })
+    .catch(function (e) { text('message', e.message); });
+}
+
+function post(action) {
+  send('POST', api + action).then(refresh);
+}
+
+function apply() {
+  var body = {};
+  ['concurrency', 'minInterval', 'maxInterval'].forEach(function (k) {
+    var v = $(k).value;
+    if (v !== '') body[k] = parseInt(v, 10);
+  });
+  // The rate is either in events or in bytes per second, the one changed
+  // is sent as setting it clears the other.
+  ['eps', 'bytesPerSecond'].forEach(function (k) {
+    var v = $(k).value;
+    if (v !== '' && parseFloat(v) !== lastConfig[k]) body[k] = parseFloat(v);
+  });
+  send('PATCH', api + 'config', body).then(function (c) {
+    if (c) showConfig(c);
+    refresh();
+  });
+}
+
+function showConfig(c) {
+  text('config', JSON.stringify(c, null, 2));
+  lastConfig = c;
+  ['concurrency', 'minInterval', 'maxInterval', 'eps', 'bytesPerSecond'].forEach(function (k) {
+    if (document.activeElement !== $(k)) $(k).value = c[k];
+  });
+}
+
+function showStatus(s) {
+  var badge = $('state');
+  badge.textContent = s.state;
+  badge.className = 'badge ' + s.state;
+  text('version', s.version ? 'v' + s.version + (s.commit ? '+' + s.commit : '') : '');
+  text('rate', fmt(s.rate));
+  text('configuredRate', s.configuredRate ? fmt(s.configuredRate) : 'unlimited');
+  text('events', fmt(s.events));
+  text('workers', s.workers);
+  text('uptime', duration(s.uptime));
+}
+
+function showWorkers(tps) {
+  var rows = $('workerRows');
+  rows.innerHTML = '';
+  Object.keys(tps).filter(function (k) { return k !== 'Total'; }).sort(function (a, b) {
+    return a.localeCompare(b, undefined, {numeric: true});
+  }).forEach(function (k) {
+    var tr = document.createElement('tr');
+    cell(tr, k);
+    cell(tr, fmt(tps[k]));
+    rows.appendChild(tr);
+  });
+}
+
+function showOutputs(outputs, now) {
+  var rows = $('outputRows');
+  var errors = 0;
+  rows.innerHTML = '';
+  Object.keys(outputs).sort().forEach(function (k) {
+    var o = outputs[k], prev = last[k], rate = 0;
+    if (prev && now > prev.time) rate = (o.events - prev.events) * 1000 / (now - prev.time);
+    last[k] = {events: o.events, time: now};
+    errors += o.errors;
+
+    var tr = document.createElement('tr');
+    cell(tr, k);
+    cell(tr, fmt(rate));
+    cell(tr, fmt(o.events));
+    cell(tr, fmt(o.bytes));
+    cell(tr, fmt(o.errors), o.errors ? 'err' : '');
+    cell(tr, fmt(o.retries));
+    cell(tr, fmt(o.drops), o.drops ? 'err' : '');
+    cell(tr, o.latency.count ? latency(o.latency.p99) : '-');
+    rows.appendChild(tr);
+  });
+  text('errors', fmt(errors));
+}
+
+function refresh() {
+  var now = Date.now();
+  getJSON(api + 'status').then(showStatus).catch(function () {
+    $('state').textContent = 'offline';
+    $('state').className = 'badge offline';
+  });
+  getJSON('/metrics/tps').then(showWorkers).catch(function () {});
+  getJSON('/metrics/outputs').then(function (o) { showOutputs(o, now); }).catch(function () {});
+}
+
+function toggleTail() {
+  if (tailSource) {
+    tailSource.close();
+    tailSource = null;
+    text('tailButton', 'Start');
+    return;
+  }
+  var q = 'format=raw&sample=' + encodeURIComponent($('sample').value || '1');
+  var filters = $('filters').value.trim();
+  if (filters) q += '&' + filters;
+
+  var pre = $('tail');
+  pre.textContent = '';
+  function append(line) {
+    pre.appendChild(document.createTextNode(line + '\n'));
+    while (pre.childNodes.length > maxTailLines) pre.removeChild(pre.firstChild);
+    pre.scrollTop = pre.scrollHeight;
+  }
+  tailSource = new EventSource(api + 'tail?' + q);
+  tailSource.onmessage = function (e) { append(e.data); };
+  tailSource.addEventListener('skipped', function (e) { append('... ' + e.data + ' events skipped ...'); });
+  tailSource.onerror = function () {
+    if (tailSource && tailSource.readyState === EventSource.CLOSED) {
+      tailSource = null;
+      text('tailButton', 'Start');
+    }
+  };
+  text('tailButton', 'Stop');
+}
+
+getJSON(api + 'config').then(showConfig).catch(function (e) { text('config', e.message); });
+refresh();
+setInterval(refresh, 1000);
+</script>
+</body>
+</html>
+`
@@ -0,0 +1,25 @@
+package console
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// WriteJSON writes the value as the JSON body of the response with the status
+// code.
+func WriteJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// WriteError writes the error as the JSON body of the response, which is
+// {"error": "the error message"}, with the status code.
+func WriteError(w http.ResponseWriter, status int, err error) {
+	WriteJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+// WriteStatus writes the text of the status code as the error.
+func WriteStatus(w http.ResponseWriter, status int) {
+	WriteJSON(w, status, map[string]string{"error": http.StatusText(status)})
+}
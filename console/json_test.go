@@ -0,0 +1,23 @@
+package console
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteError(t *testing.T) {
+	w := httptest.NewRecorder()
+	WriteError(w, http.StatusConflict, errors.New("busy"))
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Equal(t, "application/json; charset=utf-8", w.Header().Get("Content-Type"))
+	assert.Equal(t, "{\"error\":\"busy\"}\n", w.Body.String())
+
+	w = httptest.NewRecorder()
+	WriteStatus(w, http.StatusNotFound)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, "{\"error\":\"Not Found\"}\n", w.Body.String())
+}
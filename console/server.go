@@ -0,0 +1,258 @@
+package console
+
+import (
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/jiwen624/logspout/log"
+)
+
+// Config is the configuration of the management console.
+type Config struct {
+	// Disabled disables the console entirely.
+	Disabled bool `json:"disabled"`
+
+	// Addr is the address the console binds to, e.g., 0.0.0.0:10306 to be
+	// reachable from outside a container. It's localhost and the console port
+	// of the spout by default.
+	Addr string `json:"addr"`
+
+	// AuthToken is the bearer token the requests must carry in the
+	// Authorization header if it's set.
+	AuthToken string `json:"authToken"`
+
+	// BasicAuth is the user and password the requests must carry if it's set,
+	// which also works for the dashboard in a browser. If both AuthToken and
+	// BasicAuth are set, either of them is accepted.
+	BasicAuth *BasicAuth `json:"basicAuth"`
+
+	// TLS serves the console over HTTPS if it's set.
+	TLS *TLSConfig `json:"tls"`
+}
+
+// BasicAuth is the credential of the HTTP basic authentication.
+type BasicAuth struct {
+	User     string `json:"user"`
+	Password string `json:"password"`
+}
+
+// TLSConfig is the certificate of the console and optionally the CA to verify
+// the client certificates with (mTLS).
+type TLSConfig struct {
+	CertFile string `json:"certFile"`
+	KeyFile  string `json:"keyFile"`
+	// ClientCAFile is the PEM file of the CAs, a client must present a
+	// certificate signed by one of them if it's set.
+	ClientCAFile string `json:"clientCAFile"`
+}
+
+// the value of the redacted secrets
+const redacted = "******"
+
+// Redacted returns a copy of the config with the secrets redacted, which can
+// be exposed.
+func (c Config) Redacted() Config {
+	if c.AuthToken != "" {
+		c.AuthToken = redacted
+	}
+	if c.BasicAuth != nil {
+		ba := *c.BasicAuth
+		if ba.Password != "" {
+			ba.Password = redacted
+		}
+		c.BasicAuth = &ba
+	}
+	return c
+}
+
+// authEnabled tells if the requests need to be authenticated.
+func (c Config) authEnabled() bool {
+	return c.AuthToken != "" || c.BasicAuth != nil
+}
+
+var (
+	errNoCert        = errors.New("both certFile and keyFile are required for TLS")
+	errBadClientCA   = errors.New("no certificate found in the client CA file")
+	errEmptyUser     = errors.New("the user of basic auth is empty")
+	errAlreadyServed = errors.New("console is already started")
+)
+
+// Server is a management console with its own mux, the handlers registered to
+// it are protected by the authentication configured.
+type Server struct {
+	conf Config
+	mux  *http.ServeMux
+	srv  *http.Server
+	addr net.Addr
+}
+
+// NewServer creates a console with the dashboard registered. The address is
+// used if Addr is not set in the config.
+func NewServer(conf Config, addr string) (*Server, error) {
+	if conf.Addr == "" {
+		conf.Addr = addr
+	}
+	if conf.BasicAuth != nil && conf.BasicAuth.User == "" {
+		return nil, errEmptyUser
+	}
+	if conf.TLS != nil && (conf.TLS.CertFile == "" || conf.TLS.KeyFile == "") {
+		return nil, errNoCert
+	}
+
+	s := &Server{conf: conf, mux: http.NewServeMux()}
+	s.HandleFunc(DashboardEndPoint, dashboardHandler)
+	return s, nil
+}
+
+// Handle registers the handler for the given pattern to the console.
+func (s *Server) Handle(pattern string, handler http.Handler) {
+	s.mux.Handle(pattern, handler)
+}
+
+// HandleFunc registers the handler function for the given pattern to the
+// console.
+func (s *Server) HandleFunc(pattern string, handler func(http.ResponseWriter, *http.Request)) {
+	s.mux.HandleFunc(pattern, handler)
+}
+
+// ServeHTTP authenticates the request and dispatches it to the handlers.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if s.conf.authEnabled() && !s.authenticated(r) {
+		if s.conf.BasicAuth != nil {
+			w.Header().Set("WWW-Authenticate", `Basic realm="logspout"`)
+		} else {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="logspout"`)
+		}
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+	s.mux.ServeHTTP(w, r)
+}
+
+// authenticated tells if the request carries a valid credential.
+func (s *Server) authenticated(r *http.Request) bool {
+	if tok := s.conf.AuthToken; tok != "" {
+		h := r.Header.Get("Authorization")
+		if strings.HasPrefix(h, "Bearer ") && secureEqual(strings.TrimPrefix(h, "Bearer "), tok) {
+			return true
+		}
+	}
+	if ba := s.conf.BasicAuth; ba != nil {
+		user, password, ok := r.BasicAuth()
+		// both are compared so that the time doesn't tell which is wrong
+		userOK := secureEqual(user, ba.User)
+		passwordOK := secureEqual(password, ba.Password)
+		if ok && userOK && passwordOK {
+			return true
+		}
+	}
+	return false
+}
+
+func secureEqual(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
+// tlsConfig loads the certificates.
+func (c *TLSConfig) tlsConfig() (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+	if err != nil {
+		return nil, errors.Wrap(err, "load certificate")
+	}
+	tc := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if c.ClientCAFile != "" {
+		pem, err := ioutil.ReadFile(c.ClientCAFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "load client CA")
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errBadClientCA
+		}
+		tc.ClientCAs = pool
+		tc.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return tc, nil
+}
+
+// Start binds the address and serves the console in a standalone goroutine.
+// The errors of binding and loading the certificates are returned.
+func (s *Server) Start() error {
+	if s.srv != nil {
+		return errAlreadyServed
+	}
+
+	var tc *tls.Config
+	if s.conf.TLS != nil {
+		var err error
+		if tc, err = s.conf.TLS.tlsConfig(); err != nil {
+			return errors.Wrap(err, "start console")
+		}
+	}
+
+	l, err := net.Listen("tcp", s.conf.Addr)
+	if err != nil {
+		return errors.Wrap(err, "start console")
+	}
+	if tc != nil {
+		l = tls.NewListener(l, tc)
+	}
+	s.addr = l.Addr()
+	s.srv = &http.Server{Handler: s}
+
+	if !s.conf.authEnabled() && !isLoopback(s.addr) {
+		log.Warnf("The console on %s is reachable from other hosts without authentication.", s.addr)
+	}
+	log.Infof("Starting up the console on %s.", s)
+
+	go func() {
+		if err := s.srv.Serve(l); err != nil && err != http.ErrServerClosed {
+			log.Error("console serve: ", err)
+		}
+	}()
+	return nil
+}
+
+// Close stops serving the console immediately.
+func (s *Server) Close() error {
+	if s.srv == nil {
+		return nil
+	}
+	return s.srv.Close()
+}
+
+// Addr returns the address the console listens on, it's nil before the
+// console is started.
+func (s *Server) Addr() net.Addr {
+	return s.addr
+}
+
+func (s *Server) String() string {
+	scheme := "http"
+	if s.conf.TLS != nil {
+		scheme = "https"
+	}
+	addr := s.conf.Addr
+	if s.addr != nil {
+		addr = s.addr.String()
+	}
+	return fmt.Sprintf("%s://%s", scheme, addr)
+}
+
+// isLoopback tells if the address is only reachable from the local host.
+func isLoopback(addr net.Addr) bool {
+	ta, ok := addr.(*net.TCPAddr)
+	return ok && ta.IP.IsLoopback()
+}
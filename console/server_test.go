@@ -0,0 +1,208 @@
+package console
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func okHandler(w http.ResponseWriter, r *http.Request) {
+	w.Write([]byte("ok"))
+}
+
+func TestServerAuth(t *testing.T) {
+	s, err := NewServer(Config{
+		AuthToken: "secret",
+		BasicAuth: &BasicAuth{User: "admin", Password: "pass"},
+	}, "localhost:0")
+	assert.Nil(t, err)
+	s.HandleFunc("/ok", okHandler)
+
+	tests := []struct {
+		name string
+		set  func(r *http.Request)
+		code int
+	}{
+		{"none", func(r *http.Request) {}, http.StatusUnauthorized},
+		{"bearer", func(r *http.Request) { r.Header.Set("Authorization", "Bearer secret") }, http.StatusOK},
+		{"bad bearer", func(r *http.Request) { r.Header.Set("Authorization", "Bearer wrong") }, http.StatusUnauthorized},
+		{"basic", func(r *http.Request) { r.SetBasicAuth("admin", "pass") }, http.StatusOK},
+		{"bad user", func(r *http.Request) { r.SetBasicAuth("root", "pass") }, http.StatusUnauthorized},
+		{"bad password", func(r *http.Request) { r.SetBasicAuth("admin", "wrong") }, http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/ok", nil)
+		tt.set(r)
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		assert.Equal(t, tt.code, w.Code, tt.name)
+		if tt.code == http.StatusUnauthorized {
+			assert.Equal(t, `Basic realm="logspout"`, w.Header().Get("WWW-Authenticate"), tt.name)
+		}
+	}
+
+	// the dashboard is protected as well
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, DashboardEndPoint, nil))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestNewServerBadConfig(t *testing.T) {
+	_, err := NewServer(Config{BasicAuth: &BasicAuth{Password: "pass"}}, "localhost:0")
+	assert.Equal(t, errEmptyUser, err)
+
+	_, err = NewServer(Config{TLS: &TLSConfig{CertFile: "cert.pem"}}, "localhost:0")
+	assert.Equal(t, errNoCert, err)
+
+	s, err := NewServer(Config{TLS: &TLSConfig{CertFile: "nonexist.pem", KeyFile: "nonexist.pem"}}, "localhost:0")
+	assert.Nil(t, err)
+	assert.NotNil(t, s.Start())
+}
+
+func TestServerRedacted(t *testing.T) {
+	c := Config{AuthToken: "secret", BasicAuth: &BasicAuth{User: "admin", Password: "pass"}}
+	r := c.Redacted()
+	assert.Equal(t, redacted, r.AuthToken)
+	assert.Equal(t, "admin", r.BasicAuth.User)
+	assert.Equal(t, redacted, r.BasicAuth.Password)
+	// the original is untouched
+	assert.Equal(t, "pass", c.BasicAuth.Password)
+}
+
+func TestServerStart(t *testing.T) {
+	s, err := NewServer(Config{Addr: "127.0.0.1:0"}, "localhost:10306")
+	assert.Nil(t, err)
+	s.HandleFunc("/ok", okHandler)
+	assert.Nil(t, s.Start())
+	defer s.Close()
+	assert.Equal(t, errAlreadyServed, s.Start())
+
+	rsp, err := http.Get(s.String() + "/ok")
+	assert.Nil(t, err)
+	defer rsp.Body.Close()
+	assert.Equal(t, http.StatusOK, rsp.StatusCode)
+}
+
+// testCert is a certificate and its key written to PEM files.
+type testCert struct {
+	cert     *x509.Certificate
+	key      *ecdsa.PrivateKey
+	certFile string
+	keyFile  string
+}
+
+// newTestCert creates a certificate signed by the parent, it's self-signed if
+// the parent is nil.
+func newTestCert(t *testing.T, dir, name string, parent *testCert) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	signer, signerKey := tmpl, key
+	if parent == nil {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	assert.Nil(t, err)
+	cert, err := x509.ParseCertificate(der)
+	assert.Nil(t, err)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	assert.Nil(t, err)
+
+	c := &testCert{
+		cert:     cert,
+		key:      key,
+		certFile: filepath.Join(dir, name+".pem"),
+		keyFile:  filepath.Join(dir, name+"-key.pem"),
+	}
+	assert.Nil(t, ioutil.WriteFile(c.certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	assert.Nil(t, ioutil.WriteFile(c.keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600))
+	return c
+}
+
+func (c *testCert) tlsCert(t *testing.T) tls.Certificate {
+	tc, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	assert.Nil(t, err)
+	return tc
+}
+
+func TestServerTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "console")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	ca := newTestCert(t, dir, "ca", nil)
+	server := newTestCert(t, dir, "server", ca)
+	client := newTestCert(t, dir, "client", ca)
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+
+	s, err := NewServer(Config{
+		Addr: "127.0.0.1:0",
+		TLS: &TLSConfig{
+			CertFile:     server.certFile,
+			KeyFile:      server.keyFile,
+			ClientCAFile: ca.certFile,
+		},
+	}, "")
+	assert.Nil(t, err)
+	s.HandleFunc("/ok", okHandler)
+	assert.Nil(t, s.Start())
+	defer s.Close()
+	assert.Equal(t, "https://"+s.Addr().String(), s.String())
+
+	get := func(certs ...tls.Certificate) (*http.Response, error) {
+		c := &http.Client{
+			Timeout: 5 * time.Second,
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{RootCAs: roots, Certificates: certs},
+			},
+		}
+		return c.Get(s.String() + "/ok")
+	}
+
+	// a client without a certificate is rejected
+	_, err = get()
+	assert.NotNil(t, err)
+
+	rsp, err := get(client.tlsCert(t))
+	assert.Nil(t, err)
+	if err == nil {
+		defer rsp.Body.Close()
+		assert.Equal(t, http.StatusOK, rsp.StatusCode)
+	}
+
+	// a bad client CA file
+	s, err = NewServer(Config{
+		Addr: "127.0.0.1:0",
+		TLS:  &TLSConfig{CertFile: server.certFile, KeyFile: server.keyFile, ClientCAFile: server.keyFile},
+	}, "")
+	assert.Nil(t, err)
+	assert.NotNil(t, s.Start())
+}
@@ -12,6 +12,17 @@ var (
 
 	// LogMode can be either dev (development mode) or prod (production)
 	LogMode string
+
+	// TUI shows the terminal dashboard instead of the logs
+	TUI bool
+
+	// ReportPath is the file the end-of-run report is written to in json
+	// format, the report is only printed if it's empty
+	ReportPath string
+
+	// Watch reloads the configuration when the config file, the sample file
+	// or the list files are changed
+	Watch bool
 )
 
 func init() {
@@ -21,5 +32,11 @@ func init() {
 		"debug, info, warn, error.")
 	flag.StringVar(&LogMode, "log", "dev",
 		"specify the log mode. it can be either dev or prod.")
+	flag.BoolVar(&TUI, "tui", false,
+		"show a full-screen terminal dashboard instead of the logs.")
+	flag.StringVar(&ReportPath, "report", "",
+		"write the end-of-run report to the file in json format.")
+	flag.BoolVar(&Watch, "watch", false,
+		"watch the config, sample and list files and apply the changes live.")
 	flag.Parse()
 }
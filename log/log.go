@@ -3,6 +3,7 @@ package log
 
 import (
 	"fmt"
+	"io"
 	"os"
 	"strings"
 
@@ -99,6 +100,19 @@ func SetLevel(level string) error {
 	return nil
 }
 
+// SetOutput writes the logs to w rather than stderr, with the same encoding and
+// level. It must be called before the logs are written concurrently.
+func SetOutput(w io.Writer) {
+	var enc zapcore.Encoder
+	if lgrCfg.Encoding == "json" {
+		enc = zapcore.NewJSONEncoder(lgrCfg.EncoderConfig)
+	} else {
+		enc = zapcore.NewConsoleEncoder(lgrCfg.EncoderConfig)
+	}
+	core := zapcore.NewCore(enc, zapcore.Lock(zapcore.AddSync(w)), lgrCfg.Level)
+	sugar = *zap.New(core, zap.AddCaller(), zap.AddCallerSkip(1)).Sugar()
+}
+
 func GetLevel() Level {
 	return Level(lgrCfg.Level.Level())
 }
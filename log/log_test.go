@@ -1,6 +1,8 @@
 package log
 
 import (
+	"bytes"
+	"os"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -40,3 +42,15 @@ func TestToLevel(t *testing.T) {
 	assert.NotNil(t, err)
 	assert.Equal(t, DEBUG, l)
 }
+
+func TestSetOutput(t *testing.T) {
+	var b bytes.Buffer
+	SetOutput(&b)
+	defer SetOutput(os.Stderr)
+
+	assert.Nil(t, SetLevel("info"))
+	Info("hello")
+	Debug("invisible")
+	assert.Contains(t, b.String(), "hello")
+	assert.NotContains(t, b.String(), "invisible")
+}
@@ -4,10 +4,13 @@
 package main
 
 import (
+	"os"
+
 	"github.com/jiwen624/logspout/config"
 	"github.com/jiwen624/logspout/flag"
 	"github.com/jiwen624/logspout/log"
 	"github.com/jiwen624/logspout/spout"
+	"github.com/jiwen624/logspout/tui"
 	"github.com/jiwen624/logspout/utils"
 )
 
@@ -25,10 +28,30 @@ func main() {
 
 	spt, err := spout.Build(conf)
 	utils.ExitOnErr(errFailedInMain, err)
+	spt.ConfigPath = flag.ConfigPath
+	if flag.Watch {
+		utils.ExitOnErr(errFailedInMain, spt.Watch())
+	}
+
+	var ui *tui.UI
+	if flag.TUI {
+		ui = tui.New(spt)
+		utils.ExitOnErr(errFailedInMain, ui.Start())
+	}
 
 	// It will block here if no error happens
-	utils.ExitOnErr(errFailedInMain, spt.Start())
+	err = spt.Start()
+	if ui != nil {
+		ui.Close()
+	}
+	utils.ExitOnErr(errFailedInMain, err)
 
 	spt.Stop()
+
+	report := spt.Report()
+	utils.ExitOnErr(errFailedInMain, report.WriteText(os.Stderr))
+	if flag.ReportPath != "" {
+		utils.ExitOnErr(errFailedInMain, report.WriteFile(flag.ReportPath))
+	}
 	log.Info("Logspout is stopped. Bye.")
 }
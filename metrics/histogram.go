@@ -0,0 +1,115 @@
+package metrics
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// LatencyBounds are the upper bounds in seconds of the buckets of the latency
+// histograms, from 50 microseconds to 10 seconds.
+var LatencyBounds = []float64{
+	0.00005, 0.0001, 0.00025, 0.0005,
+	0.001, 0.0025, 0.005, 0.01, 0.025, 0.05,
+	0.1, 0.25, 0.5, 1, 2.5, 5, 10,
+}
+
+// Histogram counts the observed durations in buckets with fixed upper bounds.
+// It's safe to be observed concurrently.
+type Histogram struct {
+	bounds []float64
+	// counts[i] is the number of observations in (bounds[i-1], bounds[i]], the
+	// last one is for the observations greater than all the bounds.
+	counts []uint64
+	count  uint64
+	// the sum of all the observations in nanoseconds
+	sum uint64
+}
+
+// Bucket is a bucket of a histogram snapshot.
+type Bucket struct {
+	// UpperBound is the upper bound of the bucket in seconds
+	UpperBound float64 `json:"le"`
+	// Count is the cumulative number of the observations less than or equal
+	// to the upper bound.
+	Count uint64 `json:"count"`
+}
+
+// HistogramSnapshot is a point-in-time view of a histogram.
+type HistogramSnapshot struct {
+	Count uint64 `json:"count"`
+	// Sum is the sum of all the observations in seconds
+	Sum float64 `json:"sum"`
+	// The estimated quantiles in seconds
+	P50 float64 `json:"p50"`
+	P90 float64 `json:"p90"`
+	P99 float64 `json:"p99"`
+	// Buckets are the cumulative buckets, the implicit +Inf bucket is omitted
+	// as its count is Count.
+	Buckets []Bucket `json:"buckets"`
+}
+
+// NewHistogram creates a histogram with the upper bounds in seconds, which
+// must be sorted in increasing order.
+func NewHistogram(bounds []float64) *Histogram {
+	return &Histogram{
+		bounds: bounds,
+		counts: make([]uint64, len(bounds)+1),
+	}
+}
+
+// Observe records a duration.
+func (h *Histogram) Observe(d time.Duration) {
+	s := d.Seconds()
+	i := 0
+	for i < len(h.bounds) && s > h.bounds[i] {
+		i++
+	}
+	atomic.AddUint64(&h.counts[i], 1)
+	atomic.AddUint64(&h.count, 1)
+	atomic.AddUint64(&h.sum, uint64(d))
+}
+
+// Snapshot takes a snapshot of the histogram. The observations made while the
+// snapshot is being taken may be partially included.
+func (h *Histogram) Snapshot() HistogramSnapshot {
+	hs := HistogramSnapshot{
+		Buckets: make([]Bucket, len(h.bounds)),
+	}
+
+	var cum uint64
+	for i, b := range h.bounds {
+		cum += atomic.LoadUint64(&h.counts[i])
+		hs.Buckets[i] = Bucket{UpperBound: b, Count: cum}
+	}
+	hs.Count = cum + atomic.LoadUint64(&h.counts[len(h.bounds)])
+	hs.Sum = time.Duration(atomic.LoadUint64(&h.sum)).Seconds()
+
+	hs.P50 = hs.quantile(0.5)
+	hs.P90 = hs.quantile(0.9)
+	hs.P99 = hs.quantile(0.99)
+	return hs
+}
+
+// quantile estimates the quantile by linear interpolation within the bucket it
+// falls in. The quantiles in the +Inf bucket are reported as the largest upper
+// bound.
+func (hs HistogramSnapshot) quantile(q float64) float64 {
+	if hs.Count == 0 || len(hs.Buckets) == 0 {
+		return 0
+	}
+	rank := q * float64(hs.Count)
+
+	var lower float64
+	var prev uint64
+	for _, b := range hs.Buckets {
+		if float64(b.Count) >= rank {
+			n := b.Count - prev
+			if n == 0 {
+				return b.UpperBound
+			}
+			return lower + (b.UpperBound-lower)*(rank-float64(prev))/float64(n)
+		}
+		lower, prev = b.UpperBound, b.Count
+	}
+	return lower
+}
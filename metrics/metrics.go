@@ -1,7 +1,7 @@
 // Package metrics contains the methods and functions of recording metrics, e.g.,
-// TPS (transaction per second). The data recorded in this package can be offered
-// to the management console which then exposes it through a particular HTTP
-// endpoint.
+// TPS (transaction per second), the counters and the write latencies of the
+// outputs. The data recorded in this package can be offered to the management
+// console which then exposes it through particular HTTP endpoints.
 package metrics
 
 import (
@@ -19,25 +19,50 @@ const (
 
 var (
 	tps *expvar.Map
+	// the number of events generated by each worker
+	events *expvar.Map
+	// the configured rate in events per second
+	configuredRate *expvar.Float
+	// the number of the workers running
+	workers *expvar.Int
 )
 
 func init() {
 	initCounters()
-	registerHandlers()
 }
 
 func initCounters() {
 	// Don't publish it to debug/vars
 	tps = &expvar.Map{}
 	tps.Init()
+	events = &expvar.Map{}
+	events.Init()
+	configuredRate = &expvar.Float{}
+	workers = &expvar.Int{}
+	outputs = &expvar.Map{}
+	outputs.Init()
+	lateness = NewHistogram(LatencyBounds)
+	writes = NewHistogram(LatencyBounds)
+	skipped = &expvar.Float{}
 }
 
-func registerHandlers() {
-	registerHandler("/metrics/tps", tpsHandler)
+// Mux is where the handlers of the metrics endpoints are registered to, e.g.,
+// *http.ServeMux.
+type Mux interface {
+	HandleFunc(pattern string, handler func(http.ResponseWriter, *http.Request))
 }
 
-func registerHandler(url string, handler http.HandlerFunc) {
-	http.HandleFunc(url, handler)
+// Register registers the handlers of the metrics endpoints to the mux. They
+// aren't registered to http.DefaultServeMux unless it's passed in, so that
+// they are only served by the console with its authentication.
+func Register(mux Mux) {
+	registerHandler(mux, EndPoint, tpsHandler)
+	registerHandler(mux, OutputsEndPoint, outputsHandler)
+	registerHandler(mux, PrometheusEndPoint, prometheusHandler)
+}
+
+func registerHandler(mux Mux, url string, handler http.HandlerFunc) {
+	mux.HandleFunc(url, handler)
 }
 
 // SetTPS sets the transaction per second data of a particular worker
@@ -51,6 +76,64 @@ func setTPS(tps *expvar.Map, worker string, val int64) {
 	tps.Set(worker, v)
 }
 
+// RemoveWorker removes the rate of a worker which has exited, so that the rates
+// follow the workers running. Its number of events is kept in the totals.
+func RemoveWorker(worker string) {
+	tps.Delete(worker)
+}
+
+// SetWorkers sets the number of the workers running.
+func SetWorkers(n int) {
+	workers.Set(int64(n))
+}
+
+// Workers returns the number of the workers running.
+func Workers() int64 {
+	return workers.Value()
+}
+
+// AddEvents adds to the number of events generated by a particular worker
+func AddEvents(worker string, delta int64) {
+	events.Add(worker, delta)
+}
+
+// WorkerEvents returns the number of events generated by each worker
+func WorkerEvents() map[string]int64 {
+	_, m := intMap(events)
+	return m
+}
+
+// TotalEvents returns the number of events generated by all the workers
+func TotalEvents() int64 {
+	var total int64
+	events.Do(func(kv expvar.KeyValue) {
+		total += kv.Value.(*expvar.Int).Value()
+	})
+	return total
+}
+
+// Rates returns the transaction per second data of each worker, along with the
+// total keyed by TotalTPS
+func Rates() map[string]int64 {
+	return tpsSnapshot(tps)
+}
+
+// AchievedRate returns the transaction per second data of all the workers
+func AchievedRate() int64 {
+	return tpsSnapshot(tps)[TotalTPS]
+}
+
+// ConfiguredRate returns the rate set by SetConfiguredRate
+func ConfiguredRate() float64 {
+	return configuredRate.Value()
+}
+
+// SetConfiguredRate sets the rate in events per second the workers are
+// configured to generate at in total, 0 means unlimited.
+func SetConfiguredRate(eps float64) {
+	configuredRate.Set(eps)
+}
+
 // tpsHandler is an HTTP handler to expose the metrics. It also aggregates
 // the metrics based on predefined rules.
 func tpsHandler(w http.ResponseWriter, r *http.Request) {
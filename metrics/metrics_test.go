@@ -5,12 +5,11 @@ import (
 	"expvar"
 	"io/ioutil"
 	"net/http"
+	"net/http/httptest"
 	"net/url"
 	"testing"
 	"time"
 
-	"github.com/jiwen624/logspout/console"
-
 	"github.com/stretchr/testify/assert"
 )
 
@@ -26,11 +25,16 @@ func TestInitCounters(t *testing.T) {
 }
 
 func TestRegisterHandler(t *testing.T) {
-	// already registered in init() method
 	rawUrl := "/metrics/tps"
 	u, _ := url.Parse(rawUrl)
 
-	handler, path := http.DefaultServeMux.Handler(&http.Request{Method: "GET", URL: u})
+	// not registered to the default mux
+	_, path := http.DefaultServeMux.Handler(&http.Request{Method: "GET", URL: u})
+	assert.Equal(t, "", path)
+
+	mux := http.NewServeMux()
+	Register(mux)
+	handler, path := mux.Handler(&http.Request{Method: "GET", URL: u})
 	// function in go is not addressable or comparable, so just make sure the handler
 	// is not nil here.
 	assert.NotNil(t, handler)
@@ -38,8 +42,10 @@ func TestRegisterHandler(t *testing.T) {
 }
 
 func TestSetGetTPS(t *testing.T) {
-	host := "localhost:12345"
-	console.Start(host)
+	mux := http.NewServeMux()
+	Register(mux)
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
 
 	SetTPS("worker1", 1)
 	SetTPS("worker2", 2)
@@ -48,7 +54,7 @@ func TestSetGetTPS(t *testing.T) {
 	client := &http.Client{
 		Timeout: time.Second * 10,
 	}
-	rsp, err := client.Get("http://" + host + "/metrics/tps")
+	rsp, err := client.Get(srv.URL + "/metrics/tps")
 
 	assert.Nil(t, err)
 	defer rsp.Body.Close()
@@ -73,3 +79,17 @@ func TestSetGetTPS(t *testing.T) {
 func TestTpsSnapshot(t *testing.T) {
 	assert.Nil(t, tpsSnapshot(nil))
 }
+
+func TestRemoveWorker(t *testing.T) {
+	AddEvents("removed-worker", 5)
+	SetTPS("removed-worker", 5)
+	total := TotalEvents()
+
+	RemoveWorker("removed-worker")
+	assert.NotContains(t, Rates(), "removed-worker")
+	assert.Equal(t, int64(5), WorkerEvents()["removed-worker"])
+	assert.Equal(t, total, TotalEvents())
+
+	SetWorkers(2)
+	assert.Equal(t, int64(2), Workers())
+}
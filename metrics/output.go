@@ -0,0 +1,140 @@
+package metrics
+
+import (
+	"encoding/json"
+	"expvar"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// OutputsEndPoint is where the metrics of the outputs are exposed.
+const OutputsEndPoint = "/metrics/outputs"
+
+var (
+	outputs *expvar.Map
+	// outputsMu serializes the creation of the output metrics
+	outputsMu sync.Mutex
+)
+
+// OutputStats are the metrics of an output. All the methods are safe to be
+// called concurrently, and on a nil *OutputStats, in which case nothing is
+// recorded.
+type OutputStats struct {
+	events  int64
+	bytes   int64
+	errors  int64
+	retries int64
+	drops   int64
+	latency *Histogram
+}
+
+// OutputSnapshot is a point-in-time view of the metrics of an output.
+type OutputSnapshot struct {
+	// Events is the number of events written to the output successfully
+	Events int64 `json:"events"`
+	// Bytes is the number of bytes written to the output successfully
+	Bytes int64 `json:"bytes"`
+	// Errors is the number of the failed writes
+	Errors int64 `json:"errors"`
+	// Retries is the number of the retries made by the output
+	Retries int64 `json:"retries"`
+	// Drops is the number of events the output discarded after they were
+	// accepted, e.g., a batch given up after the retries.
+	Drops int64 `json:"drops"`
+	// Latency is the histogram of the time taken by the writes
+	Latency HistogramSnapshot `json:"latency"`
+}
+
+// Output returns the metrics of the output with the name, the metrics are
+// created on the first call. The name is usually the description of the output
+// as the metrics outlive it.
+func Output(name string) *OutputStats {
+	if v := outputs.Get(name); v != nil {
+		return v.(*OutputStats)
+	}
+
+	outputsMu.Lock()
+	defer outputsMu.Unlock()
+
+	if v := outputs.Get(name); v != nil {
+		return v.(*OutputStats)
+	}
+	s := &OutputStats{latency: NewHistogram(LatencyBounds)}
+	outputs.Set(name, s)
+	return s
+}
+
+// Write records a write to the output which took d.
+func (s *OutputStats) Write(n int, err error, d time.Duration) {
+	if s == nil {
+		return
+	}
+	if err != nil {
+		atomic.AddInt64(&s.errors, 1)
+	} else {
+		atomic.AddInt64(&s.events, 1)
+		atomic.AddInt64(&s.bytes, int64(n))
+	}
+	s.latency.Observe(d)
+}
+
+// Retry records a retry of the output.
+func (s *OutputStats) Retry() {
+	if s == nil {
+		return
+	}
+	atomic.AddInt64(&s.retries, 1)
+}
+
+// Drop records n events discarded by the output.
+func (s *OutputStats) Drop(n int) {
+	if s == nil {
+		return
+	}
+	atomic.AddInt64(&s.drops, int64(n))
+}
+
+// Snapshot takes a snapshot of the metrics.
+func (s *OutputStats) Snapshot() OutputSnapshot {
+	if s == nil {
+		return OutputSnapshot{}
+	}
+	return OutputSnapshot{
+		Events:  atomic.LoadInt64(&s.events),
+		Bytes:   atomic.LoadInt64(&s.bytes),
+		Errors:  atomic.LoadInt64(&s.errors),
+		Retries: atomic.LoadInt64(&s.retries),
+		Drops:   atomic.LoadInt64(&s.drops),
+		Latency: s.latency.Snapshot(),
+	}
+}
+
+// String implements expvar.Var.
+func (s *OutputStats) String() string {
+	b, err := json.Marshal(s.Snapshot())
+	if err != nil {
+		return "{}"
+	}
+	return string(b)
+}
+
+// Outputs returns the snapshots of the metrics of all the outputs keyed by
+// their names.
+func Outputs() map[string]OutputSnapshot {
+	m := make(map[string]OutputSnapshot)
+	outputs.Do(func(kv expvar.KeyValue) {
+		m[kv.Key] = kv.Value.(*OutputStats).Snapshot()
+	})
+	return m
+}
+
+// outputsHandler is an HTTP handler to expose the metrics of the outputs.
+func outputsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+	if err := json.NewEncoder(w).Encode(Outputs()); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
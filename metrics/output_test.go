@@ -0,0 +1,82 @@
+package metrics
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHistogram(t *testing.T) {
+	h := NewHistogram([]float64{0.001, 0.01, 0.1})
+	assert.Equal(t, uint64(0), h.Snapshot().Count)
+	assert.Equal(t, float64(0), h.Snapshot().P99)
+
+	for i := 0; i < 90; i++ {
+		h.Observe(500 * time.Microsecond)
+	}
+	for i := 0; i < 9; i++ {
+		h.Observe(5 * time.Millisecond)
+	}
+	h.Observe(time.Second)
+
+	hs := h.Snapshot()
+	assert.Equal(t, uint64(100), hs.Count)
+	assert.InDelta(t, 0.045+0.045+1, hs.Sum, 1e-9)
+	assert.Equal(t, []Bucket{{0.001, 90}, {0.01, 99}, {0.1, 99}}, hs.Buckets)
+	// interpolated within the first bucket
+	assert.InDelta(t, 0.001*50/90, hs.P50, 1e-9)
+	assert.InDelta(t, 0.001, hs.P90, 1e-9)
+	assert.InDelta(t, 0.01, hs.P99, 1e-9)
+
+	// the boundary belongs to the lower bucket
+	h = NewHistogram([]float64{0.001, 0.01})
+	h.Observe(time.Millisecond)
+	assert.Equal(t, uint64(1), h.Snapshot().Buckets[0].Count)
+}
+
+func TestOutputStats(t *testing.T) {
+	s := Output("test-output")
+	assert.Equal(t, s, Output("test-output"))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.Write(10, nil, time.Millisecond)
+		}()
+	}
+	wg.Wait()
+	s.Write(0, errors.New("failed"), time.Second)
+	s.Retry()
+	s.Drop(3)
+
+	ss := s.Snapshot()
+	assert.Equal(t, int64(10), ss.Events)
+	assert.Equal(t, int64(100), ss.Bytes)
+	assert.Equal(t, int64(1), ss.Errors)
+	assert.Equal(t, int64(1), ss.Retries)
+	assert.Equal(t, int64(3), ss.Drops)
+	assert.Equal(t, uint64(11), ss.Latency.Count)
+
+	// nothing is recorded to a nil one
+	var n *OutputStats
+	n.Write(1, nil, time.Second)
+	n.Retry()
+	n.Drop(1)
+	assert.Equal(t, OutputSnapshot{}, n.Snapshot())
+
+	w := httptest.NewRecorder()
+	outputsHandler(w, httptest.NewRequest(http.MethodGet, OutputsEndPoint, nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var m map[string]OutputSnapshot
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &m))
+	assert.Equal(t, ss, m["test-output"])
+}
@@ -0,0 +1,60 @@
+package metrics
+
+import (
+	"expvar"
+	"time"
+)
+
+var (
+	// lateness is the histogram of how late the events are generated after
+	// their intended times on the schedule
+	lateness *Histogram
+	// writes is the histogram of the time taken to write the events, which is
+	// how long the outputs hold up the workers
+	writes *Histogram
+	// skipped is the lag in seconds the workers gave up catching up with
+	skipped *expvar.Float
+)
+
+// PacingSnapshot is a point-in-time view of how the workers keep up with their
+// schedules. A high lateness with short writes means logspout itself is
+// falling behind, while long writes mean the outputs apply backpressure.
+type PacingSnapshot struct {
+	// Lateness is the histogram of how late the events are generated after
+	// their intended times, only the events on a schedule are observed.
+	Lateness HistogramSnapshot `json:"lateness"`
+	// Writes is the histogram of the time taken to write the events to all
+	// the outputs.
+	Writes HistogramSnapshot `json:"writes"`
+	// Skipped is the lag in seconds given up catching up with, the events in
+	// it are never generated.
+	Skipped float64 `json:"skipped"`
+}
+
+// ObserveLateness records how late an event is generated after its intended
+// time.
+func ObserveLateness(d time.Duration) {
+	if d < 0 {
+		d = 0
+	}
+	lateness.Observe(d)
+}
+
+// ObserveWrite records the time taken to write an event.
+func ObserveWrite(d time.Duration) {
+	writes.Observe(d)
+}
+
+// AddSkipped adds the lag given up catching up with.
+func AddSkipped(d time.Duration) {
+	skipped.Add(d.Seconds())
+}
+
+// Pacing returns the snapshot of the pacing metrics.
+func Pacing() PacingSnapshot {
+	return PacingSnapshot{
+		Lateness: lateness.Snapshot(),
+		Writes:   writes.Snapshot(),
+		Skipped:  skipped.Value(),
+	}
+}
@@ -0,0 +1,22 @@
+package metrics
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPacing(t *testing.T) {
+	before := Pacing()
+	ObserveLateness(3 * time.Millisecond)
+	ObserveLateness(-time.Millisecond)
+	ObserveWrite(time.Millisecond)
+	AddSkipped(1500 * time.Millisecond)
+
+	after := Pacing()
+	assert.Equal(t, before.Lateness.Count+2, after.Lateness.Count)
+	assert.InDelta(t, before.Lateness.Sum+0.003, after.Lateness.Sum, 1e-9)
+	assert.Equal(t, before.Writes.Count+1, after.Writes.Count)
+	assert.InDelta(t, before.Skipped+1.5, after.Skipped, 1e-9)
+}
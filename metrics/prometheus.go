@@ -0,0 +1,214 @@
+package metrics
+
+import (
+	"bufio"
+	"expvar"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PrometheusEndPoint is where the metrics are exposed in the Prometheus text
+// exposition format.
+const PrometheusEndPoint = "/metrics"
+
+// the content type of the text exposition format
+const prometheusContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// startTime is when the process started, approximately.
+var startTime = time.Now()
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// promWriter writes the metric families in the text exposition format.
+type promWriter struct {
+	w *bufio.Writer
+}
+
+// family writes the HELP and TYPE lines of a metric family.
+func (p *promWriter) family(name, typ, help string) {
+	fmt.Fprintf(p.w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+// sample writes a sample, the labels are pairs of names and values.
+func (p *promWriter) sample(name string, value float64, labels ...string) {
+	p.w.WriteString(name)
+	if len(labels) > 0 {
+		p.w.WriteByte('{')
+		for i := 0; i+1 < len(labels); i += 2 {
+			if i > 0 {
+				p.w.WriteByte(',')
+			}
+			fmt.Fprintf(p.w, `%s="%s"`, labels[i], labelEscaper.Replace(labels[i+1]))
+		}
+		p.w.WriteByte('}')
+	}
+	p.w.WriteByte(' ')
+	p.w.WriteString(formatValue(value))
+	p.w.WriteByte('\n')
+}
+
+// histogram writes the samples of a histogram, the labels are appended with
+// the le label in the buckets.
+func (p *promWriter) histogram(name string, hs HistogramSnapshot, labels ...string) {
+	for _, b := range hs.Buckets {
+		p.sample(name+"_bucket", float64(b.Count), append(labels, "le", formatValue(b.UpperBound))...)
+	}
+	p.sample(name+"_bucket", float64(hs.Count), append(labels, "le", "+Inf")...)
+	p.sample(name+"_sum", hs.Sum, labels...)
+	p.sample(name+"_count", float64(hs.Count), labels...)
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// intMap returns the keys sorted and the values of a map of expvar.Int.
+func intMap(m *expvar.Map) ([]string, map[string]int64) {
+	var keys []string
+	vals := make(map[string]int64)
+	m.Do(func(kv expvar.KeyValue) {
+		keys = append(keys, kv.Key)
+		vals[kv.Key] = kv.Value.(*expvar.Int).Value()
+	})
+	sort.Strings(keys)
+	return keys, vals
+}
+
+// WritePrometheus writes all the metrics in the Prometheus text exposition
+// format.
+func WritePrometheus(w io.Writer) error {
+	p := &promWriter{w: bufio.NewWriter(w)}
+
+	writeWorkerMetrics(p)
+	writePacingMetrics(p)
+	writeOutputMetrics(p)
+	writeRuntimeMetrics(p)
+
+	return p.w.Flush()
+}
+
+func writeWorkerMetrics(p *promWriter) {
+	workers, evts := intMap(events)
+	p.family("logspout_worker_events_total", "counter", "Number of events generated by each worker.")
+	var total int64
+	for _, wk := range workers {
+		p.sample("logspout_worker_events_total", float64(evts[wk]), "worker", wk)
+		total += evts[wk]
+	}
+	p.family("logspout_events_total", "counter", "Number of events generated by all the workers.")
+	p.sample("logspout_events_total", float64(total))
+
+	workers, rates := intMap(tps)
+	p.family("logspout_worker_events_per_second", "gauge", "Events generated by each worker in the last second.")
+	var achieved int64
+	for _, wk := range workers {
+		p.sample("logspout_worker_events_per_second", float64(rates[wk]), "worker", wk)
+		achieved += rates[wk]
+	}
+	p.family("logspout_achieved_rate_events_per_second", "gauge", "Events generated by all the workers in the last second.")
+	p.sample("logspout_achieved_rate_events_per_second", float64(achieved))
+	p.family("logspout_configured_rate_events_per_second", "gauge", "Rate the workers are configured to generate events at, 0 means unlimited.")
+	p.sample("logspout_configured_rate_events_per_second", configuredRate.Value())
+	p.family("logspout_workers", "gauge", "Number of the workers running.")
+	p.sample("logspout_workers", float64(Workers()))
+}
+
+func writePacingMetrics(p *promWriter) {
+	ps := Pacing()
+	p.family("logspout_lateness_seconds", "histogram", "How late the events are generated after their intended times on the schedule.")
+	p.histogram("logspout_lateness_seconds", ps.Lateness)
+	p.family("logspout_write_seconds", "histogram", "Time taken to write the events to all the outputs.")
+	p.histogram("logspout_write_seconds", ps.Writes)
+	p.family("logspout_skipped_seconds_total", "counter", "Lag in seconds the workers gave up catching up with.")
+	p.sample("logspout_skipped_seconds_total", ps.Skipped)
+}
+
+func writeOutputMetrics(p *promWriter) {
+	m := Outputs()
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	counters := []struct {
+		name, help string
+		value      func(OutputSnapshot) int64
+	}{
+		{"logspout_output_events_total", "Number of events written to the output.",
+			func(s OutputSnapshot) int64 { return s.Events }},
+		{"logspout_output_bytes_total", "Number of bytes written to the output.",
+			func(s OutputSnapshot) int64 { return s.Bytes }},
+		{"logspout_output_errors_total", "Number of failed writes to the output.",
+			func(s OutputSnapshot) int64 { return s.Errors }},
+		{"logspout_output_retries_total", "Number of retries made by the output.",
+			func(s OutputSnapshot) int64 { return s.Retries }},
+		{"logspout_output_dropped_events_total", "Number of events discarded by the output.",
+			func(s OutputSnapshot) int64 { return s.Drops }},
+	}
+	for _, c := range counters {
+		p.family(c.name, "counter", c.help)
+		for _, name := range names {
+			p.sample(c.name, float64(c.value(m[name])), "output", name)
+		}
+	}
+
+	p.family("logspout_output_write_duration_seconds", "histogram", "Time taken by the writes to the output.")
+	for _, name := range names {
+		p.histogram("logspout_output_write_duration_seconds", m[name].Latency, "output", name)
+	}
+}
+
+func writeRuntimeMetrics(p *promWriter) {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+
+	p.family("go_info", "gauge", "Information about the Go environment.")
+	p.sample("go_info", 1, "version", runtime.Version())
+	p.family("go_goroutines", "gauge", "Number of goroutines that currently exist.")
+	p.sample("go_goroutines", float64(runtime.NumGoroutine()))
+
+	stats := []struct {
+		name, typ, help string
+		value           float64
+	}{
+		{"go_memstats_alloc_bytes", "gauge", "Number of bytes allocated and still in use.", float64(ms.Alloc)},
+		{"go_memstats_alloc_bytes_total", "counter", "Total number of bytes allocated, even if freed.", float64(ms.TotalAlloc)},
+		{"go_memstats_sys_bytes", "gauge", "Number of bytes obtained from system.", float64(ms.Sys)},
+		{"go_memstats_heap_inuse_bytes", "gauge", "Number of heap bytes that are in use.", float64(ms.HeapInuse)},
+		{"go_memstats_heap_objects", "gauge", "Number of allocated objects.", float64(ms.HeapObjects)},
+		{"go_memstats_mallocs_total", "counter", "Total number of mallocs.", float64(ms.Mallocs)},
+		{"go_memstats_frees_total", "counter", "Total number of frees.", float64(ms.Frees)},
+		{"go_memstats_last_gc_time_seconds", "gauge", "Number of seconds since 1970 of last garbage collection.", float64(ms.LastGC) / 1e9},
+		{"go_memstats_gc_cpu_fraction", "gauge", "The fraction of this program's available CPU time used by the GC since the program started.", ms.GCCPUFraction},
+		{"go_gc_cycles_total", "counter", "Number of completed GC cycles.", float64(ms.NumGC)},
+		{"go_gc_pause_seconds_total", "counter", "Total time the GC stopped the world.", float64(ms.PauseTotalNs) / 1e9},
+		{"process_start_time_seconds", "gauge", "Start time of the process since unix epoch in seconds.", float64(startTime.UnixNano()) / 1e9},
+	}
+	for _, st := range stats {
+		p.family(st.name, st.typ, st.help)
+		p.sample(st.name, st.value)
+	}
+}
+
+// prometheusHandler is an HTTP handler to expose the metrics in the Prometheus
+// text exposition format.
+func prometheusHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", prometheusContentType)
+	WritePrometheus(w)
+}
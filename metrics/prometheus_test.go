@@ -0,0 +1,66 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// the line of a sample in the text exposition format
+var sampleLine = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*(\{([a-zA-Z_][a-zA-Z0-9_]*="([^"\\]|\\.)*",?)*\})? \S+$`)
+
+func TestPrometheus(t *testing.T) {
+	AddEvents("prom-worker1", 10)
+	AddEvents("prom-worker1", 5)
+	AddEvents("prom-worker2", 1)
+	SetTPS("prom-worker1", 3)
+	SetConfiguredRate(2.5)
+	SetWorkers(3)
+	Output("prom\"output\n").Write(7, nil, time.Millisecond)
+
+	w := httptest.NewRecorder()
+	prometheusHandler(w, httptest.NewRequest(http.MethodGet, PrometheusEndPoint, nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, prometheusContentType, w.Header().Get("Content-Type"))
+
+	body := w.Body.String()
+	for _, l := range strings.Split(strings.TrimSuffix(body, "\n"), "\n") {
+		if strings.HasPrefix(l, "# HELP ") || strings.HasPrefix(l, "# TYPE ") {
+			continue
+		}
+		assert.Regexp(t, sampleLine, l)
+	}
+
+	for _, s := range []string{
+		"# TYPE logspout_worker_events_total counter\n",
+		`logspout_worker_events_total{worker="prom-worker1"} 15` + "\n",
+		`logspout_worker_events_total{worker="prom-worker2"} 1` + "\n",
+		`logspout_worker_events_per_second{worker="prom-worker1"} 3` + "\n",
+		"logspout_configured_rate_events_per_second 2.5\n",
+		"logspout_workers 3\n",
+		`logspout_output_bytes_total{output="prom\"output\n"} 7` + "\n",
+		`logspout_output_write_duration_seconds_bucket{output="prom\"output\n",le="0.0005"} 0` + "\n",
+		`logspout_output_write_duration_seconds_bucket{output="prom\"output\n",le="0.001"} 1` + "\n",
+		`logspout_output_write_duration_seconds_bucket{output="prom\"output\n",le="+Inf"} 1` + "\n",
+		`logspout_output_write_duration_seconds_count{output="prom\"output\n"} 1` + "\n",
+		"# TYPE logspout_lateness_seconds histogram\n",
+		`logspout_lateness_seconds_bucket{le="+Inf"} `,
+		"# TYPE logspout_skipped_seconds_total counter\n",
+		"# TYPE go_goroutines gauge\n",
+		"process_start_time_seconds ",
+	} {
+		assert.Contains(t, body, s)
+	}
+	assert.Regexp(t, `(?m)^logspout_events_total \d+$`, body)
+}
+
+func TestFormatValue(t *testing.T) {
+	assert.Equal(t, "1", formatValue(1))
+	assert.Equal(t, "0.00025", formatValue(0.00025))
+	assert.Equal(t, "1e+06", formatValue(1e6))
+}
@@ -0,0 +1,325 @@
+package metrics
+
+import (
+	"bytes"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/jiwen624/logspout/log"
+)
+
+// The flavors of StatsD. The plain StatsD has no tags, so the labels of the
+// metrics such as the names of the outputs are appended to the metric names,
+// e.g., logspout.output.events.discard. DogStatsD tags the metrics instead,
+// e.g., logspout.output.events:10|c|#output:discard.
+const (
+	FlavorStatsD    = "statsd"
+	FlavorDogStatsD = "dogstatsd"
+)
+
+// default parameters of the StatsD reporter
+const (
+	defaultStatsDAddr          = "localhost:8125"
+	defaultStatsDPrefix        = "logspout."
+	defaultStatsDFlushInterval = 10
+	// maxStatsDPacket is the maximum size of a datagram, which fits in the
+	// MTU of most networks.
+	maxStatsDPacket = 1432
+)
+
+var errUnknownFlavor = errors.New("unknown statsd flavor")
+
+// StatsDConfig is the configuration of the StatsD reporter.
+type StatsDConfig struct {
+	// Addr is the UDP address of the StatsD server, localhost:8125 by default.
+	Addr string `json:"addr"`
+	// Flavor is either statsd (the default) or dogstatsd.
+	Flavor string `json:"flavor"`
+	// Prefix is prepended to the metric names, logspout. by default.
+	Prefix string `json:"prefix"`
+	// Tags are added to all the metrics, which are only supported by
+	// DogStatsD, e.g., {"env": "ci"}.
+	Tags map[string]string `json:"tags"`
+	// FlushInterval is the interval in seconds the metrics are pushed at, 10 by
+	// default. The metrics are pushed once more when the reporter is closed.
+	FlushInterval int `json:"flushInterval"`
+}
+
+// StatsD pushes the metrics to a StatsD or DogStatsD server periodically, which
+// suits the short-lived runs a scraper may never see. The counters are pushed
+// as the increments since the last push, and the rates as gauges.
+type StatsD struct {
+	conf StatsDConfig
+	conn net.Conn
+	// the global tags in the DogStatsD format
+	tags string
+
+	// mu serializes the pushes, and protects started
+	mu sync.Mutex
+	// the values of the counters pushed last, keyed by their names and labels
+	last map[string]int64
+
+	started   bool
+	quit      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// statsdSample is a value of a metric with a label at most.
+type statsdSample struct {
+	name       string
+	typ        string
+	value      int64
+	labelName  string
+	labelValue string
+}
+
+// NewStatsD creates a reporter of the config, the defaults are filled in.
+func NewStatsD(conf StatsDConfig) (*StatsD, error) {
+	if conf.Addr == "" {
+		conf.Addr = defaultStatsDAddr
+	}
+	if conf.Flavor == "" {
+		conf.Flavor = FlavorStatsD
+	}
+	if conf.Prefix == "" {
+		conf.Prefix = defaultStatsDPrefix
+	}
+	if conf.FlushInterval <= 0 {
+		conf.FlushInterval = defaultStatsDFlushInterval
+	}
+	if conf.Flavor != FlavorStatsD && conf.Flavor != FlavorDogStatsD {
+		return nil, errors.Wrap(errUnknownFlavor, conf.Flavor)
+	}
+	if conf.Flavor == FlavorStatsD && len(conf.Tags) != 0 {
+		log.Warnf("The tags are ignored as they are not supported by %s.", conf.Flavor)
+	}
+
+	conn, err := net.Dial("udp", conf.Addr)
+	if err != nil {
+		return nil, errors.Wrap(err, "new statsd")
+	}
+
+	r := &StatsD{
+		conf: conf,
+		conn: conn,
+		last: make(map[string]int64),
+		quit: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	if conf.Flavor == FlavorDogStatsD {
+		r.tags = dogTags(conf.Tags)
+	}
+	return r, nil
+}
+
+// dogTags formats the tags sorted by their keys.
+func dogTags(tags map[string]string) string {
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, 0, len(keys))
+	for _, k := range keys {
+		pairs = append(pairs, sanitizeTag(k)+":"+sanitizeTag(tags[k]))
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (r *StatsD) String() string {
+	return r.conf.Flavor + "://" + r.conf.Addr
+}
+
+// Start pushes the metrics in a standalone goroutine until it's closed.
+func (r *StatsD) Start() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.started {
+		return
+	}
+	r.started = true
+
+	log.Infof("Pushing the metrics to %s every %ds.", r, r.conf.FlushInterval)
+	go r.loop()
+}
+
+func (r *StatsD) loop() {
+	defer close(r.done)
+
+	ticker := time.NewTicker(time.Duration(r.conf.FlushInterval) * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.quit:
+			return
+		case <-ticker.C:
+			if err := r.Flush(); err != nil {
+				log.Warn(err)
+			}
+		}
+	}
+}
+
+// Close stops pushing the metrics after the last push.
+func (r *StatsD) Close() error {
+	var err error
+	r.closeOnce.Do(func() {
+		r.mu.Lock()
+		started := r.started
+		r.mu.Unlock()
+
+		close(r.quit)
+		if started {
+			<-r.done
+		}
+		err = r.Flush()
+		if cerr := r.conn.Close(); err == nil {
+			err = errors.Wrap(cerr, "close statsd")
+		}
+	})
+	return err
+}
+
+// Flush pushes the metrics once.
+func (r *StatsD) Flush() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var packet bytes.Buffer
+	var err error
+	send := func() {
+		if packet.Len() == 0 {
+			return
+		}
+		if _, werr := r.conn.Write(packet.Bytes()); werr != nil && err == nil {
+			err = errors.Wrap(werr, "push metrics to "+r.String())
+		}
+		packet.Reset()
+	}
+
+	for _, s := range r.collect() {
+		line := r.format(s)
+		if packet.Len() > 0 && packet.Len()+1+len(line) > maxStatsDPacket {
+			send()
+		}
+		if packet.Len() > 0 {
+			packet.WriteByte('\n')
+		}
+		packet.WriteString(line)
+	}
+	send()
+	return err
+}
+
+// collect takes the samples to push, the counters which haven't changed since
+// the last push are skipped. The caller must hold the lock.
+func (r *StatsD) collect() []statsdSample {
+	var samples []statsdSample
+	counter := func(name string, v int64, label ...string) {
+		s := statsdSample{name: name, typ: "c"}
+		if len(label) == 2 {
+			s.labelName, s.labelValue = label[0], label[1]
+		}
+		key := name + "\x00" + s.labelValue
+		if s.value = v - r.last[key]; s.value == 0 {
+			return
+		}
+		r.last[key] = v
+		samples = append(samples, s)
+	}
+	gauge := func(name string, v int64, label ...string) {
+		s := statsdSample{name: name, typ: "g", value: v}
+		if len(label) == 2 {
+			s.labelName, s.labelValue = label[0], label[1]
+		}
+		samples = append(samples, s)
+	}
+
+	workers, evts := intMap(events)
+	var total int64
+	for _, wk := range workers {
+		counter("worker.events", evts[wk], "worker", wk)
+		total += evts[wk]
+	}
+	counter("events", total)
+
+	workers, rates := intMap(tps)
+	var achieved int64
+	for _, wk := range workers {
+		gauge("worker.rate", rates[wk], "worker", wk)
+		achieved += rates[wk]
+	}
+	gauge("rate", achieved)
+	gauge("configured_rate", int64(configuredRate.Value()))
+	gauge("workers", Workers())
+
+	m := Outputs()
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		o := m[name]
+		counter("output.events", o.Events, "output", name)
+		counter("output.bytes", o.Bytes, "output", name)
+		counter("output.errors", o.Errors, "output", name)
+		counter("output.retries", o.Retries, "output", name)
+		counter("output.drops", o.Drops, "output", name)
+	}
+	return samples
+}
+
+// format formats the sample as a line of the flavor.
+func (r *StatsD) format(s statsdSample) string {
+	name := r.conf.Prefix + s.name
+	if r.conf.Flavor == FlavorStatsD && s.labelValue != "" {
+		name += "." + sanitizeName(s.labelValue)
+	}
+	line := name + ":" + strconv.FormatInt(s.value, 10) + "|" + s.typ
+
+	if r.conf.Flavor != FlavorDogStatsD {
+		return line
+	}
+	tags := r.tags
+	if s.labelValue != "" {
+		if tags != "" {
+			tags += ","
+		}
+		tags += s.labelName + ":" + sanitizeTag(s.labelValue)
+	}
+	if tags != "" {
+		line += "|#" + tags
+	}
+	return line
+}
+
+// sanitizeName replaces the characters not allowed in a segment of a metric
+// name, including the dots which separate the segments.
+func sanitizeName(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' || r == '-' {
+			return r
+		}
+		return '_'
+	}, s)
+}
+
+// sanitizeTag replaces the characters which break the DogStatsD format.
+func sanitizeTag(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case ',', '|', '#', '\n', ' ':
+			return '_'
+		}
+		return r
+	}, s)
+}
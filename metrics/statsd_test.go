@@ -0,0 +1,111 @@
+package metrics
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+// statsdServer listens for the metrics pushed.
+func statsdServer(t *testing.T) (*net.UDPConn, func() []string) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	assert.Nil(t, err)
+
+	// read returns the lines received in a push.
+	read := func() []string {
+		buf := make([]byte, 65536)
+		var lines []string
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		for {
+			n, err := conn.Read(buf)
+			if err != nil {
+				return lines
+			}
+			lines = append(lines, strings.Split(string(buf[:n]), "\n")...)
+			conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+		}
+	}
+	return conn, read
+}
+
+func TestStatsD(t *testing.T) {
+	conn, read := statsdServer(t)
+	defer conn.Close()
+
+	AddEvents("statsd-worker", 5)
+	Output("statsd.output|1").Write(10, nil, time.Millisecond)
+
+	r, err := NewStatsD(StatsDConfig{Addr: conn.LocalAddr().String(), Prefix: "test."})
+	assert.Nil(t, err)
+	assert.Nil(t, r.Flush())
+	lines := read()
+	assert.Contains(t, lines, "test.worker.events.statsd-worker:5|c")
+	assert.Contains(t, lines, "test.output.events.statsd_output_1:1|c")
+	assert.Contains(t, lines, "test.output.bytes.statsd_output_1:10|c")
+	assert.NotContains(t, lines, "test.output.errors.statsd_output_1:0|c")
+
+	// only the increments are pushed
+	AddEvents("statsd-worker", 2)
+	Output("statsd.output|1").Write(0, errors.New("failed"), time.Millisecond)
+	assert.Nil(t, r.Close())
+	lines = read()
+	assert.Contains(t, lines, "test.worker.events.statsd-worker:2|c")
+	assert.Contains(t, lines, "test.output.errors.statsd_output_1:1|c")
+	assert.NotContains(t, lines, "test.output.bytes.statsd_output_1:10|c")
+
+	assert.Nil(t, r.Close())
+}
+
+func TestDogStatsD(t *testing.T) {
+	conn, read := statsdServer(t)
+	defer conn.Close()
+
+	Output("dogstatsd,output").Write(10, nil, time.Millisecond)
+	SetTPS("dogstatsd-worker", 7)
+	defer SetTPS("dogstatsd-worker", 0)
+
+	r, err := NewStatsD(StatsDConfig{
+		Addr:   conn.LocalAddr().String(),
+		Flavor: FlavorDogStatsD,
+		Tags:   map[string]string{"job": "ci", "env": "test"},
+	})
+	assert.Nil(t, err)
+	r.Start()
+	assert.Nil(t, r.Close())
+	lines := read()
+	assert.Contains(t, lines, "logspout.output.events:1|c|#env:test,job:ci,output:dogstatsd_output")
+	assert.Contains(t, lines, "logspout.worker.rate:7|g|#env:test,job:ci,worker:dogstatsd-worker")
+}
+
+func TestStatsDPacketSize(t *testing.T) {
+	conn, read := statsdServer(t)
+	defer conn.Close()
+
+	for i := 0; i < 100; i++ {
+		Output(strings.Repeat("x", 50)+string(rune('a'+i%26))+string(rune('a'+i/26))).Write(1, nil, 0)
+	}
+	r, err := NewStatsD(StatsDConfig{Addr: conn.LocalAddr().String()})
+	assert.Nil(t, err)
+	defer r.Close()
+	assert.Nil(t, r.Flush())
+
+	var events int
+	for _, l := range read() {
+		if strings.HasPrefix(l, "logspout.output.events.xxx") {
+			events++
+		}
+	}
+	assert.Equal(t, 100, events)
+}
+
+func TestNewStatsDBadConfig(t *testing.T) {
+	_, err := NewStatsD(StatsDConfig{Flavor: "graphite"})
+	assert.Equal(t, errUnknownFlavor, errors.Cause(err))
+
+	_, err = NewStatsD(StatsDConfig{Addr: "bad address"})
+	assert.NotNil(t, err)
+}
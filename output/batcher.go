@@ -0,0 +1,191 @@
+package output
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/jiwen624/logspout/log"
+	"github.com/jiwen624/logspout/metrics"
+)
+
+// pendingBatches is the number of the full batches waiting to be sent, after
+// which the writes are blocked until one of them is sent.
+const pendingBatches = 4
+
+// batcher batches the encoded events of an output, e.g., the rows of ClickHouse
+// or the lines of InfluxDB, and sends the batches in the background, so that
+// the requests and their retries don't hold up the writes.
+type batcher struct {
+	// name is the description of the output in the logs
+	name string
+	// send sends a batch once, the error decides if it's retried.
+	send       func(batch []byte) error
+	size       int
+	maxRetries int
+	backoff    time.Duration
+	// where the retries and the drops are recorded
+	stats *metrics.OutputStats
+
+	// mu protects the batch below
+	mu    sync.Mutex
+	batch bytes.Buffer
+	rows  int
+
+	// inflight tracks the batches being sent
+	inflight sync.WaitGroup
+	// the full batches waiting for the sender
+	full   chan []byte
+	done   chan struct{}
+	sender sync.WaitGroup
+}
+
+// start starts the sender, which sends the full batches, and the current batch
+// in the interval so that the events don't stay in the batch for too long when
+// the event rate is low. A negative maxRetries disables the retries.
+func (b *batcher) start(name string, size, maxRetries int, backoff, interval time.Duration, send func([]byte) error) {
+	b.name, b.size, b.maxRetries, b.backoff, b.send = name, size, maxRetries, backoff, send
+	b.stats = metrics.Output(name)
+	b.full = make(chan []byte, pendingBatches)
+	b.done = make(chan struct{})
+	b.sender.Add(1)
+	go b.sendBatches(interval)
+}
+
+// stop stops the sender after the full batches are sent, then sends the
+// current batch.
+func (b *batcher) stop() error {
+	close(b.done)
+	b.sender.Wait()
+
+	err := b.Flush()
+	b.inflight.Wait()
+	return err
+}
+
+// add adds an encoded event to the current batch, which is handed to the
+// sender when it's full.
+func (b *batcher) add(p []byte) {
+	b.mu.Lock()
+	b.batch.Write(p)
+	b.rows++
+	var full []byte
+	if b.rows >= b.size {
+		full = b.take()
+	}
+	b.mu.Unlock()
+
+	if full != nil {
+		b.enqueue(full)
+	}
+}
+
+// take returns the current batch and starts a new one. The caller must hold
+// the mutex.
+func (b *batcher) take() []byte {
+	if b.rows == 0 {
+		return nil
+	}
+	batch := make([]byte, b.batch.Len())
+	copy(batch, b.batch.Bytes())
+	b.batch.Reset()
+	b.rows = 0
+	b.inflight.Add(1)
+	return batch
+}
+
+// enqueue hands the batch to the sender, or sends it if the sender is gone.
+func (b *batcher) enqueue(batch []byte) {
+	select {
+	case b.full <- batch:
+	case <-b.done:
+		b.sendLogged(batch)
+	}
+}
+
+// Flush sends the events in the current batch immediately.
+func (b *batcher) Flush() error {
+	b.mu.Lock()
+	batch := b.take()
+	b.mu.Unlock()
+
+	if batch == nil {
+		return nil
+	}
+	return b.retry(batch)
+}
+
+// sendBatches sends the full batches, and the current batch in the interval.
+// The full batches are all sent before it returns.
+func (b *batcher) sendBatches(interval time.Duration) {
+	defer b.sender.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case batch := <-b.full:
+			b.sendLogged(batch)
+		case <-b.done:
+			for {
+				select {
+				case batch := <-b.full:
+					b.sendLogged(batch)
+				default:
+					return
+				}
+			}
+		case <-ticker.C:
+			if err := b.Flush(); err != nil {
+				log.Warn(errors.Wrap(err, b.name))
+			}
+		}
+	}
+}
+
+// sendLogged sends the batch and logs the error as there's nobody to return it
+// to.
+func (b *batcher) sendLogged(batch []byte) {
+	if err := b.retry(batch); err != nil {
+		log.Warn(errors.Wrap(err, b.name))
+	}
+}
+
+// retry sends a batch, retrying on retriable errors with exponential backoff.
+// The events are counted as dropped if it fails eventually.
+func (b *batcher) retry(batch []byte) (err error) {
+	defer b.inflight.Done()
+	defer func() {
+		if err != nil {
+			b.stats.Drop(countLines(batch))
+		}
+	}()
+
+	retries := b.maxRetries
+	if retries < 0 {
+		retries = 0
+	}
+
+	backoff := b.backoff
+	for i := 0; i <= retries; i++ {
+		if i > 0 {
+			log.Debugf("Retrying to send to %s (#%d): %v", b.name, i, err)
+			b.stats.Retry()
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+		if err = b.send(batch); err == nil || !retriable(err) {
+			return err
+		}
+	}
+	return errors.Wrap(err, fmt.Sprintf("gave up after %d retries", retries))
+}
+
+// countLines returns the number of the newline terminated events in the batch.
+func countLines(batch []byte) int {
+	return bytes.Count(batch, []byte{'\n'})
+}
@@ -0,0 +1,59 @@
+package output
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/jiwen624/logspout/metrics"
+)
+
+func TestBatcher(t *testing.T) {
+	var mu sync.Mutex
+	var batches []string
+	fail := 0
+	send := func(batch []byte) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if fail > 0 {
+			fail--
+			return &influxError{status: 503}
+		}
+		batches = append(batches, string(batch))
+		return nil
+	}
+
+	b := &batcher{}
+	b.start("batcher-test", 2, 1, time.Millisecond, time.Hour, send)
+	b.add([]byte("a\n"))
+	b.add([]byte("b\n"))
+	b.add([]byte("c\n"))
+	assert.Nil(t, b.stop())
+	assert.Equal(t, []string{"a\nb\n", "c\n"}, batches)
+
+	// it's retried once then dropped
+	b = &batcher{}
+	b.start("batcher-test-retry", 1, 1, time.Millisecond, time.Hour, send)
+	fail = 3
+	b.add([]byte("d\n"))
+	b.add([]byte("e\n"))
+	assert.Nil(t, b.stop())
+	assert.Equal(t, []string{"a\nb\n", "c\n", "e\n"}, batches)
+	ss := metrics.Output("batcher-test-retry").Snapshot()
+	assert.Equal(t, int64(2), ss.Retries)
+	assert.Equal(t, int64(1), ss.Drops)
+
+	// not retriable
+	b = &batcher{}
+	b.start("batcher-test-fatal", 1, 3, time.Millisecond, time.Hour, func([]byte) error {
+		return errors.New("bad request")
+	})
+	b.add([]byte("f\n"))
+	assert.Nil(t, b.stop())
+	ss = metrics.Output("batcher-test-fatal").Snapshot()
+	assert.Equal(t, int64(0), ss.Retries)
+	assert.Equal(t, int64(1), ss.Drops)
+}
@@ -0,0 +1,432 @@
+package output
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/jiwen624/logspout/log"
+)
+
+// ClickHouse inserts the events into a ClickHouse table through its HTTP
+// interface. The events are batched and each capture group is mapped to a
+// column of the table.
+type ClickHouse struct {
+	// URL is the address of the HTTP interface, e.g., http://localhost:8123
+	URL      string `json:"url"`
+	Database string `json:"database"`
+	Table    string `json:"table"`
+	User     string `json:"user"`
+	Password string `json:"password"`
+	// Format is the input format of the INSERT statement, which can be either
+	// JSONEachRow (the default) or TabSeparated.
+	Format string `json:"format"`
+	// Columns maps the capture groups to the columns of the table.
+	Columns []Column `json:"columns"`
+	// BatchSize is the maximum number of rows in a single INSERT.
+	BatchSize int `json:"batchSize"`
+	// FlushInterval is the maximum time in milliseconds a row stays in the
+	// batch before it's sent.
+	FlushInterval int `json:"flushInterval"`
+	// AsyncInsert enables the server-side async_insert setting.
+	AsyncInsert bool `json:"asyncInsert"`
+	// WaitForAsyncInsert makes the server acknowledge an async insert only
+	// after the data is flushed to the table.
+	WaitForAsyncInsert bool `json:"waitForAsyncInsert"`
+	// BestEffortDateTime lets the server parse the DateTime columns with the
+	// best_effort parser, so that most human readable timestamps are accepted.
+	BestEffortDateTime bool `json:"bestEffortDateTime"`
+	// MaxRetries is the number of times a batch is retried on retriable errors,
+	// a negative value disables the retries.
+	MaxRetries int `json:"maxRetries"`
+	// RetryBackoff is the initial backoff in milliseconds between retries, it's
+	// doubled after each retry.
+	RetryBackoff int `json:"retryBackoff"`
+	// Timeout is the timeout in milliseconds of a single HTTP request.
+	Timeout int `json:"timeout"`
+
+	client *http.Client
+	query  string
+
+	// the rows are inserted in batches in the background
+	batcher
+}
+
+// Column maps a capture group (or a pseudo field such as _raw) to a column of
+// a ClickHouse table.
+type Column struct {
+	// Name is the column name.
+	Name string `json:"name"`
+	// Field is the name of the capture group, it defaults to the column name.
+	Field string `json:"field"`
+	// Type is the ClickHouse data type of the column, e.g., UInt32, Float64,
+	// String, DateTime. It decides how the value is encoded.
+	Type string `json:"type"`
+}
+
+// the supported input formats
+const (
+	formatJSONEachRow  = "JSONEachRow"
+	formatTabSeparated = "TabSeparated"
+)
+
+// default parameters
+const (
+	defaultCHURL           = "http://localhost:8123"
+	defaultCHDatabase      = "default"
+	defaultCHBatchSize     = 1000
+	defaultCHFlushInterval = 1000 // milliseconds
+	defaultCHMaxRetries    = 3
+	defaultCHRetryBackoff  = 100  // milliseconds
+	defaultCHTimeout       = 5000 // milliseconds
+)
+
+var (
+	errNoTable         = errors.New("no table specified")
+	errNoColumns       = errors.New("no columns specified")
+	errUnknownFormat   = errors.New("unknown format")
+	errFieldNotFound   = errors.New("field not found")
+	errInvalidColValue = errors.New("invalid column value")
+)
+
+// clickHouseError is an error returned by the ClickHouse server.
+type clickHouseError struct {
+	status int
+	code   int
+	msg    string
+}
+
+func (e *clickHouseError) Error() string {
+	return fmt.Sprintf("clickhouse: status %d, code %d: %s", e.status, e.code, e.msg)
+}
+
+// retriableCodes are the ClickHouse error codes which are worth a retry, as
+// they are usually caused by temporary overload or network issues.
+var retriableCodes = map[int]bool{
+	159: true, // TIMEOUT_EXCEEDED
+	164: true, // READONLY
+	202: true, // TOO_MANY_SIMULTANEOUS_QUERIES
+	203: true, // NO_FREE_CONNECTION
+	209: true, // SOCKET_TIMEOUT
+	210: true, // NETWORK_ERROR
+	241: true, // MEMORY_LIMIT_EXCEEDED
+	242: true, // TABLE_IS_READ_ONLY
+	252: true, // TOO_MANY_PARTS
+	285: true, // TOO_FEW_LIVE_REPLICAS
+	319: true, // UNKNOWN_STATUS_OF_INSERT
+	425: true, // SYSTEM_ERROR
+}
+
+func (e *clickHouseError) retriable() bool {
+	if retriableCodes[e.code] {
+		return true
+	}
+	switch e.status {
+	case http.StatusTooManyRequests, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// retriable tells if the error of a write is worth a retry.
+func retriable(err error) bool {
+	switch e := errors.Cause(err).(type) {
+	case *clickHouseError:
+		return e.retriable()
+	case *influxError:
+		return e.retriable()
+	case *url.Error:
+		// The request was not sent or the response was not received.
+		_, ok := e.Err.(net.Error)
+		return ok || e.Err == io.EOF || e.Err == io.ErrUnexpectedEOF
+	case net.Error:
+		return true
+	}
+	return false
+}
+
+func (c *ClickHouse) String() string {
+	return fmt.Sprintf("ClickHouse{URL:%s, Database:%s, Table:%s}",
+		c.URL, c.Database, c.Table)
+}
+
+func (c *ClickHouse) ID() ID {
+	return id(c.String())
+}
+
+func (c *ClickHouse) Type() Type {
+	return clickhouse
+}
+
+// Write inserts a log event which has no capture groups, only the pseudo fields
+// are available to the columns.
+func (c *ClickHouse) Write(p []byte) (n int, err error) {
+	return c.WriteEvent(&Event{Raw: string(p), Time: time.Now()})
+}
+
+// WriteEvent encodes the event as a row and adds it to the current batch. The
+// batch is handed to the sender when it's full, so the inserts and their
+// retries don't hold up the writes.
+func (c *ClickHouse) WriteEvent(e *Event) (n int, err error) {
+	if c.client == nil {
+		return 0, errors.Wrap(errOutputNull, c.String())
+	}
+
+	row, err := c.encodeRow(e)
+	if err != nil {
+		return 0, errors.Wrap(err, c.String())
+	}
+
+	c.add(row)
+	return len(e.Raw), nil
+}
+
+func (c *ClickHouse) Activate() error {
+	log.Infof("Activating output %s", c)
+
+	if err := c.buildClickHouse(); err != nil {
+		return errors.Wrap(err, "activate clickhouse")
+	}
+
+	c.start(c.String(), c.BatchSize, c.MaxRetries,
+		time.Millisecond*time.Duration(c.RetryBackoff),
+		time.Millisecond*time.Duration(c.FlushInterval), c.insert)
+	return nil
+}
+
+func (c *ClickHouse) Deactivate() error {
+	if c.client == nil {
+		return errors.Wrap(errOutputNull, c.String())
+	}
+	log.Infof("Deactivating output %s", c)
+
+	err := c.stop()
+	c.client = nil
+	return errors.Wrap(err, "deactivate clickhouse")
+}
+
+// insert sends a single HTTP request to insert the rows.
+func (c *ClickHouse) insert(batch []byte) error {
+	req, err := http.NewRequest(http.MethodPost, c.query, bytes.NewReader(batch))
+	if err != nil {
+		return errors.Wrap(err, "insert")
+	}
+	if c.User != "" {
+		req.Header.Set("X-ClickHouse-User", c.User)
+		req.Header.Set("X-ClickHouse-Key", c.Password)
+	}
+
+	rsp, err := c.client.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "insert")
+	}
+	defer rsp.Body.Close()
+
+	body, _ := ioutil.ReadAll(io.LimitReader(rsp.Body, 4096))
+	if rsp.StatusCode == http.StatusOK {
+		return nil
+	}
+
+	code, _ := strconv.Atoi(rsp.Header.Get("X-ClickHouse-Exception-Code"))
+	return &clickHouseError{
+		status: rsp.StatusCode,
+		code:   code,
+		msg:    strings.TrimSpace(string(body)),
+	}
+}
+
+// encodeRow encodes an event as a row of the configured format.
+func (c *ClickHouse) encodeRow(e *Event) ([]byte, error) {
+	var b bytes.Buffer
+
+	switch c.Format {
+	case formatJSONEachRow:
+		b.WriteByte('{')
+		for i, col := range c.Columns {
+			v, err := columnValue(e, col)
+			if err != nil {
+				return nil, err
+			}
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			b.Write(quoteJSON(col.Name))
+			b.WriteByte(':')
+			b.Write(jsonValue(v, col.Type))
+		}
+		b.WriteString("}\n")
+
+	case formatTabSeparated:
+		for i, col := range c.Columns {
+			v, err := columnValue(e, col)
+			if err != nil {
+				return nil, err
+			}
+			if i > 0 {
+				b.WriteByte('\t')
+			}
+			b.WriteString(tsvEscaper.Replace(v))
+		}
+		b.WriteByte('\n')
+
+	default:
+		return nil, errors.Wrap(errUnknownFormat, c.Format)
+	}
+	return b.Bytes(), nil
+}
+
+// columnValue looks up the value of a column from the event and validates it
+// against the column type.
+func columnValue(e *Event, col Column) (string, error) {
+	f := col.Field
+	if f == "" {
+		f = col.Name
+	}
+	v, ok := e.Field(f)
+	if !ok {
+		return "", errors.Wrap(errFieldNotFound, f)
+	}
+
+	var err error
+	switch baseType(col.Type) {
+	case "Int8", "Int16", "Int32", "Int64":
+		_, err = strconv.ParseInt(v, 10, 64)
+	case "UInt8", "UInt16", "UInt32", "UInt64":
+		_, err = strconv.ParseUint(v, 10, 64)
+	case "Float32", "Float64", "Decimal":
+		_, err = strconv.ParseFloat(v, 64)
+	case "Bool":
+		_, err = strconv.ParseBool(v)
+	}
+	if err != nil {
+		return "", errors.Wrap(errInvalidColValue, fmt.Sprintf("%s %s: %q", col.Name, col.Type, v))
+	}
+	return v, nil
+}
+
+// baseType strips the modifiers and parameters of a ClickHouse type, e.g.,
+// Nullable(Decimal(10, 2)) -> Decimal.
+func baseType(t string) string {
+	for _, m := range []string{"Nullable(", "LowCardinality("} {
+		if strings.HasPrefix(t, m) {
+			t = strings.TrimSuffix(strings.TrimPrefix(t, m), ")")
+		}
+	}
+	if i := strings.IndexByte(t, '('); i >= 0 {
+		t = t[:i]
+	}
+	return strings.TrimSpace(t)
+}
+
+// jsonValue encodes a validated value as a JSON number or string based on the
+// column type.
+func jsonValue(v string, typ string) []byte {
+	switch baseType(typ) {
+	case "Int8", "Int16", "Int32", "Int64", "UInt8", "UInt16", "UInt32", "UInt64",
+		"Float32", "Float64", "Decimal":
+		return []byte(v)
+	case "Bool":
+		b, _ := strconv.ParseBool(v)
+		return []byte(strconv.FormatBool(b))
+	}
+	return quoteJSON(v)
+}
+
+// quoteJSON encodes a string as a JSON string without escaping the HTML
+// characters, which are common in the log events.
+func quoteJSON(s string) []byte {
+	var b bytes.Buffer
+	enc := json.NewEncoder(&b)
+	enc.SetEscapeHTML(false)
+	enc.Encode(s)
+	return bytes.TrimSuffix(b.Bytes(), []byte("\n"))
+}
+
+// tsvEscaper escapes the special characters of the TabSeparated format.
+var tsvEscaper = strings.NewReplacer(
+	`\`, `\\`,
+	"\t", `\t`,
+	"\n", `\n`,
+	"\r", `\r`,
+)
+
+// buildClickHouse fills in the default parameters and builds the HTTP client
+// and the INSERT query.
+func (c *ClickHouse) buildClickHouse() error {
+	if c.Table == "" {
+		return errNoTable
+	}
+	if len(c.Columns) == 0 {
+		return errNoColumns
+	}
+	if c.URL == "" {
+		c.URL = defaultCHURL
+	}
+	if c.Database == "" {
+		c.Database = defaultCHDatabase
+	}
+	if c.Format == "" {
+		c.Format = formatJSONEachRow
+	}
+	if c.Format != formatJSONEachRow && c.Format != formatTabSeparated {
+		return errors.Wrap(errUnknownFormat, c.Format)
+	}
+	if c.BatchSize <= 0 {
+		c.BatchSize = defaultCHBatchSize
+	}
+	if c.FlushInterval <= 0 {
+		c.FlushInterval = defaultCHFlushInterval
+	}
+	if c.MaxRetries == 0 {
+		c.MaxRetries = defaultCHMaxRetries
+	}
+	if c.RetryBackoff <= 0 {
+		c.RetryBackoff = defaultCHRetryBackoff
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = defaultCHTimeout
+	}
+
+	u, err := url.Parse(c.URL)
+	if err != nil {
+		return errors.Wrap(err, "build clickhouse")
+	}
+
+	var cols []string
+	for _, col := range c.Columns {
+		cols = append(cols, col.Name)
+	}
+	params := u.Query()
+	params.Set("query", fmt.Sprintf("INSERT INTO %s.%s (%s) FORMAT %s",
+		c.Database, c.Table, strings.Join(cols, ", "), c.Format))
+	if c.AsyncInsert {
+		params.Set("async_insert", "1")
+		params.Set("wait_for_async_insert", boolParam(c.WaitForAsyncInsert))
+	}
+	if c.BestEffortDateTime {
+		params.Set("date_time_input_format", "best_effort")
+	}
+	u.RawQuery = params.Encode()
+
+	c.query = u.String()
+	c.client = &http.Client{Timeout: time.Millisecond * time.Duration(c.Timeout)}
+	return nil
+}
+
+func boolParam(b bool) string {
+	if b {
+		return "1"
+	}
+	return "0"
+}
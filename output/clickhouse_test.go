@@ -0,0 +1,239 @@
+package output
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/jiwen624/logspout/metrics"
+)
+
+func testEvent() *Event {
+	return &Event{
+		Raw:    "<2018-01-01> <42> <GuoJing>",
+		Names:  []string{"", "timestamp", "", "thread", "", "user"},
+		Values: []string{"<", "2018-01-01", "> <", "42", "> <", "GuoJing"},
+	}
+}
+
+type insertRecorder struct {
+	sync.Mutex
+	queries []string
+	bodies  []string
+}
+
+func (r *insertRecorder) handler(status int, code string) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		body, _ := ioutil.ReadAll(req.Body)
+		r.Lock()
+		r.queries = append(r.queries, req.URL.Query().Get("query"))
+		r.bodies = append(r.bodies, string(body))
+		r.Unlock()
+		if code != "" {
+			w.Header().Set("X-ClickHouse-Exception-Code", code)
+		}
+		w.WriteHeader(status)
+	}
+}
+
+func TestClickHouse_Type(t *testing.T) {
+	c := &ClickHouse{}
+	assert.Equal(t, clickhouse, c.Type())
+	assert.Equal(t, id(c.String()), c.ID())
+}
+
+func TestClickHouse_WriteInactive(t *testing.T) {
+	c := &ClickHouse{Table: "logs"}
+	n, err := c.WriteEvent(testEvent())
+	assert.Equal(t, 0, n)
+	assert.Contains(t, err.Error(), errOutputNull.Error())
+	assert.NotNil(t, c.Deactivate())
+}
+
+func TestClickHouse_Activate(t *testing.T) {
+	c := &ClickHouse{}
+	assert.NotNil(t, c.Activate())
+
+	c = &ClickHouse{Table: "logs"}
+	assert.NotNil(t, c.Activate())
+
+	c = &ClickHouse{Table: "logs", Format: "CSV", Columns: []Column{{Name: "user"}}}
+	assert.NotNil(t, c.Activate())
+}
+
+func TestClickHouse_JSONEachRow(t *testing.T) {
+	rec := &insertRecorder{}
+	srv := httptest.NewServer(rec.handler(http.StatusOK, ""))
+	defer srv.Close()
+
+	c := &ClickHouse{
+		URL:         srv.URL,
+		Table:       "logs",
+		BatchSize:   2,
+		AsyncInsert: true,
+		Columns: []Column{
+			{Name: "ts", Field: "timestamp", Type: "String"},
+			{Name: "thread", Type: "UInt32"},
+			{Name: "user", Type: "LowCardinality(String)"},
+			{Name: "raw", Field: FieldRaw, Type: "String"},
+		},
+	}
+	assert.Nil(t, c.Activate())
+
+	// the lengths of the events are returned rather than the rows
+	e := testEvent()
+	n, err := c.WriteEvent(e)
+	assert.Nil(t, err)
+	assert.Equal(t, len(e.Raw), n)
+	_, err = c.WriteEvent(e)
+	assert.Nil(t, err)
+	_, err = c.WriteEvent(e)
+	assert.Nil(t, err)
+	assert.Nil(t, c.Deactivate())
+
+	assert.Len(t, rec.bodies, 2)
+	assert.Equal(t, "INSERT INTO default.logs (ts, thread, user, raw) FORMAT JSONEachRow", rec.queries[0])
+	row := `{"ts":"2018-01-01","thread":42,"user":"GuoJing","raw":"<2018-01-01> <42> <GuoJing>"}` + "\n"
+	assert.Equal(t, row+row, rec.bodies[0])
+	assert.Equal(t, row, rec.bodies[1])
+}
+
+func TestClickHouse_TabSeparated(t *testing.T) {
+	c := &ClickHouse{
+		Format:  formatTabSeparated,
+		Columns: []Column{{Name: "user"}, {Name: "x", Field: FieldRaw}},
+	}
+	e := testEvent()
+	e.Raw = "a\tb\nc\\"
+	row, err := c.encodeRow(e)
+	assert.Nil(t, err)
+	assert.Equal(t, "GuoJing\ta\\tb\\nc\\\\\n", string(row))
+}
+
+func TestClickHouse_Write(t *testing.T) {
+	rec := &insertRecorder{}
+	srv := httptest.NewServer(rec.handler(http.StatusOK, ""))
+	defer srv.Close()
+
+	c := &ClickHouse{
+		URL:     srv.URL,
+		Table:   "logs",
+		Format:  formatTabSeparated,
+		Columns: []Column{{Name: "raw", Field: FieldRaw}},
+	}
+	assert.Nil(t, c.Activate())
+	// the row is longer than the event as the tab is escaped
+	n, err := c.Write([]byte("a\tb"))
+	assert.Nil(t, err)
+	assert.Equal(t, 3, n)
+	assert.Nil(t, c.Deactivate())
+	assert.Equal(t, []string{"a\\tb\n"}, rec.bodies)
+}
+
+func TestClickHouse_InvalidValue(t *testing.T) {
+	c := &ClickHouse{Format: formatJSONEachRow, Columns: []Column{{Name: "user", Type: "Int64"}}}
+	_, err := c.encodeRow(testEvent())
+	assert.Contains(t, err.Error(), errInvalidColValue.Error())
+
+	c = &ClickHouse{Format: formatJSONEachRow, Columns: []Column{{Name: "nonexist"}}}
+	_, err = c.encodeRow(testEvent())
+	assert.Contains(t, err.Error(), errFieldNotFound.Error())
+}
+
+func TestClickHouse_Retry(t *testing.T) {
+	rec := &insertRecorder{}
+	srv := httptest.NewServer(rec.handler(http.StatusInternalServerError, "252"))
+	defer srv.Close()
+
+	c := &ClickHouse{
+		URL:          srv.URL,
+		Table:        "logs",
+		BatchSize:    1,
+		MaxRetries:   2,
+		RetryBackoff: 1,
+		Columns:      []Column{{Name: "user"}},
+	}
+	assert.Nil(t, c.Activate())
+	// the batch is sent in the background
+	_, err := c.WriteEvent(testEvent())
+	assert.Nil(t, err)
+	c.Deactivate()
+	assert.Len(t, rec.bodies, 3)
+	ss := metrics.Output(c.String()).Snapshot()
+	assert.Equal(t, int64(2), ss.Retries)
+	assert.Equal(t, int64(1), ss.Drops)
+
+	// not retriable
+	rec = &insertRecorder{}
+	srv2 := httptest.NewServer(rec.handler(http.StatusBadRequest, "62"))
+	defer srv2.Close()
+	c.URL = srv2.URL
+	assert.Nil(t, c.Activate())
+	_, err = c.WriteEvent(testEvent())
+	assert.Nil(t, err)
+	c.Deactivate()
+	assert.Len(t, rec.bodies, 1)
+	ss = metrics.Output(c.String()).Snapshot()
+	assert.Equal(t, int64(0), ss.Retries)
+	assert.Equal(t, int64(1), ss.Drops)
+}
+
+func TestClickHouse_SlowServer(t *testing.T) {
+	rec := &insertRecorder{}
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		<-release
+		rec.handler(http.StatusOK, "")(w, req)
+	}))
+	defer srv.Close()
+
+	c := &ClickHouse{URL: srv.URL, Table: "logs", BatchSize: 1, Columns: []Column{{Name: "user"}}}
+	assert.Nil(t, c.Activate())
+
+	// the writes aren't held up by the inserts
+	written := make(chan struct{})
+	go func() {
+		for i := 0; i < pendingBatches; i++ {
+			_, err := c.WriteEvent(testEvent())
+			assert.Nil(t, err)
+		}
+		close(written)
+	}()
+	select {
+	case <-written:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the writes are blocked by the server")
+	}
+
+	close(release)
+	assert.Nil(t, c.Deactivate())
+	assert.Len(t, rec.bodies, pendingBatches)
+}
+
+func TestRetriable(t *testing.T) {
+	assert.False(t, retriable(errors.New("hello")))
+	assert.True(t, retriable(&clickHouseError{status: http.StatusServiceUnavailable}))
+	assert.True(t, retriable(errors.Wrap(&clickHouseError{status: 500, code: 241}, "x")))
+	assert.False(t, retriable(&clickHouseError{status: 500, code: 62}))
+}
+
+func TestBaseType(t *testing.T) {
+	cases := map[string]string{
+		"UInt32":                   "UInt32",
+		"Nullable(Decimal(10, 2))": "Decimal",
+		"LowCardinality(String)":   "String",
+		"DateTime64(3)":            "DateTime64",
+	}
+	for in, out := range cases {
+		assert.Equal(t, out, baseType(in), in)
+	}
+	assert.True(t, strings.HasPrefix(string(jsonValue("1.5", "Float64")), "1.5"))
+	assert.Equal(t, `"1.5"`, string(jsonValue("1.5", "String")))
+}
@@ -0,0 +1,180 @@
+package output
+
+import (
+	"bytes"
+	"encoding/json"
+	"strconv"
+	"time"
+)
+
+// Pseudo fields which are not capture groups of a pattern but can be looked up
+// from an event in the same way.
+const (
+	// FieldRaw is the whole rendered log event.
+	FieldRaw = "_raw"
+	// FieldLogType is the LogType of the spout which generated the event.
+	FieldLogType = "_logType"
+	// FieldTime is the time when the event was generated.
+	FieldTime = "_time"
+	// FieldSeed is the index of the seed log the event was generated from.
+	FieldSeed = "_seed"
+)
+
+// The formats the events are encoded in for the clients, see EncodeEvent.
+const (
+	// FormatJSON is an EventMessage in JSON.
+	FormatJSON = "json"
+	// FormatRaw is the rendered log event as it is.
+	FormatRaw = "raw"
+)
+
+// EventMessage is an event in the json format.
+type EventMessage struct {
+	Time    time.Time         `json:"time"`
+	Seed    int               `json:"seed"`
+	LogType string            `json:"logType,omitempty"`
+	Raw     string            `json:"raw"`
+	Fields  map[string]string `json:"fields,omitempty"`
+}
+
+// EncodeEvent encodes the event in the format, which is either FormatJSON or
+// FormatRaw.
+func EncodeEvent(e *Event, format string) []byte {
+	if format == FormatRaw {
+		return []byte(e.Raw)
+	}
+
+	m := EventMessage{Time: e.Time, Seed: e.Seed, LogType: e.LogType, Raw: e.Raw, Fields: e.Fields()}
+	var b bytes.Buffer
+	enc := json.NewEncoder(&b)
+	enc.SetEscapeHTML(false)
+	enc.Encode(m)
+	return bytes.TrimSuffix(b.Bytes(), []byte("\n"))
+}
+
+// Kind is the kind of the value of a capture group, which is decided by the
+// replacer generating the value.
+type Kind int
+
+const (
+	// KindString is a value of any text.
+	KindString Kind = iota
+	// KindInteger is a value generated by an integer replacer.
+	KindInteger
+	// KindFloat is a value generated by a float replacer.
+	KindFloat
+)
+
+// Event is a generated log event. Besides the rendered string it carries the
+// capture groups it was built from, so that an output can store the event in a
+// structured way (e.g., a column per capture group).
+//
+// Names and Values are owned by the worker which generated the event and will
+// be overwritten after the write returns. An output must copy them if it needs
+// to keep them for longer.
+type Event struct {
+	// Raw is the rendered log event.
+	Raw string
+	// LogType is the type of the logs, e.g., the application name.
+	LogType string
+	// Time is the time when the event was generated.
+	Time time.Time
+	// Seed is the index of the seed log, as well as its pattern, which the
+	// event was generated from.
+	Seed int
+	// Names are the capture group names of the pattern, unnamed groups are empty
+	// strings.
+	Names []string
+	// Values are the values of the capture groups, in the same order as Names.
+	Values []string
+	// Kinds are the kinds of the capture groups keyed by their names, the
+	// groups not in it are strings. It's shared by all the events of a spout
+	// and must not be modified.
+	Kinds map[string]Kind
+}
+
+// Field returns the value of a named capture group or a pseudo field. The second
+// return value reports whether the field was found.
+func (e *Event) Field(name string) (string, bool) {
+	switch name {
+	case FieldRaw:
+		return e.Raw, true
+	case FieldLogType:
+		return e.LogType, true
+	case FieldTime:
+		return e.Time.Format(time.RFC3339Nano), true
+	case FieldSeed:
+		return strconv.Itoa(e.Seed), true
+	}
+
+	if name == "" {
+		return "", false
+	}
+	for i, n := range e.Names {
+		if n == name && i < len(e.Values) {
+			return e.Values[i], true
+		}
+	}
+	return "", false
+}
+
+// Fields returns the named capture groups and their values, it's nil if there
+// is none.
+func (e *Event) Fields() map[string]string {
+	var m map[string]string
+	for i, name := range e.Names {
+		if name != "" && i < len(e.Values) {
+			if m == nil {
+				m = make(map[string]string)
+			}
+			m[name] = e.Values[i]
+		}
+	}
+	return m
+}
+
+// Matches tells if the event matches the filters, which are the values wanted
+// keyed by the fields. The event matches if each of the fields has one of the
+// values wanted.
+func (e *Event) Matches(filters map[string][]string) bool {
+	for f, vs := range filters {
+		v, ok := e.Field(f)
+		if !ok {
+			return false
+		}
+		var found bool
+		for _, want := range vs {
+			if v == want {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// Kind returns the kind of a capture group, a pseudo field is always a string.
+func (e *Event) Kind(name string) Kind {
+	return e.Kinds[name]
+}
+
+// EventWriter is implemented by the outputs which consume the capture groups of
+// an event rather than only its rendered string. The registry prefers WriteEvent
+// over Write when an output implements both.
+type EventWriter interface {
+	// WriteEvent writes the event to the output destination and returns the
+	// number of bytes written.
+	WriteEvent(e *Event) (n int, err error)
+}
+
+// writeEvent writes the event to the output via WriteEvent if it's supported,
+// otherwise it falls back to Write with the rendered string.
+func writeEvent(o Output, e *Event) (int, error) {
+	if ew, ok := o.(EventWriter); ok {
+		return ew.WriteEvent(e)
+	}
+	return o.Write([]byte(e.Raw))
+}
@@ -0,0 +1,52 @@
+package output
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEvent_Field(t *testing.T) {
+	e := testEvent()
+	e.LogType = "weblogic"
+	e.Time = time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	v, ok := e.Field("thread")
+	assert.True(t, ok)
+	assert.Equal(t, "42", v)
+
+	v, ok = e.Field(FieldRaw)
+	assert.True(t, ok)
+	assert.Equal(t, e.Raw, v)
+
+	v, ok = e.Field(FieldLogType)
+	assert.True(t, ok)
+	assert.Equal(t, "weblogic", v)
+
+	v, ok = e.Field(FieldTime)
+	assert.True(t, ok)
+	assert.Equal(t, "2018-01-01T00:00:00Z", v)
+
+	_, ok = e.Field("")
+	assert.False(t, ok)
+	_, ok = e.Field("nonexist")
+	assert.False(t, ok)
+}
+
+func TestEncodeEvent(t *testing.T) {
+	e := testEvent()
+	e.Seed = 1
+	e.Time = time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	assert.Equal(t, e.Raw, string(EncodeEvent(e, FormatRaw)))
+	assert.Equal(t, `{"time":"2018-01-01T00:00:00Z","seed":1,"raw":"<2018-01-01> <42> <GuoJing>",`+
+		`"fields":{"thread":"42","timestamp":"2018-01-01","user":"GuoJing"}}`, string(EncodeEvent(e, FormatJSON)))
+}
+
+func TestWriteEvent(t *testing.T) {
+	d := Discard{}
+	n, err := writeEvent(d, testEvent())
+	assert.Nil(t, err)
+	assert.Equal(t, len(testEvent().Raw), n)
+}
@@ -0,0 +1,160 @@
+package output
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	con "github.com/jiwen624/logspout/console"
+	"github.com/jiwen624/logspout/log"
+)
+
+// EndPoint is the path of the output management endpoints of the console:
+//
+//	GET    /outputs                      lists the outputs
+//	GET    /outputs?id=<id>              describes an output
+//	POST   /outputs[?activate=false]     adds an output from a JSON body in the
+//	                                     same format as the config file, e.g.,
+//	                                     {"type": "file", "attrs": {...}}
+//	DELETE /outputs?id=<id>              deactivates and removes an output
+//	POST   /outputs/activate?id=<id>     activates an output
+//	POST   /outputs/deactivate?id=<id>   deactivates an output
+const EndPoint = "/outputs"
+
+// the maximum size of the body to add an output
+const maxOutputBody = 1 << 20
+
+// Handler returns the HTTP handler of the output management endpoints. It
+// should be registered to both EndPoint and EndPoint + "/".
+func Handler(r *Registry) http.Handler {
+	return &handler{r: r}
+}
+
+type handler struct {
+	r *Registry
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	id := ID(req.URL.Query().Get("id"))
+
+	switch strings.TrimSuffix(req.URL.Path, "/") {
+	case EndPoint:
+		switch req.Method {
+		case http.MethodGet:
+			if id == "" {
+				con.WriteJSON(w, http.StatusOK, h.r.List())
+				return
+			}
+			info, err := h.r.Info(id)
+			if err != nil {
+				con.WriteError(w, errorStatus(err), err)
+				return
+			}
+			con.WriteJSON(w, http.StatusOK, info)
+		case http.MethodPost:
+			h.add(w, req)
+		case http.MethodDelete:
+			if err := h.r.Remove(id); err != nil {
+				con.WriteError(w, errorStatus(err), err)
+				return
+			}
+			log.Infof("Removed output %s through the console", id)
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			con.WriteStatus(w, http.StatusMethodNotAllowed)
+		}
+
+	case EndPoint + "/activate", EndPoint + "/deactivate":
+		if req.Method != http.MethodPost {
+			con.WriteStatus(w, http.StatusMethodNotAllowed)
+			return
+		}
+		if strings.HasSuffix(req.URL.Path, "/activate") {
+			if err := h.r.Activate(id); err != nil {
+				con.WriteError(w, activateStatus(err), err)
+				return
+			}
+		} else if err := h.r.Deactivate(id); err != nil {
+			con.WriteError(w, errorStatus(err), err)
+			return
+		}
+		info, err := h.r.Info(id)
+		if err != nil {
+			con.WriteError(w, errorStatus(err), err)
+			return
+		}
+		con.WriteJSON(w, http.StatusOK, info)
+
+	default:
+		con.WriteStatus(w, http.StatusNotFound)
+	}
+}
+
+// add creates an output from the request body, registers and activates it.
+func (h *handler) add(w http.ResponseWriter, req *http.Request) {
+	var wp Wrapper
+	dec := json.NewDecoder(io.LimitReader(req.Body, maxOutputBody))
+	if err := dec.Decode(&wp); err != nil {
+		con.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+	o, err := New(wp)
+	if err != nil {
+		con.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	// The output is keyed by the ID it's registered with, which may differ
+	// from its ID after the activation fills in the defaults.
+	id := o.ID()
+	if err := h.r.Register(o); err != nil {
+		con.WriteError(w, errorStatus(err), err)
+		return
+	}
+	if req.URL.Query().Get("activate") != "false" {
+		if err := h.r.Activate(id); err != nil {
+			// Don't leave an output which can't be activated behind.
+			h.r.Remove(id)
+			con.WriteError(w, activateStatus(err), err)
+			return
+		}
+	}
+	log.Infof("Added output %s through the console", o)
+
+	info, err := h.r.Info(id)
+	if err != nil {
+		con.WriteError(w, errorStatus(err), err)
+		return
+	}
+	con.WriteJSON(w, http.StatusCreated, info)
+}
+
+// errorStatus returns the status code of the cause of the error.
+func errorStatus(err error) int {
+	status := http.StatusInternalServerError
+	switch errors.Cause(err) {
+	case ErrNotFound, ErrEmptyRegistry:
+		status = http.StatusNotFound
+	case ErrDuplicate, ErrAlreadyActive, ErrNotActive:
+		status = http.StatusConflict
+	case errUnknownType, errNoTable, errNoColumns, errUnknownFormat,
+		errUnknownTransport, errUnknownPrecision, errNoBucket, errUnknownCodec,
+		errUnknownColType, errUnknownProtocol, errInvalidAddr, errAddrFamily,
+		errUnknownWSFormat:
+		status = http.StatusBadRequest
+	}
+	return status
+}
+
+// activateStatus returns the status code of an output failing to be activated.
+// Other than the state of the registry, the failure is caused by the config
+// from the client, e.g., a bad path or an unreachable address.
+func activateStatus(err error) int {
+	if status := errorStatus(err); status != http.StatusInternalServerError {
+		return status
+	}
+	return http.StatusBadRequest
+}
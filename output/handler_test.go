@@ -0,0 +1,144 @@
+package output
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func serve(h http.Handler, method, target, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(method, target, strings.NewReader(body)))
+	return w
+}
+
+func TestHandler(t *testing.T) {
+	r := NewRegistry()
+	h := Handler(r)
+
+	w := serve(h, http.MethodGet, EndPoint, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "[]\n", w.Body.String())
+
+	// add an output and activate it
+	w = serve(h, http.MethodPost, EndPoint, `{"type": "discard", "attrs": {}}`)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	var info Info
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &info))
+	assert.Equal(t, discard, info.Type)
+	assert.True(t, info.Active)
+	assert.True(t, r.IsActive(info.ID))
+
+	w = serve(h, http.MethodPost, EndPoint, `{"type": "discard"}`)
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	// the IDs must be escaped in the query
+	q := "?id=" + url.QueryEscape(string(info.ID))
+	w = serve(h, http.MethodGet, EndPoint+q, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = serve(h, http.MethodPost, EndPoint+"/deactivate"+q, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.False(t, r.IsActive(info.ID))
+	w = serve(h, http.MethodPost, EndPoint+"/deactivate"+q, "")
+	assert.Equal(t, http.StatusConflict, w.Code)
+	w = serve(h, http.MethodPost, EndPoint+"/activate"+q, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.True(t, r.IsActive(info.ID))
+
+	w = serve(h, http.MethodGet, EndPoint, "")
+	var infos []Info
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &infos))
+	assert.Len(t, infos, 1)
+
+	w = serve(h, http.MethodDelete, EndPoint+q, "")
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, 0, r.Size())
+	w = serve(h, http.MethodDelete, EndPoint+q, "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestHandlerBadRequest(t *testing.T) {
+	r := NewRegistry()
+	h := Handler(r)
+
+	for _, body := range []string{`{`, `{"type": "nonexist"}`, `{"type": "file", "attrs": {"maxSize": "x"}}`} {
+		w := serve(h, http.MethodPost, EndPoint, body)
+		assert.Equal(t, http.StatusBadRequest, w.Code, body)
+	}
+
+	// an output failing to activate for its config isn't kept
+	for _, body := range []string{
+		`{"type": "influx", "attrs": {"transport": "tcp"}}`,
+		`{"type": "clickhouse", "attrs": {"columns": ["a"]}}`,
+		`{"type": "pcap", "attrs": {"directory": "/nonexist/pcap"}}`,
+	} {
+		w := serve(h, http.MethodPost, EndPoint, body)
+		assert.Equal(t, http.StatusBadRequest, w.Code, body)
+	}
+	assert.Equal(t, 0, r.Size())
+
+	w := serve(h, http.MethodPost, EndPoint+"?activate=false", `{"type": "pcap", "attrs": {"directory": "/nonexist/pcap"}}`)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	var info Info
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &info))
+	q := "?id=" + url.QueryEscape(string(info.ID))
+	w = serve(h, http.MethodPost, EndPoint+"/activate"+q, "")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Nil(t, r.Remove(info.ID))
+
+	// added without activation
+	w = serve(h, http.MethodPost, EndPoint+"?activate=false", `{"type": "discard"}`)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Contains(t, w.Body.String(), `"active":false`)
+
+	w = serve(h, http.MethodGet, EndPoint+"/activate", "")
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+	w = serve(h, http.MethodPut, EndPoint, "")
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+	w = serve(h, http.MethodGet, EndPoint+"/nonexist", "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestHandlerAddDefaulted(t *testing.T) {
+	dir, err := ioutil.TempDir("", "handler")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	r := NewRegistry()
+	h := Handler(r)
+	defer r.DeactivateAll()
+
+	// the file name is filled in by the activation, which changes the ID
+	w := serve(h, http.MethodPost, EndPoint, `{"type": "file", "attrs": {"directory": "`+dir+`"}}`)
+	assert.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	var info Info
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &info))
+	assert.Equal(t, file, info.Type)
+	assert.True(t, info.Active)
+	assert.Contains(t, info.Desc, defaultFileName)
+
+	w = serve(h, http.MethodGet, EndPoint+"?id="+url.QueryEscape(string(info.ID)), "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.True(t, r.IsActive(info.ID))
+}
+
+func TestHandlerServerError(t *testing.T) {
+	r := NewRegistry()
+	h := Handler(r)
+
+	o := &stuckOutput{}
+	assert.Nil(t, r.Register(o))
+	assert.Nil(t, r.Activate(o.ID()))
+
+	// a failure of the output itself isn't the client's fault
+	w := serve(h, http.MethodPost, EndPoint+"/deactivate?id="+url.QueryEscape(string(o.ID())), "")
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+}
@@ -0,0 +1,535 @@
+package output
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/vjeantet/jodaTime"
+
+	"github.com/jiwen624/logspout/log"
+	"github.com/jiwen624/logspout/utils"
+)
+
+// Influx turns the events into the InfluxDB line protocol. The measurement is
+// the LogType of the spout, the tags and the fields are the capture groups, and
+// the values generated by the integer and float replacers are numeric fields.
+// The lines are written to a file, sent over UDP, or sent to the /api/v2/write
+// endpoint of InfluxDB.
+type Influx struct {
+	// Transport is either http (the default), udp or file.
+	Transport string `json:"transport"`
+	// URL is the address of InfluxDB for the http transport, e.g.,
+	// http://localhost:8086
+	URL    string `json:"url"`
+	Org    string `json:"org"`
+	Bucket string `json:"bucket"`
+	Token  string `json:"token"`
+	// Host is the address of the UDP listener for the udp transport.
+	Host string `json:"host"`
+	// FileName and Directory are the file for the file transport, the lines
+	// are appended to it.
+	FileName  string `json:"fileName"`
+	Directory string `json:"directory"`
+
+	// Measurement overrides the LogType as the measurement name.
+	Measurement string `json:"measurement"`
+	// Tags are the capture groups stored as tags.
+	Tags []string `json:"tags"`
+	// Fields are the capture groups (or pseudo fields such as _raw) stored as
+	// fields. It defaults to all the named capture groups which are neither
+	// tags nor the time field.
+	Fields []string `json:"fields"`
+	// TimeField is the capture group of the timestamp, the time when the event
+	// was generated is used if it's not set.
+	TimeField string `json:"timeField"`
+	// TimeFormat is the format of TimeField in Joda-Time syntax, RFC 3339 is
+	// expected if it's not set.
+	TimeFormat string `json:"timeFormat"`
+	// Precision of the timestamps, which can be ns (the default), us, ms or s.
+	Precision string `json:"precision"`
+
+	// BatchSize is the maximum number of lines sent at once.
+	BatchSize int `json:"batchSize"`
+	// FlushInterval is the maximum time in milliseconds a line stays in the
+	// batch before it's sent.
+	FlushInterval int `json:"flushInterval"`
+	// MaxRetries is the number of times a batch is retried on retriable errors,
+	// a negative value disables the retries.
+	MaxRetries int `json:"maxRetries"`
+	// RetryBackoff is the initial backoff in milliseconds between retries, it's
+	// doubled after each retry.
+	RetryBackoff int `json:"retryBackoff"`
+	// Timeout is the timeout in milliseconds of a single HTTP request.
+	Timeout int `json:"timeout"`
+
+	sink lineSink
+	unit time.Duration
+
+	// the lines are sent in batches in the background
+	batcher
+}
+
+// the transports of the lines
+const (
+	transportHTTP = "http"
+	transportUDP  = "udp"
+	transportFile = "file"
+)
+
+// default parameters
+const (
+	defaultInfluxURL           = "http://localhost:8086"
+	defaultInfluxHost          = "localhost:8089"
+	defaultInfluxFileName      = "logspout.lp"
+	defaultInfluxMeasurement   = "logspout"
+	defaultInfluxBatchSize     = 1000
+	defaultInfluxFlushInterval = 1000 // milliseconds
+	defaultInfluxMaxRetries    = 3
+	defaultInfluxRetryBackoff  = 100  // milliseconds
+	defaultInfluxTimeout       = 5000 // milliseconds
+	// the field storing the whole event if no field is available
+	influxMessageField = "message"
+	// the maximum payload of a UDP packet, which avoids IP fragmentation
+	maxInfluxUDPPayload = 1400
+)
+
+var precisions = map[string]time.Duration{
+	"ns": time.Nanosecond,
+	"us": time.Microsecond,
+	"ms": time.Millisecond,
+	"s":  time.Second,
+}
+
+var (
+	errUnknownTransport = errors.New("unknown transport")
+	errUnknownPrecision = errors.New("unknown precision")
+	errNoBucket         = errors.New("no org or bucket specified")
+)
+
+// influxError is an error returned by the InfluxDB server.
+type influxError struct {
+	status int
+	code   string
+	msg    string
+}
+
+func (e *influxError) Error() string {
+	return fmt.Sprintf("influx: status %d, code %s: %s", e.status, e.code, e.msg)
+}
+
+func (e *influxError) retriable() bool {
+	switch e.status {
+	case http.StatusTooManyRequests, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+func (i *Influx) String() string {
+	addr := i.URL
+	switch i.Transport {
+	case transportUDP:
+		addr = i.Host
+	case transportFile:
+		addr = filepath.Join(i.Directory, i.FileName)
+	}
+	return fmt.Sprintf("Influx{Transport:%s, Addr:%s, Bucket:%s}", i.Transport, addr, i.Bucket)
+}
+
+func (i *Influx) ID() ID {
+	return id(i.String())
+}
+
+func (i *Influx) Type() Type {
+	return influx
+}
+
+// Write writes a log event which has no capture groups, it's stored in the
+// field message.
+func (i *Influx) Write(p []byte) (n int, err error) {
+	return i.WriteEvent(&Event{Raw: string(p), Time: time.Now()})
+}
+
+// WriteEvent encodes the event as a line and adds it to the current batch. The
+// batch is handed to the sender when it's full, so the writes of the sink and
+// their retries don't hold up the writes.
+func (i *Influx) WriteEvent(e *Event) (n int, err error) {
+	if i.sink == nil {
+		return 0, errors.Wrap(errOutputNull, i.String())
+	}
+
+	line, err := i.encodeLine(e)
+	if err != nil {
+		return 0, errors.Wrap(err, i.String())
+	}
+
+	i.add(line)
+	return len(e.Raw), nil
+}
+
+// encodeLine encodes an event as a line of the line protocol.
+func (i *Influx) encodeLine(e *Event) ([]byte, error) {
+	var b bytes.Buffer
+
+	m := i.Measurement
+	if m == "" {
+		m = e.LogType
+	}
+	if m == "" {
+		m = defaultInfluxMeasurement
+	}
+	b.WriteString(measurementEscaper.Replace(m))
+
+	// the tags are sorted by the keys as InfluxDB recommends
+	tags := make([]string, len(i.Tags))
+	copy(tags, i.Tags)
+	sort.Strings(tags)
+	for _, t := range tags {
+		// a tag without value is omitted
+		if v, ok := e.Field(t); ok && v != "" {
+			b.WriteByte(',')
+			b.WriteString(keyEscaper.Replace(t))
+			b.WriteByte('=')
+			b.WriteString(keyEscaper.Replace(v))
+		}
+	}
+
+	fields := i.Fields
+	if len(fields) == 0 {
+		for _, name := range e.Names {
+			if name != "" && name != i.TimeField && utils.StrIndex(i.Tags, name) == -1 &&
+				utils.StrIndex(fields, name) == -1 {
+				fields = append(fields, name)
+			}
+		}
+	}
+
+	sep := byte(' ')
+	for _, f := range fields {
+		v, ok := e.Field(f)
+		if !ok {
+			continue
+		}
+		fv, err := fieldValue(f, v, e.Kind(f))
+		if err != nil {
+			return nil, err
+		}
+		b.WriteByte(sep)
+		b.WriteString(keyEscaper.Replace(f))
+		b.WriteByte('=')
+		b.WriteString(fv)
+		sep = ','
+	}
+	// a line must have at least one field
+	if sep == ' ' {
+		b.WriteByte(sep)
+		b.WriteString(influxMessageField)
+		b.WriteByte('=')
+		b.WriteString(quoteFieldString(e.Raw))
+	}
+
+	t, err := i.timestamp(e)
+	if err != nil {
+		return nil, err
+	}
+	b.WriteByte(' ')
+	b.WriteString(strconv.FormatInt(t.UnixNano()/int64(i.unit), 10))
+	b.WriteByte('\n')
+	return b.Bytes(), nil
+}
+
+// fieldValue encodes a field value based on the kind of the capture group.
+func fieldValue(name, v string, kind Kind) (string, error) {
+	var err error
+	switch kind {
+	case KindInteger:
+		if _, err = strconv.ParseInt(v, 10, 64); err == nil {
+			return v + "i", nil
+		}
+	case KindFloat:
+		if _, err = strconv.ParseFloat(v, 64); err == nil {
+			return v, nil
+		}
+	default:
+		return quoteFieldString(v), nil
+	}
+	return "", errors.Wrap(errInvalidColValue, fmt.Sprintf("%s: %q", name, v))
+}
+
+// timestamp returns the time of the line.
+func (i *Influx) timestamp(e *Event) (time.Time, error) {
+	if i.TimeField == "" {
+		if e.Time.IsZero() {
+			return time.Now(), nil
+		}
+		return e.Time, nil
+	}
+
+	v, ok := e.Field(i.TimeField)
+	if !ok {
+		return time.Time{}, errors.Wrap(errFieldNotFound, i.TimeField)
+	}
+	var t time.Time
+	var err error
+	if i.TimeFormat != "" {
+		t, err = jodaTime.Parse(i.TimeFormat, v)
+	} else {
+		t, err = time.Parse(time.RFC3339Nano, v)
+	}
+	if err != nil {
+		return time.Time{}, errors.Wrap(errInvalidColValue, fmt.Sprintf("%s: %q", i.TimeField, v))
+	}
+	return t, nil
+}
+
+// The escaping rules of the line protocol. A line feed is not allowed anywhere
+// in a line, so it's escaped as \n.
+var (
+	measurementEscaper = strings.NewReplacer(
+		`,`, `\,`,
+		` `, `\ `,
+		"\n", `\n`,
+	)
+	keyEscaper = strings.NewReplacer(
+		`,`, `\,`,
+		`=`, `\=`,
+		` `, `\ `,
+		"\n", `\n`,
+	)
+	stringEscaper = strings.NewReplacer(
+		`"`, `\"`,
+		`\`, `\\`,
+		"\n", `\n`,
+	)
+)
+
+func quoteFieldString(s string) string {
+	return `"` + stringEscaper.Replace(s) + `"`
+}
+
+func (i *Influx) Activate() error {
+	log.Infof("Activating output %s", i)
+
+	if err := i.buildInflux(); err != nil {
+		return errors.Wrap(err, "activate influx")
+	}
+
+	i.start(i.String(), i.BatchSize, i.MaxRetries,
+		time.Millisecond*time.Duration(i.RetryBackoff),
+		time.Millisecond*time.Duration(i.FlushInterval), i.sink.send)
+	return nil
+}
+
+func (i *Influx) Deactivate() error {
+	if i.sink == nil {
+		return errors.Wrap(errOutputNull, i.String())
+	}
+	log.Infof("Deactivating output %s", i)
+
+	err := i.stop()
+	if e := i.sink.close(); err == nil {
+		err = e
+	}
+	i.sink = nil
+	return errors.Wrap(err, "deactivate influx")
+}
+
+// lineSink is where the batches of lines are sent to.
+type lineSink interface {
+	send(batch []byte) error
+	close() error
+}
+
+// influxHTTP sends the lines to the /api/v2/write endpoint.
+type influxHTTP struct {
+	client *http.Client
+	url    string
+	token  string
+}
+
+func (h *influxHTTP) send(batch []byte) error {
+	req, err := http.NewRequest(http.MethodPost, h.url, bytes.NewReader(batch))
+	if err != nil {
+		return errors.Wrap(err, "write")
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if h.token != "" {
+		req.Header.Set("Authorization", "Token "+h.token)
+	}
+
+	rsp, err := h.client.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "write")
+	}
+	defer rsp.Body.Close()
+
+	body, _ := ioutil.ReadAll(io.LimitReader(rsp.Body, 4096))
+	if rsp.StatusCode/100 == 2 {
+		return nil
+	}
+
+	e := &influxError{status: rsp.StatusCode, msg: strings.TrimSpace(string(body))}
+	var r struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	}
+	if json.Unmarshal(body, &r) == nil && r.Message != "" {
+		e.code, e.msg = r.Code, r.Message
+	}
+	return e
+}
+
+func (h *influxHTTP) close() error {
+	return nil
+}
+
+// influxUDP sends the lines over UDP, a batch is split into packets at the
+// line boundaries.
+type influxUDP struct {
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+func (u *influxUDP) send(batch []byte) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	for len(batch) > 0 {
+		n := len(batch)
+		if n > maxInfluxUDPPayload {
+			// a line longer than the limit is sent in its own packet
+			n = bytes.LastIndexByte(batch[:maxInfluxUDPPayload], '\n') + 1
+			if n == 0 {
+				n = bytes.IndexByte(batch, '\n') + 1
+			}
+		}
+		if _, err := u.conn.Write(batch[:n]); err != nil {
+			return errors.Wrap(err, "write")
+		}
+		batch = batch[n:]
+	}
+	return nil
+}
+
+func (u *influxUDP) close() error {
+	return u.conn.Close()
+}
+
+// influxFile appends the lines to a file.
+type influxFile struct {
+	mu sync.Mutex
+	f  *os.File
+}
+
+func (f *influxFile) send(batch []byte) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	_, err := f.f.Write(batch)
+	return errors.Wrap(err, "write")
+}
+
+func (f *influxFile) close() error {
+	return f.f.Close()
+}
+
+// buildInflux fills in the default parameters and builds the sink of the
+// transport.
+func (i *Influx) buildInflux() error {
+	if i.Transport == "" {
+		i.Transport = transportHTTP
+	}
+	if i.Precision == "" {
+		i.Precision = "ns"
+	}
+	unit, ok := precisions[i.Precision]
+	if !ok {
+		return errors.Wrap(errUnknownPrecision, i.Precision)
+	}
+	if i.BatchSize <= 0 {
+		i.BatchSize = defaultInfluxBatchSize
+	}
+	if i.FlushInterval <= 0 {
+		i.FlushInterval = defaultInfluxFlushInterval
+	}
+	if i.MaxRetries == 0 {
+		i.MaxRetries = defaultInfluxMaxRetries
+	}
+	if i.RetryBackoff <= 0 {
+		i.RetryBackoff = defaultInfluxRetryBackoff
+	}
+	if i.Timeout <= 0 {
+		i.Timeout = defaultInfluxTimeout
+	}
+
+	switch i.Transport {
+	case transportHTTP:
+		if i.Org == "" || i.Bucket == "" {
+			return errNoBucket
+		}
+		if i.URL == "" {
+			i.URL = defaultInfluxURL
+		}
+		u, err := url.Parse(i.URL)
+		if err != nil {
+			return errors.Wrap(err, "build influx")
+		}
+		u.Path = strings.TrimSuffix(u.Path, "/") + "/api/v2/write"
+		params := u.Query()
+		params.Set("org", i.Org)
+		params.Set("bucket", i.Bucket)
+		params.Set("precision", i.Precision)
+		u.RawQuery = params.Encode()
+
+		i.sink = &influxHTTP{
+			client: &http.Client{Timeout: time.Millisecond * time.Duration(i.Timeout)},
+			url:    u.String(),
+			token:  i.Token,
+		}
+
+	case transportUDP:
+		if i.Host == "" {
+			i.Host = defaultInfluxHost
+		}
+		conn, err := net.Dial("udp", i.Host)
+		if err != nil {
+			return errors.Wrap(err, "build influx")
+		}
+		i.sink = &influxUDP{conn: conn}
+
+	case transportFile:
+		if i.FileName == "" {
+			i.FileName = defaultInfluxFileName
+		}
+		if i.Directory == "" {
+			i.Directory = defaultDir
+		}
+		f, err := os.OpenFile(filepath.Join(i.Directory, i.FileName),
+			os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return errors.Wrap(err, "build influx")
+		}
+		i.sink = &influxFile{f: f}
+
+	default:
+		return errors.Wrap(errUnknownTransport, i.Transport)
+	}
+
+	i.unit = unit
+	return nil
+}
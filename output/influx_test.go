@@ -0,0 +1,252 @@
+package output
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/jiwen624/logspout/metrics"
+)
+
+// influxEvent returns an event with numeric capture groups.
+func influxEvent() *Event {
+	e := testEvent()
+	e.LogType = "weblogic"
+	e.Time = time.Unix(1514764800, 0)
+	e.Names = append(e.Names, "", "latency", "", "host")
+	e.Values = append(e.Values, " ", "0.25", " ", "web 1")
+	e.Kinds = map[string]Kind{"thread": KindInteger, "latency": KindFloat}
+	return e
+}
+
+func TestInflux_Type(t *testing.T) {
+	i := &Influx{}
+	assert.Equal(t, influx, i.Type())
+	assert.Equal(t, id(i.String()), i.ID())
+}
+
+func TestInflux_WriteInactive(t *testing.T) {
+	i := &Influx{}
+	n, err := i.WriteEvent(testEvent())
+	assert.Equal(t, 0, n)
+	assert.Contains(t, err.Error(), errOutputNull.Error())
+	assert.NotNil(t, i.Deactivate())
+}
+
+func TestInflux_Activate(t *testing.T) {
+	for _, i := range []*Influx{
+		{},
+		{Org: "o", Bucket: "b", Precision: "m"},
+		{Transport: "tcp"},
+		{Transport: transportFile, Directory: "/nonexist"},
+	} {
+		assert.NotNil(t, i.Activate(), i.String())
+	}
+}
+
+func TestInflux_EncodeLine(t *testing.T) {
+	i := &Influx{Tags: []string{"user", "host"}, unit: time.Second}
+	line, err := i.encodeLine(influxEvent())
+	assert.Nil(t, err)
+	assert.Equal(t, `weblogic,host=web\ 1,user=GuoJing timestamp="2018-01-01",thread=42i,latency=0.25 1514764800`+"\n", string(line))
+
+	i = &Influx{
+		Measurement: "my logs",
+		Fields:      []string{"user", FieldRaw, "nonexist"},
+		TimeField:   "timestamp",
+		TimeFormat:  "yyyy-MM-dd",
+		unit:        time.Millisecond,
+	}
+	e := influxEvent()
+	e.Raw = `say "hi"` + "\n"
+	line, err = i.encodeLine(e)
+	assert.Nil(t, err)
+	assert.Equal(t, `my\ logs user="GuoJing",_raw="say \"hi\"\n" 1514764800000`+"\n", string(line))
+
+	// the whole event is stored if there are no fields
+	i = &Influx{unit: time.Nanosecond}
+	line, err = i.encodeLine(&Event{Raw: "hello", Time: time.Unix(0, 1)})
+	assert.Nil(t, err)
+	assert.Equal(t, `logspout message="hello" 1`+"\n", string(line))
+}
+
+func TestInflux_InvalidValue(t *testing.T) {
+	i := &Influx{Fields: []string{"user"}, unit: time.Second}
+	e := influxEvent()
+	e.Kinds = map[string]Kind{"user": KindInteger}
+	_, err := i.encodeLine(e)
+	assert.Contains(t, err.Error(), errInvalidColValue.Error())
+
+	i = &Influx{TimeField: "user", unit: time.Second}
+	_, err = i.encodeLine(influxEvent())
+	assert.Contains(t, err.Error(), errInvalidColValue.Error())
+
+	i = &Influx{TimeField: "nonexist", unit: time.Second}
+	_, err = i.encodeLine(influxEvent())
+	assert.Contains(t, err.Error(), errFieldNotFound.Error())
+}
+
+type writeRecorder struct {
+	sync.Mutex
+	reqs   []*http.Request
+	bodies []string
+}
+
+func (r *writeRecorder) handler(status int, body string) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		b, _ := ioutil.ReadAll(req.Body)
+		r.Lock()
+		r.reqs = append(r.reqs, req)
+		r.bodies = append(r.bodies, string(b))
+		r.Unlock()
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}
+}
+
+func TestInflux_HTTP(t *testing.T) {
+	rec := &writeRecorder{}
+	srv := httptest.NewServer(rec.handler(http.StatusNoContent, ""))
+	defer srv.Close()
+
+	i := &Influx{URL: srv.URL, Org: "acme", Bucket: "logs", Token: "secret", Precision: "s", BatchSize: 2}
+	assert.Nil(t, i.Activate())
+	for n := 0; n < 3; n++ {
+		_, err := i.WriteEvent(influxEvent())
+		assert.Nil(t, err)
+	}
+	assert.Nil(t, i.Deactivate())
+
+	assert.Len(t, rec.bodies, 2)
+	req := rec.reqs[0]
+	assert.Equal(t, "/api/v2/write", req.URL.Path)
+	assert.Equal(t, "acme", req.URL.Query().Get("org"))
+	assert.Equal(t, "logs", req.URL.Query().Get("bucket"))
+	assert.Equal(t, "s", req.URL.Query().Get("precision"))
+	assert.Equal(t, "Token secret", req.Header.Get("Authorization"))
+
+	line, _ := i.encodeLine(influxEvent())
+	assert.Equal(t, string(line)+string(line), rec.bodies[0])
+	assert.Equal(t, string(line), rec.bodies[1])
+}
+
+func TestInflux_Retry(t *testing.T) {
+	rec := &writeRecorder{}
+	srv := httptest.NewServer(rec.handler(http.StatusServiceUnavailable, ""))
+	defer srv.Close()
+
+	i := &Influx{URL: srv.URL, Org: "o", Bucket: "b", BatchSize: 1, MaxRetries: 2, RetryBackoff: 1}
+	assert.Nil(t, i.Activate())
+	// the batch is sent in the background
+	_, err := i.WriteEvent(testEvent())
+	assert.Nil(t, err)
+	i.Deactivate()
+	assert.Len(t, rec.bodies, 3)
+	ss := metrics.Output(i.String()).Snapshot()
+	assert.Equal(t, int64(2), ss.Retries)
+	assert.Equal(t, int64(1), ss.Drops)
+
+	// not retriable
+	rec = &writeRecorder{}
+	srv2 := httptest.NewServer(rec.handler(http.StatusBadRequest, `{"code":"invalid","message":"bad line"}`))
+	defer srv2.Close()
+	i.URL = srv2.URL
+	assert.Nil(t, i.Activate())
+	_, err = i.WriteEvent(testEvent())
+	assert.Nil(t, err)
+	i.Deactivate()
+	assert.Len(t, rec.bodies, 1)
+	ss = metrics.Output(i.String()).Snapshot()
+	assert.Equal(t, int64(0), ss.Retries)
+	assert.Equal(t, int64(1), ss.Drops)
+}
+
+func TestInflux_SlowServer(t *testing.T) {
+	rec := &writeRecorder{}
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		<-release
+		rec.handler(http.StatusNoContent, "")(w, req)
+	}))
+	defer srv.Close()
+
+	i := &Influx{URL: srv.URL, Org: "o", Bucket: "b", BatchSize: 1}
+	assert.Nil(t, i.Activate())
+
+	// the writes aren't held up by the sink
+	written := make(chan struct{})
+	go func() {
+		for n := 0; n < pendingBatches; n++ {
+			_, err := i.WriteEvent(testEvent())
+			assert.Nil(t, err)
+		}
+		close(written)
+	}()
+	select {
+	case <-written:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the writes are blocked by the server")
+	}
+
+	close(release)
+	assert.Nil(t, i.Deactivate())
+	assert.Len(t, rec.bodies, pendingBatches)
+}
+
+func TestInflux_UDP(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer pc.Close()
+
+	i := &Influx{Transport: transportUDP, Host: pc.LocalAddr().String(), BatchSize: 100}
+	assert.Nil(t, i.Activate())
+	// 30 lines of about 100 bytes are split into 3 packets
+	for n := 0; n < 30; n++ {
+		_, err := i.WriteEvent(influxEvent())
+		assert.Nil(t, err)
+	}
+	assert.Nil(t, i.Deactivate())
+
+	line, _ := i.encodeLine(influxEvent())
+	buf := make([]byte, 65536)
+	var lines int
+	pc.SetReadDeadline(time.Now().Add(time.Second))
+	for lines < 30 {
+		n, _, err := pc.ReadFrom(buf)
+		if !assert.Nil(t, err) {
+			break
+		}
+		assert.True(t, n <= maxInfluxUDPPayload)
+		assert.Equal(t, 0, n%len(line))
+		lines += n / len(line)
+	}
+	assert.Equal(t, 30, lines)
+}
+
+func TestInflux_File(t *testing.T) {
+	dir, err := ioutil.TempDir("", "influx")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	for n := 0; n < 2; n++ {
+		i := &Influx{Transport: transportFile, Directory: dir}
+		assert.Nil(t, i.Activate())
+		// the length of the event is returned rather than the line
+		written, err := i.Write([]byte("hello"))
+		assert.Nil(t, err)
+		assert.Equal(t, 5, written)
+		assert.Nil(t, i.Deactivate())
+	}
+
+	b, err := ioutil.ReadFile(filepath.Join(dir, defaultInfluxFileName))
+	assert.Nil(t, err)
+	assert.Regexp(t, `^(logspout message="hello" \d+\n){2}$`, string(b))
+}
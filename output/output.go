@@ -7,6 +7,7 @@ import (
 	"crypto/sha1"
 	"encoding/base64"
 	"encoding/json"
+	"fmt"
 	"io"
 	"sync"
 
@@ -36,6 +37,13 @@ type Output interface {
 	Deactivate() error
 }
 
+// Flusher is implemented by the outputs which buffer the events, e.g., in
+// batches, so that they can be written out before the outputs are closed.
+type Flusher interface {
+	// Flush writes out the events buffered
+	Flush() error
+}
+
 // Wrapper is a wrapper struct that contains the output type and a byte slice
 // which represents the configurations of that type.
 type Wrapper struct {
@@ -60,7 +68,8 @@ func (d DumbClosableWriter) Close() error { return nil }
 
 // errors
 var (
-	errOutputNull = errors.New("output is null")
+	errOutputNull  = errors.New("output is null")
+	errUnknownType = errors.New("unknown output type")
 )
 
 // initializers is the map for the output types and their factory methods
@@ -82,11 +91,16 @@ func setupInitializers() {
 	mu.Lock()
 	defer mu.Unlock()
 	initializers = map[Type]Initializer{
-		console: func() Output { return &Console{} },
-		file:    func() Output { return &File{} },
-		syslog:  func() Output { return &Syslog{} },
-		kafka:   func() Output { return &Kafka{} },
-		discard: func() Output { return &Discard{} },
+		console:    func() Output { return &Console{} },
+		file:       func() Output { return &File{} },
+		syslog:     func() Output { return &Syslog{} },
+		kafka:      func() Output { return &Kafka{} },
+		discard:    func() Output { return &Discard{} },
+		clickhouse: func() Output { return &ClickHouse{} },
+		parquet:    func() Output { return &Parquet{} },
+		pcap:       func() Output { return &Pcap{} },
+		influx:     func() Output { return &Influx{} },
+		websocket:  func() Output { return &WebSocket{} },
 	}
 }
 
@@ -126,12 +140,27 @@ func buildOutputMap(ow map[string]Wrapper) map[string]Output {
 
 // buildFile builds a single output instance based on the wrapper.
 func build(m Wrapper) Output {
-	op := initializers[m.T]()
-	utils.ExitOnErr("build", json.Unmarshal(m.Raw, op))
+	op, err := New(m)
+	utils.ExitOnErr("build", err)
 
 	return op
 }
 
+// New creates an output instance based on the wrapper.
+func New(m Wrapper) (Output, error) {
+	init, ok := GetInitializer(m.T)
+	if !ok {
+		return nil, errors.Wrap(errUnknownType, fmt.Sprintf("%d", m.T))
+	}
+	op := init()
+	if len(m.Raw) != 0 {
+		if err := json.Unmarshal(m.Raw, op); err != nil {
+			return nil, errors.Wrap(err, "new output")
+		}
+	}
+	return op, nil
+}
+
 // id creates a short checksum of the string
 func id(s string) ID {
 	h := sha1.New()
@@ -0,0 +1,337 @@
+package output
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/vjeantet/jodaTime"
+
+	"github.com/jiwen624/logspout/log"
+	"github.com/jiwen624/logspout/utils"
+)
+
+// Parquet writes the events into Parquet files. The schema of the files is
+// derived from the named capture groups of the pattern, each of them becomes
+// an optional column of the type given in Types (string by default).
+type Parquet struct {
+	// FileName is the prefix of the file names, a sequence number is appended
+	// to it for each file.
+	FileName  string `json:"fileName"`
+	Directory string `json:"directory"`
+	// Fields are the capture groups (or pseudo fields such as _raw) to be
+	// stored. It defaults to all the named capture groups of the first event.
+	Fields []string `json:"fields"`
+	// IncludeRaw adds the whole event as the column _raw.
+	IncludeRaw bool `json:"includeRaw"`
+	// Types are the type hints of the columns, which may be int64, double,
+	// timestamp or string.
+	Types map[string]string `json:"types"`
+	// TimeFormat is the format of the timestamp columns in Joda-Time syntax,
+	// RFC 3339 is expected if it's not set.
+	TimeFormat string `json:"timeFormat"`
+	// Compression can be none, snappy (the default) or zstd.
+	Compression string `json:"compression"`
+	// RowGroupSize is the number of rows in a row group.
+	RowGroupSize int `json:"rowGroupSize"`
+	// MaxRows rolls the file after the number of rows is reached.
+	MaxRows int64 `json:"maxRows"`
+	// MaxBytes rolls the file after the file size is reached. The size is
+	// checked after each row group.
+	MaxBytes int64 `json:"maxBytes"`
+
+	// mu protects all the fields below
+	mu      sync.Mutex
+	active  bool
+	codec   int32
+	columns []*parquetColumn
+	writer  *parquetWriter
+	file    *os.File
+	seq     int
+	started string
+}
+
+// default parameters
+const (
+	defaultParquetFileName     = "logspout"
+	defaultParquetRowGroupSize = 10000
+	parquetExt                 = ".parquet"
+	// the suffix of the file being written, it's removed when the file is closed
+	inProgressSuffix = ".inprogress"
+)
+
+var codecs = map[string]int32{
+	"none":   codecUncompressed,
+	"snappy": codecSnappy,
+	"zstd":   codecZstd,
+}
+
+var errUnknownCodec = errors.New("unknown compression codec")
+
+func (p *Parquet) String() string {
+	return fmt.Sprintf("Parquet{FileName:%s, Directory:%s}", p.FileName, p.Directory)
+}
+
+func (p *Parquet) ID() ID {
+	return id(p.String())
+}
+
+func (p *Parquet) Type() Type {
+	return parquet
+}
+
+// Write writes a log event which has no capture groups, only the pseudo fields
+// are available to the columns.
+func (p *Parquet) Write(b []byte) (n int, err error) {
+	return p.WriteEvent(&Event{Raw: string(b), Time: time.Now()})
+}
+
+// WriteEvent adds the event as a row of the current row group.
+func (p *Parquet) WriteEvent(e *Event) (n int, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.active {
+		return 0, errors.Wrap(errOutputNull, p.String())
+	}
+
+	if p.columns == nil {
+		if err := p.buildSchema(e); err != nil {
+			return 0, errors.Wrap(err, p.String())
+		}
+	}
+
+	values := make([]interface{}, len(p.columns))
+	for i, c := range p.columns {
+		v, ok := e.Field(c.name)
+		if !ok {
+			continue
+		}
+		cv, err := p.convert(c, v)
+		if err != nil {
+			return 0, errors.Wrap(err, p.String())
+		}
+		values[i] = cv
+		n += len(v)
+	}
+	p.appendRow(values)
+
+	if err := p.maybeFlush(); err != nil {
+		return 0, errors.Wrap(err, p.String())
+	}
+	return n, nil
+}
+
+// buildSchema creates the columns from the fields of the first event.
+func (p *Parquet) buildSchema(e *Event) error {
+	fields := p.Fields
+	if len(fields) == 0 {
+		for _, name := range e.Names {
+			if name != "" && utils.StrIndex(fields, name) == -1 {
+				fields = append(fields, name)
+			}
+		}
+	}
+	if (p.IncludeRaw || len(fields) == 0) && utils.StrIndex(fields, FieldRaw) == -1 {
+		fields = append(fields, FieldRaw)
+	}
+
+	var columns []*parquetColumn
+	for _, f := range fields {
+		typ := p.Types[f]
+		if typ == "" {
+			typ = parquetString
+		}
+		c, err := newParquetColumn(f, typ)
+		if err != nil {
+			return errors.Wrap(err, f)
+		}
+		columns = append(columns, c)
+	}
+	p.columns = columns
+	log.Debugf("Parquet schema of %s: %v", p, fields)
+	return nil
+}
+
+// convert converts a field value to the column type.
+func (p *Parquet) convert(c *parquetColumn, v string) (interface{}, error) {
+	var cv interface{}
+	var err error
+
+	switch c.typ {
+	case parquetInt:
+		cv, err = strconv.ParseInt(v, 10, 64)
+	case parquetFloat:
+		cv, err = strconv.ParseFloat(v, 64)
+	case parquetTimestamp:
+		var t time.Time
+		if p.TimeFormat != "" {
+			t, err = jodaTime.Parse(p.TimeFormat, v)
+		} else {
+			t, err = time.Parse(time.RFC3339Nano, v)
+		}
+		cv = t.UnixNano() / int64(time.Millisecond)
+	default:
+		cv = v
+	}
+
+	if err != nil {
+		return nil, errors.Wrap(errInvalidColValue, fmt.Sprintf("%s %s: %q", c.name, c.typ, v))
+	}
+	return cv, nil
+}
+
+func (p *Parquet) appendRow(values []interface{}) {
+	for i, c := range p.columns {
+		switch v := values[i].(type) {
+		case nil:
+			c.appendNull()
+		case int64:
+			c.appendInt(v)
+		case float64:
+			c.appendFloat(v)
+		case string:
+			c.appendString(v)
+		}
+	}
+}
+
+// maybeFlush writes a row group when it's full, and rolls the file when it
+// reaches the size limits. The caller must hold the mutex.
+func (p *Parquet) maybeFlush() error {
+	if p.writer == nil {
+		if err := p.openFile(); err != nil {
+			return err
+		}
+	}
+
+	rows := int64(p.writer.bufferedRows())
+	full := rows >= int64(p.RowGroupSize)
+	roll := p.MaxRows > 0 && p.writer.rows+rows >= p.MaxRows
+	if !full && !roll {
+		return nil
+	}
+
+	if err := p.writer.flushRowGroup(); err != nil {
+		return err
+	}
+	if roll || (p.MaxBytes > 0 && p.writer.offset >= p.MaxBytes) {
+		return p.closeFile()
+	}
+	return nil
+}
+
+// openFile creates a new file and writes the header. The names of the files
+// which exist, e.g., written before the output is reactivated in the same
+// second, are skipped.
+func (p *Parquet) openFile() error {
+	var f *os.File
+	for f == nil {
+		p.seq++
+		name := fmt.Sprintf("%s-%s-%04d%s", p.FileName, p.started, p.seq, parquetExt)
+		path := filepath.Join(p.Directory, name)
+		if _, err := os.Stat(path); err == nil {
+			continue
+		}
+
+		var err error
+		f, err = os.OpenFile(path+inProgressSuffix, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if os.IsExist(err) {
+			continue
+		}
+		if err != nil {
+			return errors.Wrap(err, "open parquet file")
+		}
+	}
+	w, err := newParquetWriter(f, p.columns, p.codec)
+	if err != nil {
+		f.Close()
+		return errors.Wrap(err, "open parquet file")
+	}
+	p.file = f
+	p.writer = w
+	log.Debugf("Opened parquet file %s", f.Name())
+	return nil
+}
+
+// closeFile writes the footer and renames the file to its final name.
+func (p *Parquet) closeFile() error {
+	if p.writer == nil {
+		return nil
+	}
+	err := p.writer.close()
+	if e := p.file.Close(); err == nil {
+		err = e
+	}
+
+	path := p.file.Name()
+	p.writer = nil
+	p.file = nil
+	if err != nil {
+		return errors.Wrap(err, "close parquet file")
+	}
+	return errors.Wrap(os.Rename(path, strings.TrimSuffix(path, inProgressSuffix)), "close parquet file")
+}
+
+func (p *Parquet) Activate() error {
+	log.Infof("Activating output %s", p)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err := p.buildParquet(); err != nil {
+		return errors.Wrap(err, "activate parquet")
+	}
+	p.active = true
+	return nil
+}
+
+func (p *Parquet) Deactivate() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.active {
+		return errors.Wrap(errOutputNull, p.String())
+	}
+	log.Infof("Deactivating output %s", p)
+
+	p.active = false
+	return errors.Wrap(p.closeFile(), "deactivate parquet")
+}
+
+// buildParquet fills in the default parameters.
+func (p *Parquet) buildParquet() error {
+	if p.FileName == "" {
+		p.FileName = defaultParquetFileName
+	}
+	if p.Directory == "" {
+		p.Directory = defaultDir
+	}
+	if p.RowGroupSize <= 0 {
+		p.RowGroupSize = defaultParquetRowGroupSize
+	}
+	if p.Compression == "" {
+		p.Compression = "snappy"
+	}
+
+	codec, ok := codecs[strings.ToLower(p.Compression)]
+	if !ok {
+		return errors.Wrap(errUnknownCodec, p.Compression)
+	}
+	for f, typ := range p.Types {
+		if _, err := newParquetColumn(f, typ); err != nil {
+			return errors.Wrap(err, f)
+		}
+	}
+
+	p.codec = codec
+	p.started = time.Now().Format("20060102T150405")
+	p.seq = 0
+	p.columns = nil
+	return nil
+}
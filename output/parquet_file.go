@@ -0,0 +1,358 @@
+package output
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+
+	"github.com/pkg/errors"
+
+	"github.com/jiwen624/logspout/compress"
+)
+
+// This file implements a minimal Parquet file writer: flat schemas of optional
+// columns, one PLAIN encoded data page (v1) per column chunk and RLE encoded
+// definition levels.
+
+const parquetMagic = "PAR1"
+
+// The physical types of Parquet
+const (
+	parquetInt64     = 2
+	parquetDouble    = 5
+	parquetByteArray = 6
+)
+
+// The other enums of the Parquet metadata
+const (
+	repetitionOptional   = 1
+	convertedUTF8        = 0
+	convertedTimestampMs = 9
+	encodingPlain        = 0
+	encodingRLE          = 3
+	pageTypeData         = 0
+)
+
+// The compression codecs of Parquet
+const (
+	codecUncompressed = 0
+	codecSnappy       = 1
+	codecZstd         = 6
+)
+
+// The column types supported by the Parquet output
+const (
+	parquetString    = "string"
+	parquetInt       = "int64"
+	parquetFloat     = "double"
+	parquetTimestamp = "timestamp"
+)
+
+var errUnknownColType = errors.New("unknown column type")
+
+// parquetColumn buffers the values of a column of the current row group.
+type parquetColumn struct {
+	name string
+	typ  string
+
+	// present is the definition level of each row, a null value is not stored
+	// in the slices below.
+	present []bool
+	ints    []int64
+	floats  []float64
+	strs    []string
+}
+
+func newParquetColumn(name, typ string) (*parquetColumn, error) {
+	switch typ {
+	case parquetString, parquetInt, parquetFloat, parquetTimestamp:
+		return &parquetColumn{name: name, typ: typ}, nil
+	}
+	return nil, errors.Wrap(errUnknownColType, typ)
+}
+
+func (c *parquetColumn) physicalType() int32 {
+	switch c.typ {
+	case parquetInt, parquetTimestamp:
+		return parquetInt64
+	case parquetFloat:
+		return parquetDouble
+	}
+	return parquetByteArray
+}
+
+func (c *parquetColumn) appendNull() {
+	c.present = append(c.present, false)
+}
+
+func (c *parquetColumn) appendInt(v int64) {
+	c.present = append(c.present, true)
+	c.ints = append(c.ints, v)
+}
+
+func (c *parquetColumn) appendFloat(v float64) {
+	c.present = append(c.present, true)
+	c.floats = append(c.floats, v)
+}
+
+func (c *parquetColumn) appendString(v string) {
+	c.present = append(c.present, true)
+	c.strs = append(c.strs, v)
+}
+
+func (c *parquetColumn) reset() {
+	c.present = c.present[:0]
+	c.ints = c.ints[:0]
+	c.floats = c.floats[:0]
+	c.strs = c.strs[:0]
+}
+
+// pageData returns the definition levels and the PLAIN encoded values.
+func (c *parquetColumn) pageData() []byte {
+	var b bytes.Buffer
+
+	levels := rleLevels(c.present)
+	var l [4]byte
+	binary.LittleEndian.PutUint32(l[:], uint32(len(levels)))
+	b.Write(l[:])
+	b.Write(levels)
+
+	var v [8]byte
+	switch c.physicalType() {
+	case parquetInt64:
+		for _, i := range c.ints {
+			binary.LittleEndian.PutUint64(v[:], uint64(i))
+			b.Write(v[:])
+		}
+	case parquetDouble:
+		for _, f := range c.floats {
+			binary.LittleEndian.PutUint64(v[:], math.Float64bits(f))
+			b.Write(v[:])
+		}
+	case parquetByteArray:
+		for _, s := range c.strs {
+			binary.LittleEndian.PutUint32(l[:], uint32(len(s)))
+			b.Write(l[:])
+			b.WriteString(s)
+		}
+	}
+	return b.Bytes()
+}
+
+// rleLevels encodes the definition levels (bit width 1) with the RLE runs of
+// the RLE/bit-packing hybrid encoding.
+func rleLevels(present []bool) []byte {
+	var b []byte
+	var buf [binary.MaxVarintLen64]byte
+	for i := 0; i < len(present); {
+		j := i + 1
+		for j < len(present) && present[j] == present[i] {
+			j++
+		}
+		n := binary.PutUvarint(buf[:], uint64(j-i)<<1)
+		b = append(b, buf[:n]...)
+		if present[i] {
+			b = append(b, 1)
+		} else {
+			b = append(b, 0)
+		}
+		i = j
+	}
+	return b
+}
+
+// chunkMeta is the metadata of a column chunk.
+type chunkMeta struct {
+	offset           int64
+	numValues        int64
+	uncompressedSize int64
+	compressedSize   int64
+}
+
+// rowGroupMeta is the metadata of a row group.
+type rowGroupMeta struct {
+	numRows int64
+	chunks  []chunkMeta
+}
+
+// parquetWriter writes the row groups and the footer of a Parquet file.
+type parquetWriter struct {
+	w         *bufio.Writer
+	offset    int64
+	codec     int32
+	columns   []*parquetColumn
+	rows      int64
+	rowGroups []rowGroupMeta
+}
+
+func newParquetWriter(w io.Writer, columns []*parquetColumn, codec int32) (*parquetWriter, error) {
+	pw := &parquetWriter{w: bufio.NewWriter(w), codec: codec, columns: columns}
+	if err := pw.write([]byte(parquetMagic)); err != nil {
+		return nil, err
+	}
+	return pw, nil
+}
+
+func (pw *parquetWriter) write(p []byte) error {
+	n, err := pw.w.Write(p)
+	pw.offset += int64(n)
+	return err
+}
+
+func (pw *parquetWriter) compress(p []byte) []byte {
+	switch pw.codec {
+	case codecSnappy:
+		return compress.Snappy(p)
+	case codecZstd:
+		return compress.Zstd(p)
+	}
+	return p
+}
+
+// bufferedRows returns the number of rows of the current row group.
+func (pw *parquetWriter) bufferedRows() int {
+	if len(pw.columns) == 0 {
+		return 0
+	}
+	return len(pw.columns[0].present)
+}
+
+// flushRowGroup writes the buffered rows as a row group.
+func (pw *parquetWriter) flushRowGroup() error {
+	rows := pw.bufferedRows()
+	if rows == 0 {
+		return nil
+	}
+
+	rg := rowGroupMeta{numRows: int64(rows)}
+	for _, c := range pw.columns {
+		data := c.pageData()
+		compressed := pw.compress(data)
+
+		var h thriftWriter
+		h.beginStruct()
+		h.i32Field(1, pageTypeData)
+		h.i32Field(2, int32(len(data)))
+		h.i32Field(3, int32(len(compressed)))
+		h.structField(5)
+		h.i32Field(1, int32(rows))
+		h.i32Field(2, encodingPlain)
+		h.i32Field(3, encodingRLE)
+		h.i32Field(4, encodingRLE)
+		h.endStruct()
+		h.endStruct()
+
+		cm := chunkMeta{
+			offset:           pw.offset,
+			numValues:        int64(rows),
+			uncompressedSize: int64(h.Len() + len(data)),
+			compressedSize:   int64(h.Len() + len(compressed)),
+		}
+		if err := pw.write(h.Bytes()); err != nil {
+			return errors.Wrap(err, "write page header")
+		}
+		if err := pw.write(compressed); err != nil {
+			return errors.Wrap(err, "write page")
+		}
+		rg.chunks = append(rg.chunks, cm)
+		c.reset()
+	}
+
+	pw.rows += int64(rows)
+	pw.rowGroups = append(pw.rowGroups, rg)
+	return pw.w.Flush()
+}
+
+// close writes the remaining rows and the footer. It doesn't close the
+// underlying writer.
+func (pw *parquetWriter) close() error {
+	if err := pw.flushRowGroup(); err != nil {
+		return err
+	}
+
+	footer := pw.fileMetaData()
+	var l [4]byte
+	binary.LittleEndian.PutUint32(l[:], uint32(len(footer)))
+
+	for _, p := range [][]byte{footer, l[:], []byte(parquetMagic)} {
+		if err := pw.write(p); err != nil {
+			return errors.Wrap(err, "write footer")
+		}
+	}
+	return pw.w.Flush()
+}
+
+// fileMetaData encodes the FileMetaData struct of the footer.
+func (pw *parquetWriter) fileMetaData() []byte {
+	var t thriftWriter
+	t.beginStruct()
+	t.i32Field(1, 1) // version
+
+	t.listField(2, thriftStruct, len(pw.columns)+1)
+	t.beginStruct()
+	t.stringField(4, "schema")
+	t.i32Field(5, int32(len(pw.columns)))
+	t.endStruct()
+	for _, c := range pw.columns {
+		t.beginStruct()
+		t.i32Field(1, c.physicalType())
+		t.i32Field(3, repetitionOptional)
+		t.stringField(4, c.name)
+		switch c.typ {
+		case parquetString:
+			t.i32Field(6, convertedUTF8)
+			t.structField(10)
+			t.structField(1) // STRING
+			t.endStruct()
+			t.endStruct()
+		case parquetTimestamp:
+			t.i32Field(6, convertedTimestampMs)
+			t.structField(10)
+			t.structField(8) // TIMESTAMP
+			t.boolField(1, true)
+			t.structField(2)
+			t.structField(1) // MILLIS
+			t.endStruct()
+			t.endStruct()
+			t.endStruct()
+			t.endStruct()
+		}
+		t.endStruct()
+	}
+
+	t.i64Field(3, pw.rows)
+
+	t.listField(4, thriftStruct, len(pw.rowGroups))
+	for _, rg := range pw.rowGroups {
+		var total int64
+		t.beginStruct()
+		t.listField(1, thriftStruct, len(rg.chunks))
+		for i, cm := range rg.chunks {
+			c := pw.columns[i]
+			total += cm.uncompressedSize
+
+			t.beginStruct()
+			t.i64Field(2, cm.offset)
+			t.structField(3)
+			t.i32Field(1, c.physicalType())
+			t.i32List(2, encodingPlain, encodingRLE)
+			t.stringList(3, c.name)
+			t.i32Field(4, pw.codec)
+			t.i64Field(5, cm.numValues)
+			t.i64Field(6, cm.uncompressedSize)
+			t.i64Field(7, cm.compressedSize)
+			t.i64Field(9, cm.offset)
+			t.endStruct()
+			t.endStruct()
+		}
+		t.i64Field(2, total)
+		t.i64Field(3, rg.numRows)
+		t.endStruct()
+	}
+
+	t.stringField(6, "logspout")
+	t.endStruct()
+	return t.Bytes()
+}
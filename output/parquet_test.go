@@ -0,0 +1,169 @@
+package output
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParquetString(t *testing.T) {
+	p := &Parquet{}
+	assert.NotEmpty(t, p.String())
+	assert.NotEmpty(t, p.ID())
+	assert.Equal(t, parquet, p.Type())
+}
+
+func TestParquetActivate(t *testing.T) {
+	p := &Parquet{Directory: os.TempDir()}
+	assert.Nil(t, p.Activate())
+	assert.Equal(t, defaultParquetFileName, p.FileName)
+	assert.Equal(t, defaultParquetRowGroupSize, p.RowGroupSize)
+	assert.Equal(t, int32(codecSnappy), p.codec)
+	assert.Nil(t, p.Deactivate())
+	assert.NotNil(t, p.Deactivate())
+
+	p = &Parquet{Compression: "lzo"}
+	assert.NotNil(t, p.Activate())
+
+	p = &Parquet{Types: map[string]string{"thread": "int32"}}
+	assert.NotNil(t, p.Activate())
+}
+
+func TestParquetWriteInactive(t *testing.T) {
+	p := &Parquet{}
+	n, err := p.WriteEvent(testEvent())
+	assert.Equal(t, 0, n)
+	assert.NotNil(t, err)
+}
+
+func TestParquetSchema(t *testing.T) {
+	p := &Parquet{IncludeRaw: true, Types: map[string]string{"thread": parquetInt}}
+	assert.Nil(t, p.buildSchema(testEvent()))
+
+	var names, types []string
+	for _, c := range p.columns {
+		names = append(names, c.name)
+		types = append(types, c.typ)
+	}
+	assert.Equal(t, []string{"timestamp", "thread", "user", FieldRaw}, names)
+	assert.Equal(t, []string{parquetString, parquetInt, parquetString, parquetString}, types)
+
+	p = &Parquet{Fields: []string{"user"}}
+	assert.Nil(t, p.buildSchema(testEvent()))
+	assert.Equal(t, 1, len(p.columns))
+
+	// only the raw event is available without capture groups
+	p = &Parquet{}
+	assert.Nil(t, p.buildSchema(&Event{Raw: "abc"}))
+	assert.Equal(t, FieldRaw, p.columns[0].name)
+}
+
+func TestParquetConvert(t *testing.T) {
+	p := &Parquet{TimeFormat: "yyyy-MM-dd"}
+	c, _ := newParquetColumn("ts", parquetTimestamp)
+	v, err := p.convert(c, "1970-01-02")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(86400000), v)
+
+	c, _ = newParquetColumn("thread", parquetInt)
+	_, err = p.convert(c, "abc")
+	assert.NotNil(t, err)
+
+	c, _ = newParquetColumn("ratio", parquetFloat)
+	v, err = p.convert(c, "0.5")
+	assert.Nil(t, err)
+	assert.Equal(t, 0.5, v)
+}
+
+func TestParquetRoll(t *testing.T) {
+	dir, err := ioutil.TempDir("", "parquet")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	for _, codec := range []string{"none", "snappy", "zstd"} {
+		p := &Parquet{
+			FileName:     codec,
+			Directory:    dir,
+			Compression:  codec,
+			RowGroupSize: 2,
+			MaxRows:      5,
+			Types:        map[string]string{"thread": parquetInt},
+		}
+		assert.Nil(t, p.Activate())
+		for i := 0; i < 12; i++ {
+			_, err := p.WriteEvent(testEvent())
+			assert.Nil(t, err)
+		}
+		assert.Nil(t, p.Deactivate())
+
+		files, _ := filepath.Glob(filepath.Join(dir, codec+"-*"+parquetExt))
+		assert.Equal(t, 3, len(files), codec)
+		for _, f := range files {
+			b, err := ioutil.ReadFile(f)
+			assert.Nil(t, err)
+			assert.Equal(t, parquetMagic, string(b[:4]))
+			assert.Equal(t, parquetMagic, string(b[len(b)-4:]))
+			footer := binary.LittleEndian.Uint32(b[len(b)-8:])
+			assert.True(t, int(footer) < len(b)-12)
+			assert.True(t, bytes.Contains(b[len(b)-8-int(footer):], []byte("thread")))
+		}
+	}
+
+	inProgress, _ := filepath.Glob(filepath.Join(dir, "*"+inProgressSuffix))
+	assert.Empty(t, inProgress)
+}
+
+func TestRLELevels(t *testing.T) {
+	assert.Empty(t, rleLevels(nil))
+	assert.Equal(t, []byte{6, 1, 2, 0}, rleLevels([]bool{true, true, true, false}))
+	// the run length is a varint
+	levels := make([]bool, 100)
+	assert.Equal(t, []byte{0xC8, 0x01, 0}, rleLevels(levels))
+}
+
+func TestThriftWriter(t *testing.T) {
+	var w thriftWriter
+	w.beginStruct()
+	w.i32Field(1, -1)
+	w.structField(2)
+	w.stringField(1, "ab")
+	w.endStruct()
+	w.i64Field(20, 1)
+	w.i32List(21, 1, 2)
+	w.endStruct()
+
+	assert.Equal(t, []byte{
+		0x15, 0x01, // field 1 i32 -1
+		0x1C,                 // field 2 struct
+		0x18, 0x02, 'a', 'b', // field 1 binary "ab"
+		0x00,       // stop
+		0x06, 0x28, // field 20 i64 with the long form
+		0x02,                   // 1
+		0x19, 0x25, 0x02, 0x04, // field 21 list<i32> [1, 2]
+		0x00, // stop
+	}, w.Bytes())
+}
+
+func TestParquetReactivate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "parquet")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	// the files written in the same second aren't overwritten, even by
+	// another output
+	for _, p := range []*Parquet{{Directory: dir}, {Directory: dir}} {
+		for i := 0; i < 2; i++ {
+			assert.Nil(t, p.Activate())
+			_, err := p.WriteEvent(testEvent())
+			assert.Nil(t, err)
+			assert.Nil(t, p.Deactivate())
+		}
+	}
+	files, _ := filepath.Glob(filepath.Join(dir, defaultParquetFileName+"-*"+parquetExt))
+	assert.Equal(t, 4, len(files))
+}
@@ -0,0 +1,448 @@
+package output
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/jiwen624/logspout/log"
+)
+
+// Pcap writes the events into a libpcap file as if they were captured from the
+// network, each event is the payload of a synthetic Ethernet/IP/UDP frame or of
+// a TCP segment. It's useful to test IDS and network sensors without a live
+// network.
+type Pcap struct {
+	// FileName is the name of the capture file. A sequence number is appended
+	// to it if the file exists, e.g., written before the output is
+	// reactivated, as a capture is never overwritten.
+	FileName  string `json:"fileName"`
+	Directory string `json:"directory"`
+	// Protocol is either udp (the default) or tcp. A TCP flow starts with a
+	// three-way handshake and is closed when the output is deactivated.
+	Protocol string `json:"protocol"`
+	// Src and Dst are the addresses in the host:port format. A zero source
+	// port is replaced by a random ephemeral port per flow.
+	Src string `json:"src"`
+	Dst string `json:"dst"`
+	// SrcField and DstField are the capture groups holding the IP addresses
+	// of the packet. The IP of Src or Dst is used if the field is not found.
+	SrcField string `json:"srcField"`
+	DstField string `json:"dstField"`
+	SrcMAC   string `json:"srcMAC"`
+	DstMAC   string `json:"dstMAC"`
+	// Syslog prepends a RFC 3164 header to the events, the source IP is used
+	// as the host name.
+	Syslog bool   `json:"syslog"`
+	Tag    string `json:"tag"`
+
+	// mu protects all the fields below
+	mu             sync.Mutex
+	active         bool
+	file           *os.File
+	writer         *pcapWriter
+	src, dst       endpoint
+	srcMAC, dstMAC net.HardwareAddr
+	ipID           uint16
+	// ports are the ephemeral source ports of the flows
+	ports map[string]uint16
+	// flows are the open TCP flows, in the order they were opened
+	flows    map[string]*tcpFlow
+	flowKeys []string
+	rand     *rand.Rand
+}
+
+// tcpFlow is the state of a TCP connection.
+type tcpFlow struct {
+	client, server endpoint
+	// the next sequence numbers of both sides
+	clientSeq, serverSeq uint32
+}
+
+// default parameters
+const (
+	defaultPcapFileName = "logspout.pcap"
+	defaultPcapSrc      = "10.0.0.1:0"
+	defaultPcapDst      = "10.0.0.2:514"
+	defaultPcapSrcMAC   = "02:00:00:00:00:01"
+	defaultPcapDstMAC   = "02:00:00:00:00:02"
+	defaultPcapTag      = "logspout"
+
+	// the simulated half round-trip time between the hosts
+	pcapHalfRTT = 50 * time.Microsecond
+	// the maximum payload of a UDP datagram which fits in the snap length
+	maxUDPPayload = pcapSnapLen - ethHeaderLen - ipv6HeaderLen - udpHeaderLen
+	// the maximum segment sizes for an Ethernet MTU of 1500 bytes
+	mssIPv4 = 1460
+	mssIPv6 = 1440
+	// the range of ephemeral ports of RFC 6335
+	ephemeralPortMin = 49152
+	ephemeralPorts   = 16384
+)
+
+var (
+	errUnknownProtocol = errors.New("unknown protocol")
+	errInvalidAddr     = errors.New("invalid address")
+)
+
+func (p *Pcap) String() string {
+	return fmt.Sprintf("Pcap{FileName:%s, Directory:%s, Protocol:%s, Src:%s, Dst:%s}",
+		p.FileName, p.Directory, p.Protocol, p.Src, p.Dst)
+}
+
+func (p *Pcap) ID() ID {
+	return id(p.String())
+}
+
+func (p *Pcap) Type() Type {
+	return pcap
+}
+
+// Write writes a log event as the payload of a packet between Src and Dst.
+func (p *Pcap) Write(b []byte) (n int, err error) {
+	return p.WriteEvent(&Event{Raw: string(b), Time: time.Now()})
+}
+
+// WriteEvent writes the event as the payload of a packet, the addresses are
+// taken from the capture groups if SrcField or DstField is set.
+func (p *Pcap) WriteEvent(e *Event) (n int, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.active {
+		return 0, errors.Wrap(errOutputNull, p.String())
+	}
+
+	src, dst := p.src, p.dst
+	if src.ip, err = fieldIP(e, p.SrcField, src.ip); err != nil {
+		return 0, errors.Wrap(err, p.String())
+	}
+	if dst.ip, err = fieldIP(e, p.DstField, dst.ip); err != nil {
+		return 0, errors.Wrap(err, p.String())
+	}
+	if src.port == 0 {
+		src.port = p.ephemeralPort(src, dst)
+	}
+
+	t := e.Time
+	if t.IsZero() {
+		t = time.Now()
+	}
+	payload := p.payload(e.Raw, src, t)
+
+	if p.Protocol == "tcp" {
+		err = p.writeTCP(t, src, dst, payload)
+	} else {
+		if len(payload) > maxUDPPayload {
+			payload = payload[:maxUDPPayload]
+		}
+		err = p.emit(t, &packet{src: src, dst: dst}, payload)
+	}
+	if err != nil {
+		return 0, errors.Wrap(err, p.String())
+	}
+	return len(e.Raw), nil
+}
+
+// fieldIP returns the IP in the capture group, or def if it's not found.
+func fieldIP(e *Event, field string, def net.IP) (net.IP, error) {
+	v, ok := e.Field(field)
+	if !ok {
+		return def, nil
+	}
+	ip := net.ParseIP(v)
+	if ip == nil {
+		return nil, errors.Wrap(errInvalidAddr, fmt.Sprintf("%s: %q", field, v))
+	}
+	return ip, nil
+}
+
+// ephemeralPort returns the source port of the flow, which is chosen randomly
+// the first time the flow is seen.
+func (p *Pcap) ephemeralPort(src, dst endpoint) uint16 {
+	key := src.ip.String() + ">" + dst.String()
+	port, ok := p.ports[key]
+	if !ok {
+		port = uint16(ephemeralPortMin + p.rand.Intn(ephemeralPorts))
+		p.ports[key] = port
+	}
+	return port
+}
+
+// payload returns the bytes to be sent for an event.
+func (p *Pcap) payload(raw string, src endpoint, t time.Time) []byte {
+	if p.Syslog {
+		// facility user, severity info
+		raw = fmt.Sprintf("<14>%s %s %s: %s", t.Format(time.Stamp), src.ip, p.Tag, raw)
+	}
+	// the events in a TCP stream are delimited by the line feeds
+	if p.Protocol == "tcp" && !strings.HasSuffix(raw, "\n") {
+		raw += "\n"
+	}
+	return []byte(raw)
+}
+
+// writeTCP sends the payload from src to dst, and the server acknowledges it.
+// A new flow is opened with a three-way handshake.
+func (p *Pcap) writeTCP(t time.Time, src, dst endpoint, payload []byte) error {
+	key := src.String() + ">" + dst.String()
+	f, ok := p.flows[key]
+	if !ok {
+		f = &tcpFlow{client: src, server: dst, clientSeq: p.rand.Uint32(), serverSeq: p.rand.Uint32()}
+		if err := p.handshake(t, f); err != nil {
+			return err
+		}
+		t = t.Add(2 * pcapHalfRTT)
+		p.flows[key] = f
+		p.flowKeys = append(p.flowKeys, key)
+	}
+
+	mss := mssIPv4
+	if src.ip.To4() == nil {
+		mss = mssIPv6
+	}
+	for len(payload) > 0 {
+		seg := payload
+		if len(seg) > mss {
+			seg = seg[:mss]
+		}
+		payload = payload[len(seg):]
+
+		flags := byte(tcpACK)
+		if len(payload) == 0 {
+			flags |= tcpPSH
+		}
+		if err := p.emit(t, f.fromClient(flags), seg); err != nil {
+			return err
+		}
+		f.clientSeq += uint32(len(seg))
+	}
+	return p.emit(t.Add(pcapHalfRTT), f.fromServer(tcpACK), nil)
+}
+
+func (p *Pcap) handshake(t time.Time, f *tcpFlow) error {
+	if err := p.emit(t, f.fromClient(tcpSYN), nil); err != nil {
+		return err
+	}
+	f.clientSeq++
+	if err := p.emit(t.Add(pcapHalfRTT), f.fromServer(tcpSYN|tcpACK), nil); err != nil {
+		return err
+	}
+	f.serverSeq++
+	return p.emit(t.Add(2*pcapHalfRTT), f.fromClient(tcpACK), nil)
+}
+
+// closeFlows closes all the TCP flows with FIN from the client.
+func (p *Pcap) closeFlows() error {
+	t := p.writer.last
+	for _, key := range p.flowKeys {
+		f := p.flows[key]
+		if err := p.emit(t, f.fromClient(tcpFIN|tcpACK), nil); err != nil {
+			return err
+		}
+		f.clientSeq++
+		if err := p.emit(t.Add(pcapHalfRTT), f.fromServer(tcpFIN|tcpACK), nil); err != nil {
+			return err
+		}
+		f.serverSeq++
+		if err := p.emit(t.Add(2*pcapHalfRTT), f.fromClient(tcpACK), nil); err != nil {
+			return err
+		}
+	}
+	p.flows = make(map[string]*tcpFlow)
+	p.flowKeys = nil
+	return nil
+}
+
+func (f *tcpFlow) fromClient(flags byte) *packet {
+	pk := &packet{src: f.client, dst: f.server, seq: f.clientSeq, flags: flags}
+	if flags&tcpACK != 0 {
+		pk.ack = f.serverSeq
+	}
+	return pk
+}
+
+func (f *tcpFlow) fromServer(flags byte) *packet {
+	pk := &packet{src: f.server, dst: f.client, seq: f.serverSeq, flags: flags, reply: true}
+	if flags&tcpACK != 0 {
+		pk.ack = f.clientSeq
+	}
+	return pk
+}
+
+// emit encodes a packet and writes it to the file. The MAC addresses are
+// swapped for the replies.
+func (p *Pcap) emit(t time.Time, pk *packet, payload []byte) error {
+	pk.srcMAC, pk.dstMAC = p.srcMAC, p.dstMAC
+	if pk.reply {
+		pk.srcMAC, pk.dstMAC = p.dstMAC, p.srcMAC
+	}
+	p.ipID++
+	pk.id = p.ipID
+
+	var frame []byte
+	var err error
+	if p.Protocol == "tcp" {
+		frame, err = tcpFrame(pk, payload)
+	} else {
+		frame, err = udpFrame(pk, payload)
+	}
+	if err != nil {
+		return err
+	}
+	return p.writer.writePacket(t, frame)
+}
+
+// Flush writes the buffered packets to the file.
+func (p *Pcap) Flush() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.active {
+		return errors.Wrap(errOutputNull, p.String())
+	}
+	return errors.Wrap(p.writer.flush(), p.String())
+}
+
+func (p *Pcap) Activate() error {
+	log.Infof("Activating output %s", p)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err := p.buildPcap(); err != nil {
+		return errors.Wrap(err, "activate pcap")
+	}
+
+	f, err := p.createFile()
+	if err != nil {
+		return errors.Wrap(err, "activate pcap")
+	}
+	w, err := newPcapWriter(f)
+	if err != nil {
+		f.Close()
+		return errors.Wrap(err, "activate pcap")
+	}
+	p.file = f
+	p.writer = w
+	p.active = true
+	return nil
+}
+
+func (p *Pcap) Deactivate() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.active {
+		return errors.Wrap(errOutputNull, p.String())
+	}
+	log.Infof("Deactivating output %s", p)
+
+	p.active = false
+	err := p.closeFlows()
+	if e := p.writer.flush(); err == nil {
+		err = e
+	}
+	if e := p.file.Close(); err == nil {
+		err = e
+	}
+	p.writer = nil
+	p.file = nil
+	return errors.Wrap(err, "deactivate pcap")
+}
+
+// createFile creates the capture file, the names of the files which exist are
+// skipped, e.g., logspout.pcap, logspout-1.pcap, logspout-2.pcap.
+func (p *Pcap) createFile() (*os.File, error) {
+	ext := filepath.Ext(p.FileName)
+	base := strings.TrimSuffix(p.FileName, ext)
+	for seq := 0; ; seq++ {
+		name := p.FileName
+		if seq > 0 {
+			name = fmt.Sprintf("%s-%d%s", base, seq, ext)
+		}
+		f, err := os.OpenFile(filepath.Join(p.Directory, name), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if !os.IsExist(err) {
+			return f, err
+		}
+	}
+}
+
+// buildPcap fills in the default parameters and parses the addresses.
+func (p *Pcap) buildPcap() error {
+	if p.FileName == "" {
+		p.FileName = defaultPcapFileName
+	}
+	if p.Directory == "" {
+		p.Directory = defaultDir
+	}
+	if p.Protocol == "" {
+		p.Protocol = "udp"
+	}
+	p.Protocol = strings.ToLower(p.Protocol)
+	if p.Protocol != "udp" && p.Protocol != "tcp" {
+		return errors.Wrap(errUnknownProtocol, p.Protocol)
+	}
+	if p.Src == "" {
+		p.Src = defaultPcapSrc
+	}
+	if p.Dst == "" {
+		p.Dst = defaultPcapDst
+	}
+	if p.SrcMAC == "" {
+		p.SrcMAC = defaultPcapSrcMAC
+	}
+	if p.DstMAC == "" {
+		p.DstMAC = defaultPcapDstMAC
+	}
+	if p.Tag == "" {
+		p.Tag = defaultPcapTag
+	}
+
+	var err error
+	if p.src, err = parseEndpoint(p.Src); err != nil {
+		return err
+	}
+	if p.dst, err = parseEndpoint(p.Dst); err != nil {
+		return err
+	}
+	if p.dst.port == 0 {
+		return errors.Wrap(errInvalidAddr, p.Dst)
+	}
+	if p.srcMAC, err = net.ParseMAC(p.SrcMAC); err != nil {
+		return err
+	}
+	if p.dstMAC, err = net.ParseMAC(p.DstMAC); err != nil {
+		return err
+	}
+
+	p.ipID = 0
+	p.ports = make(map[string]uint16)
+	p.flows = make(map[string]*tcpFlow)
+	p.flowKeys = nil
+	p.rand = rand.New(rand.NewSource(time.Now().UnixNano()))
+	return nil
+}
+
+// parseEndpoint parses an address in the host:port format, the host must be
+// an IP address.
+func parseEndpoint(addr string) (endpoint, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return endpoint{}, errors.Wrap(errInvalidAddr, addr)
+	}
+	ip := net.ParseIP(host)
+	pn, err := strconv.ParseUint(port, 10, 16)
+	if ip == nil || err != nil {
+		return endpoint{}, errors.Wrap(errInvalidAddr, addr)
+	}
+	return endpoint{ip: ip, port: uint16(pn)}, nil
+}
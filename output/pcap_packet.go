@@ -0,0 +1,228 @@
+package output
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// This file encodes synthetic Ethernet/IP/UDP and Ethernet/IP/TCP frames and
+// writes them in the classic libpcap file format.
+
+const (
+	pcapMagic        = 0xa1b2c3d4
+	pcapVersionMajor = 2
+	pcapVersionMinor = 4
+	pcapSnapLen      = 65535
+	linkTypeEthernet = 1
+)
+
+const (
+	etherTypeIPv4 = 0x0800
+	etherTypeIPv6 = 0x86DD
+
+	protoTCP = 6
+	protoUDP = 17
+
+	ethHeaderLen  = 14
+	ipv4HeaderLen = 20
+	ipv6HeaderLen = 40
+	udpHeaderLen  = 8
+	tcpHeaderLen  = 20
+
+	defaultTTL = 64
+)
+
+// The TCP flags
+const (
+	tcpFIN = 0x01
+	tcpSYN = 0x02
+	tcpPSH = 0x08
+	tcpACK = 0x10
+)
+
+var errAddrFamily = errors.New("source and destination are of different address families")
+
+// endpoint is one side of a flow.
+type endpoint struct {
+	ip   net.IP
+	port uint16
+}
+
+func (e endpoint) String() string {
+	return net.JoinHostPort(e.ip.String(), strconv.Itoa(int(e.port)))
+}
+
+// packet is the layer 3 and layer 4 information of a frame.
+type packet struct {
+	srcMAC, dstMAC net.HardwareAddr
+	src, dst       endpoint
+	// id is the IPv4 identification field
+	id uint16
+	// reply is set for the packets from the destination to the source
+	reply bool
+
+	// TCP only
+	seq, ack uint32
+	flags    byte
+}
+
+// udpFrame encodes an Ethernet frame carrying a UDP datagram.
+func udpFrame(p *packet, payload []byte) ([]byte, error) {
+	seg := make([]byte, udpHeaderLen+len(payload))
+	binary.BigEndian.PutUint16(seg[0:], p.src.port)
+	binary.BigEndian.PutUint16(seg[2:], p.dst.port)
+	binary.BigEndian.PutUint16(seg[4:], uint16(len(seg)))
+	copy(seg[udpHeaderLen:], payload)
+	return ipFrame(p, protoUDP, seg, 6)
+}
+
+// tcpFrame encodes an Ethernet frame carrying a TCP segment.
+func tcpFrame(p *packet, payload []byte) ([]byte, error) {
+	seg := make([]byte, tcpHeaderLen+len(payload))
+	binary.BigEndian.PutUint16(seg[0:], p.src.port)
+	binary.BigEndian.PutUint16(seg[2:], p.dst.port)
+	binary.BigEndian.PutUint32(seg[4:], p.seq)
+	binary.BigEndian.PutUint32(seg[8:], p.ack)
+	seg[12] = (tcpHeaderLen / 4) << 4
+	seg[13] = p.flags
+	binary.BigEndian.PutUint16(seg[14:], 65535) // window
+	copy(seg[tcpHeaderLen:], payload)
+	return ipFrame(p, protoTCP, seg, 16)
+}
+
+// ipFrame wraps the transport segment with the IP and Ethernet headers, and
+// fills in the transport checksum at csumOff of the segment.
+func ipFrame(p *packet, proto byte, seg []byte, csumOff int) ([]byte, error) {
+	src4, dst4 := p.src.ip.To4(), p.dst.ip.To4()
+	if (src4 == nil) != (dst4 == nil) {
+		return nil, errors.Wrap(errAddrFamily, p.src.String()+" > "+p.dst.String())
+	}
+
+	var frame []byte
+	if src4 != nil {
+		frame = make([]byte, ethHeaderLen+ipv4HeaderLen+len(seg))
+		ethHeader(frame, p, etherTypeIPv4)
+
+		ip := frame[ethHeaderLen:]
+		ip[0] = 0x45
+		binary.BigEndian.PutUint16(ip[2:], uint16(ipv4HeaderLen+len(seg)))
+		binary.BigEndian.PutUint16(ip[4:], p.id)
+		binary.BigEndian.PutUint16(ip[6:], 0x4000) // don't fragment
+		ip[8] = defaultTTL
+		ip[9] = proto
+		copy(ip[12:], src4)
+		copy(ip[16:], dst4)
+		binary.BigEndian.PutUint16(ip[10:], checksum(ip[:ipv4HeaderLen]))
+
+		pseudo := make([]byte, 12, 12+len(seg))
+		copy(pseudo[0:], src4)
+		copy(pseudo[4:], dst4)
+		pseudo[9] = proto
+		binary.BigEndian.PutUint16(pseudo[10:], uint16(len(seg)))
+		putTransportChecksum(seg, csumOff, pseudo)
+		copy(ip[ipv4HeaderLen:], seg)
+	} else {
+		frame = make([]byte, ethHeaderLen+ipv6HeaderLen+len(seg))
+		ethHeader(frame, p, etherTypeIPv6)
+
+		ip := frame[ethHeaderLen:]
+		ip[0] = 0x60
+		binary.BigEndian.PutUint16(ip[4:], uint16(len(seg)))
+		ip[6] = proto
+		ip[7] = defaultTTL
+		copy(ip[8:], p.src.ip.To16())
+		copy(ip[24:], p.dst.ip.To16())
+
+		pseudo := make([]byte, 40, 40+len(seg))
+		copy(pseudo[0:], p.src.ip.To16())
+		copy(pseudo[16:], p.dst.ip.To16())
+		binary.BigEndian.PutUint32(pseudo[32:], uint32(len(seg)))
+		pseudo[39] = proto
+		putTransportChecksum(seg, csumOff, pseudo)
+		copy(ip[ipv6HeaderLen:], seg)
+	}
+	return frame, nil
+}
+
+func ethHeader(frame []byte, p *packet, etherType uint16) {
+	copy(frame[0:], p.dstMAC)
+	copy(frame[6:], p.srcMAC)
+	binary.BigEndian.PutUint16(frame[12:], etherType)
+}
+
+// putTransportChecksum computes the checksum of the segment with the pseudo
+// header. A zero UDP checksum means no checksum, so it's sent as all ones.
+func putTransportChecksum(seg []byte, off int, pseudo []byte) {
+	c := checksum(append(pseudo, seg...))
+	if c == 0 {
+		c = 0xffff
+	}
+	binary.BigEndian.PutUint16(seg[off:], c)
+}
+
+// checksum is the Internet checksum of RFC 1071.
+func checksum(b []byte) uint16 {
+	var sum uint32
+	for ; len(b) > 1; b = b[2:] {
+		sum += uint32(b[0])<<8 | uint32(b[1])
+	}
+	if len(b) == 1 {
+		sum += uint32(b[0]) << 8
+	}
+	for sum > 0xffff {
+		sum = sum>>16 + sum&0xffff
+	}
+	return ^uint16(sum)
+}
+
+// pcapWriter writes the packet records of a libpcap file.
+type pcapWriter struct {
+	w *bufio.Writer
+	// last is the timestamp of the last packet, the timestamps never go back
+	// as the events may be generated by concurrent workers.
+	last time.Time
+}
+
+func newPcapWriter(w io.Writer) (*pcapWriter, error) {
+	var h [24]byte
+	binary.LittleEndian.PutUint32(h[0:], pcapMagic)
+	binary.LittleEndian.PutUint16(h[4:], pcapVersionMajor)
+	binary.LittleEndian.PutUint16(h[6:], pcapVersionMinor)
+	binary.LittleEndian.PutUint32(h[16:], pcapSnapLen)
+	binary.LittleEndian.PutUint32(h[20:], linkTypeEthernet)
+
+	pw := &pcapWriter{w: bufio.NewWriter(w)}
+	if _, err := pw.w.Write(h[:]); err != nil {
+		return nil, errors.Wrap(err, "write pcap header")
+	}
+	return pw, nil
+}
+
+// writePacket writes a frame captured at time t.
+func (pw *pcapWriter) writePacket(t time.Time, frame []byte) error {
+	if t.Before(pw.last) {
+		t = pw.last
+	}
+	pw.last = t
+
+	var h [16]byte
+	binary.LittleEndian.PutUint32(h[0:], uint32(t.Unix()))
+	binary.LittleEndian.PutUint32(h[4:], uint32(t.Nanosecond()/int(time.Microsecond)))
+	binary.LittleEndian.PutUint32(h[8:], uint32(len(frame)))
+	binary.LittleEndian.PutUint32(h[12:], uint32(len(frame)))
+	if _, err := pw.w.Write(h[:]); err != nil {
+		return errors.Wrap(err, "write pcap record")
+	}
+	_, err := pw.w.Write(frame)
+	return errors.Wrap(err, "write pcap record")
+}
+
+func (pw *pcapWriter) flush() error {
+	return pw.w.Flush()
+}
@@ -0,0 +1,178 @@
+package output
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPcapString(t *testing.T) {
+	p := &Pcap{}
+	assert.NotEmpty(t, p.String())
+	assert.NotEmpty(t, p.ID())
+	assert.Equal(t, pcap, p.Type())
+}
+
+func TestPcapActivate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pcap")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	p := &Pcap{Directory: dir}
+	assert.Nil(t, p.Activate())
+	assert.Equal(t, defaultPcapFileName, p.FileName)
+	assert.Equal(t, "udp", p.Protocol)
+	assert.Equal(t, defaultPcapDst, p.Dst)
+	assert.Nil(t, p.Deactivate())
+	assert.NotNil(t, p.Deactivate())
+
+	for _, bad := range []*Pcap{
+		{Directory: dir, Protocol: "sctp"},
+		{Directory: dir, Src: "localhost:1"},
+		{Directory: dir, Dst: "10.0.0.2"},
+		{Directory: dir, Dst: "10.0.0.2:0"},
+		{Directory: dir, SrcMAC: "02:00"},
+	} {
+		assert.NotNil(t, bad.Activate(), bad.String())
+	}
+}
+
+func TestPcapReactivate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pcap")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	// the capture of an earlier activation isn't overwritten
+	p := &Pcap{Directory: dir}
+	for _, msg := range []string{"hello", "world"} {
+		assert.Nil(t, p.Activate())
+		_, err := p.Write([]byte(msg))
+		assert.Nil(t, err)
+		assert.Nil(t, p.Deactivate())
+	}
+	for _, name := range []string{"logspout.pcap", "logspout-1.pcap"} {
+		assert.Equal(t, 1, len(pcapRecords(t, filepath.Join(dir, name))), name)
+	}
+}
+
+func TestPcapWriteInactive(t *testing.T) {
+	p := &Pcap{}
+	n, err := p.WriteEvent(testEvent())
+	assert.Equal(t, 0, n)
+	assert.NotNil(t, err)
+}
+
+// pcapRecords returns the frames of a pcap file.
+func pcapRecords(t *testing.T, path string) [][]byte {
+	b, err := ioutil.ReadFile(path)
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(pcapMagic), binary.LittleEndian.Uint32(b))
+	assert.Equal(t, uint32(linkTypeEthernet), binary.LittleEndian.Uint32(b[20:]))
+
+	var frames [][]byte
+	for b = b[24:]; len(b) > 0; {
+		l := binary.LittleEndian.Uint32(b[8:])
+		frames = append(frames, b[16:16+l])
+		b = b[16+l:]
+	}
+	return frames
+}
+
+func TestPcapUDP(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pcap")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	p := &Pcap{Directory: dir, Src: "10.0.0.1:5140", Syslog: true, SrcField: "ip"}
+	assert.Nil(t, p.Activate())
+
+	e := &Event{Raw: "hello", Time: time.Now(), Names: []string{"ip"}, Values: []string{"192.168.0.1"}}
+	n, err := p.WriteEvent(e)
+	assert.Nil(t, err)
+	assert.Equal(t, 5, n)
+
+	_, err = p.Write([]byte("world"))
+	assert.Nil(t, err)
+
+	e.Values = []string{"not an ip"}
+	_, err = p.WriteEvent(e)
+	assert.NotNil(t, err)
+
+	e.Values = []string{"fd00::1"}
+	_, err = p.WriteEvent(e)
+	assert.NotNil(t, err)
+	assert.Nil(t, p.Deactivate())
+
+	frames := pcapRecords(t, filepath.Join(dir, defaultPcapFileName))
+	assert.Equal(t, 2, len(frames))
+
+	f := frames[0]
+	assert.Equal(t, uint16(etherTypeIPv4), binary.BigEndian.Uint16(f[12:]))
+	ip := f[ethHeaderLen:]
+	assert.Equal(t, uint16(0), checksum(ip[:ipv4HeaderLen]))
+	assert.Equal(t, byte(protoUDP), ip[9])
+	assert.Equal(t, net.ParseIP("192.168.0.1").To4(), net.IP(ip[12:16]))
+	udp := ip[ipv4HeaderLen:]
+	assert.Equal(t, uint16(5140), binary.BigEndian.Uint16(udp))
+	assert.Equal(t, uint16(514), binary.BigEndian.Uint16(udp[2:]))
+	assert.Contains(t, string(udp[udpHeaderLen:]), "192.168.0.1 logspout: hello")
+
+	ip = frames[1][ethHeaderLen:]
+	assert.Equal(t, net.ParseIP("10.0.0.1").To4(), net.IP(ip[12:16]))
+}
+
+func TestPcapTCP(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pcap")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	p := &Pcap{Directory: dir, Protocol: "tcp", Src: "[fd00::1]:0", Dst: "[fd00::2]:6514"}
+	assert.Nil(t, p.Activate())
+	_, err = p.Write(make([]byte, 2000))
+	assert.Nil(t, err)
+	_, err = p.Write([]byte("a"))
+	assert.Nil(t, err)
+	assert.Nil(t, p.Deactivate())
+
+	frames := pcapRecords(t, filepath.Join(dir, defaultPcapFileName))
+	var flags []byte
+	var sent int
+	for _, f := range frames {
+		assert.Equal(t, uint16(etherTypeIPv6), binary.BigEndian.Uint16(f[12:]))
+		tcp := f[ethHeaderLen+ipv6HeaderLen:]
+		flags = append(flags, tcp[13])
+		if binary.BigEndian.Uint16(tcp[2:]) == 6514 {
+			sent += len(tcp) - tcpHeaderLen
+		}
+	}
+	assert.Equal(t, []byte{
+		tcpSYN, tcpSYN | tcpACK, tcpACK, // handshake
+		tcpACK, tcpPSH | tcpACK, tcpACK, // 2000 bytes in two segments
+		tcpPSH | tcpACK, tcpACK,
+		tcpFIN | tcpACK, tcpFIN | tcpACK, tcpACK,
+	}, flags)
+	// the events are delimited by line feeds
+	assert.Equal(t, 2003, sent)
+}
+
+func TestChecksum(t *testing.T) {
+	// the example of RFC 1071
+	assert.Equal(t, ^uint16(0xddf2), checksum([]byte{0x00, 0x01, 0xf2, 0x03, 0xf4, 0xf5, 0xf6, 0xf7}))
+	assert.Equal(t, ^uint16(0x0100), checksum([]byte{0x01}))
+}
+
+func TestParseEndpoint(t *testing.T) {
+	e, err := parseEndpoint("[::1]:80")
+	assert.Nil(t, err)
+	assert.Equal(t, uint16(80), e.port)
+	assert.Equal(t, "[::1]:80", e.String())
+
+	_, err = parseEndpoint("10.0.0.1:65536")
+	assert.NotNil(t, err)
+}
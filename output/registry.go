@@ -1,10 +1,15 @@
 package output
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
+	"sort"
 	"sync"
+	"time"
 
 	"github.com/jiwen624/logspout/log"
+	"github.com/jiwen624/logspout/metrics"
 
 	"github.com/jiwen624/logspout/utils"
 
@@ -18,7 +23,18 @@ type Registry struct {
 	// Where the data is written to. There may be multiple destinations for a
 	// specific output type.
 	m map[Type]map[ID]Output
-	// The mutex to protect the global map above
+	// The outputs activated through the registry, only they are written to.
+	// It's keyed by the IDs the outputs are registered with, which may differ
+	// from their current IDs as the defaults are filled on activation.
+	active map[ID]bool
+	// The metrics of the active outputs
+	stats map[ID]*metrics.OutputStats
+	// The configurations of the outputs registered from them, which tell if an
+	// output is changed when the configuration is reconciled.
+	confs map[ID]string
+	// The mutex to protect the global maps above. An output is activated or
+	// deactivated with the write lock held so that it never happens during a
+	// write.
 	sync.RWMutex
 	// Make sure the global registry is initialized only once
 	sync.Once
@@ -30,8 +46,18 @@ var (
 	ErrDuplicate           = errors.New("duplicate ID found")
 	ErrNotFound            = errors.New("output not found")
 	ErrEmptyRegistry       = errors.New("registry is empty")
+	ErrAlreadyActive       = errors.New("output is already active")
+	ErrNotActive           = errors.New("output is not active")
 )
 
+// Info is the description of a registered output.
+type Info struct {
+	ID     ID     `json:"id"`
+	Type   Type   `json:"type"`
+	Active bool   `json:"active"`
+	Desc   string `json:"desc"`
+}
+
 func (r *Registry) Size() int {
 	r.Lock()
 	defer r.Unlock()
@@ -70,9 +96,7 @@ func (r *Registry) Register(output Output) error {
 	// 	return errors.Wrap(err, "register failed")
 	// }
 
-	r.Do(func() {
-		r.m = make(map[Type]map[ID]Output)
-	})
+	r.init()
 
 	typ := output.Type()
 	tm, ok := r.m[typ]
@@ -91,8 +115,17 @@ func (r *Registry) Register(output Output) error {
 	return nil
 }
 
+func (r *Registry) init() {
+	r.Do(func() {
+		r.m = make(map[Type]map[ID]Output)
+		r.active = make(map[ID]bool)
+		r.stats = make(map[ID]*metrics.OutputStats)
+		r.confs = make(map[ID]string)
+	})
+}
+
 // Unregister unregisters an output from the global registry. When an output is
-// unregistered it will be deactivated automatically.
+// unregistered it will be deactivated automatically if it's active.
 func (r *Registry) Unregister(output Output) error {
 	if output == nil || r.Size() == 0 {
 		return ErrUnRegisterNilOutput
@@ -103,10 +136,6 @@ func (r *Registry) Unregister(output Output) error {
 	r.Lock()
 	defer r.Unlock()
 
-	if err := output.Deactivate(); err != nil {
-		return errors.Wrap(err, "unregister failed:")
-	}
-
 	tm, ok := r.m[typ]
 	if !ok {
 		return errors.Wrap(ErrNotFound, fmt.Sprintf("Type: %v", typ))
@@ -115,15 +144,38 @@ func (r *Registry) Unregister(output Output) error {
 	if _, ok := tm[id]; !ok {
 		return errors.Wrap(ErrNotFound, fmt.Sprintf("ID: %v", id))
 	}
+	return r.unregister(id, output)
+}
+
+// unregister deactivates the output if it's active and removes it. The caller
+// must hold the lock.
+func (r *Registry) unregister(id ID, output Output) error {
+	typ := output.Type()
+
+	if r.active[id] {
+		if err := output.Deactivate(); err != nil {
+			return errors.Wrap(err, "unregister failed:")
+		}
+	}
 	log.Debugf("Unregistering output id: %s type: %v", id, typ)
+	r.forget(id, output)
+	return nil
+}
+
+// forget removes the output from the registry without deactivating it. The
+// caller must hold the lock.
+func (r *Registry) forget(id ID, output Output) {
+	typ := output.Type()
+	tm := r.m[typ]
 
+	delete(r.active, id)
+	delete(r.stats, id)
 	delete(tm, id)
+	delete(r.confs, id)
 
 	if len(tm) == 0 {
 		delete(r.m, typ)
 	}
-
-	return nil
 }
 
 // Get accepts and output ID and returns the output object
@@ -135,6 +187,188 @@ func (r *Registry) Get(id ID) (output Output, err error) {
 	return
 }
 
+// Remove deactivates the output if it's active and unregisters it.
+func (r *Registry) Remove(id ID) error {
+	r.Lock()
+	defer r.Unlock()
+
+	o, err := r.get(id)
+	if err != nil {
+		return err
+	}
+	return r.unregister(id, o)
+}
+
+// get returns the output of the ID. The caller must hold the lock.
+func (r *Registry) get(id ID) (Output, error) {
+	for _, tm := range r.m {
+		if o, ok := tm[id]; ok {
+			return o, nil
+		}
+	}
+	return nil, errors.Wrap(ErrNotFound, fmt.Sprintf("ID: %s", id))
+}
+
+// Activate activates the output and starts writing to it.
+func (r *Registry) Activate(id ID) error {
+	r.Lock()
+	defer r.Unlock()
+
+	o, err := r.get(id)
+	if err != nil {
+		return err
+	}
+	return r.activate(id, o)
+}
+
+// activate activates the output registered with the ID. The caller must hold
+// the lock.
+func (r *Registry) activate(id ID, o Output) error {
+	if r.active[id] {
+		return errors.Wrap(ErrAlreadyActive, o.String())
+	}
+	if err := o.Activate(); err != nil {
+		return err
+	}
+	r.markActive(id, o)
+	return nil
+}
+
+// markActive writes to the activated output from now on. The caller must hold
+// the lock.
+func (r *Registry) markActive(id ID, o Output) {
+	r.active[id] = true
+	// The metrics are named after the description which is complete after
+	// the activation.
+	r.stats[id] = metrics.Output(o.String())
+}
+
+// Deactivate stops writing to the output and deactivates it.
+func (r *Registry) Deactivate(id ID) error {
+	r.Lock()
+	defer r.Unlock()
+
+	o, err := r.get(id)
+	if err != nil {
+		return err
+	}
+	return r.deactivate(id, o)
+}
+
+// deactivate deactivates the output registered with the ID. The caller must
+// hold the lock.
+func (r *Registry) deactivate(id ID, o Output) error {
+	if !r.active[id] {
+		return errors.Wrap(ErrNotActive, o.String())
+	}
+	delete(r.active, id)
+	delete(r.stats, id)
+	return o.Deactivate()
+}
+
+// ActivateAll activates all the inactive outputs.
+func (r *Registry) ActivateAll() error {
+	r.Lock()
+	defer r.Unlock()
+
+	var errs []error
+	for _, tm := range r.m {
+		for id, o := range tm {
+			if r.active[id] {
+				continue
+			}
+			if err := r.activate(id, o); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return utils.CombineErrs(errs)
+}
+
+// DeactivateAll deactivates all the active outputs.
+func (r *Registry) DeactivateAll() error {
+	r.Lock()
+	defer r.Unlock()
+
+	var errs []error
+	for _, tm := range r.m {
+		for id, o := range tm {
+			if !r.active[id] {
+				continue
+			}
+			if err := r.deactivate(id, o); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return utils.CombineErrs(errs)
+}
+
+// FlushAll flushes the active outputs which buffer the events, see Flusher.
+func (r *Registry) FlushAll() error {
+	r.RLock()
+	defer r.RUnlock()
+
+	var errs []error
+	for _, tm := range r.m {
+		for id, o := range tm {
+			f, ok := o.(Flusher)
+			if !ok || !r.active[id] {
+				continue
+			}
+			if err := f.Flush(); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return utils.CombineErrs(errs)
+}
+
+// IsActive tells if the output is active.
+func (r *Registry) IsActive(id ID) bool {
+	r.RLock()
+	defer r.RUnlock()
+
+	return r.active[id]
+}
+
+// Info returns the description of the output.
+func (r *Registry) Info(id ID) (Info, error) {
+	r.RLock()
+	defer r.RUnlock()
+
+	o, err := r.get(id)
+	if err != nil {
+		return Info{}, err
+	}
+	return r.info(id, o), nil
+}
+
+func (r *Registry) info(id ID, o Output) Info {
+	return Info{ID: id, Type: o.Type(), Active: r.active[id], Desc: o.String()}
+}
+
+// List returns the descriptions of all the outputs ordered by the types and the
+// IDs.
+func (r *Registry) List() []Info {
+	r.RLock()
+	defer r.RUnlock()
+
+	infos := []Info{}
+	for _, tm := range r.m {
+		for id, o := range tm {
+			infos = append(infos, r.info(id, o))
+		}
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		if infos[i].Type != infos[j].Type {
+			return infos[i].Type < infos[j].Type
+		}
+		return infos[i].ID < infos[j].ID
+	})
+	return infos
+}
+
 // ForEach applies the operation to each output which matches the predicate
 func (r *Registry) ForEach(apply Apply, predicate Predicate) error {
 	r.RLock()
@@ -195,13 +429,38 @@ func (r *Registry) ForOne(apply Apply, typ Type, id ID) error {
 // It writes to all the outputs one by one, which may be a performance
 // bottleneck.
 func (r *Registry) Write(str string) error {
-	return r.ForAll(func(o Output) error {
-		n, err := o.Write([]byte(str))
-		if err == nil {
+	return r.WriteEvent(&Event{Raw: str})
+}
+
+// WriteEvent writes the event to all the active outputs. The outputs which
+// implement EventWriter receive the whole event, the others receive the
+// rendered string. The events, bytes, errors and latencies of the writes are
+// recorded in the metrics of the outputs.
+func (r *Registry) WriteEvent(e *Event) error {
+	r.RLock()
+	defer r.RUnlock()
+
+	if r.m == nil {
+		return ErrEmptyRegistry
+	}
+
+	var errs []error
+	for _, tm := range r.m {
+		for id, o := range tm {
+			if !r.active[id] {
+				continue
+			}
+			start := time.Now()
+			n, err := writeEvent(o, e)
+			r.stats[id].Write(n, err, time.Since(start))
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
 			log.Debugf("Wrote %d bytes to %s", n, o)
 		}
-		return err
-	})
+	}
+	return utils.CombineErrs(errs)
 }
 
 func NewRegistry() *Registry {
@@ -215,11 +474,142 @@ func RegistryFromConf(ow map[string]Wrapper) (*Registry, error) {
 	r := &Registry{}
 
 	var errs []error
-	for _, o := range om {
+	for name, o := range om {
 		if err := r.Register(o); err != nil {
 			errs = append(errs, err)
+			continue
 		}
+		r.Lock()
+		r.confs[o.ID()] = confKey(ow[name])
+		r.Unlock()
 	}
 
 	return r, utils.CombineErrs(errs)
 }
+
+// Reconcile makes the outputs the configured ones, see Prepare and
+// Reconciliation.Commit.
+func (r *Registry) Reconcile(ow map[string]Wrapper) error {
+	rc, err := r.Prepare(ow)
+	if err != nil {
+		return err
+	}
+	return rc.Commit()
+}
+
+// Reconciliation is the changes to make the outputs the configured ones: the
+// outputs not configured any more are removed, the new ones are added, and the
+// ones whose configurations are changed are replaced. The unchanged ones are
+// kept as they are.
+type Reconciliation struct {
+	r *Registry
+	// the configurations of all the configured outputs
+	confs map[ID]string
+	// the new and changed outputs, which are activated
+	outputs map[ID]Output
+}
+
+// Prepare builds and activates the new and changed outputs of the
+// configurations, but the registry isn't changed until the reconciliation is
+// committed. Nothing is changed if any of the configurations is invalid or any
+// of the outputs fails to be activated.
+func (r *Registry) Prepare(ow map[string]Wrapper) (*Reconciliation, error) {
+	rc := &Reconciliation{
+		r:       r,
+		confs:   make(map[ID]string, len(ow)),
+		outputs: make(map[ID]Output, len(ow)),
+	}
+	for name, w := range ow {
+		o, err := New(w)
+		if err != nil {
+			return nil, errors.Wrap(err, name)
+		}
+		if _, ok := rc.outputs[o.ID()]; ok {
+			return nil, errors.Wrap(ErrDuplicate, name)
+		}
+		rc.outputs[o.ID()], rc.confs[o.ID()] = o, confKey(w)
+	}
+
+	r.RLock()
+	for id := range rc.outputs {
+		if conf, ok := r.confs[id]; ok && conf == rc.confs[id] {
+			delete(rc.outputs, id)
+		}
+	}
+	r.RUnlock()
+
+	// They are activated without the lock as it may take a while, e.g., to
+	// connect to a server.
+	var activated []Output
+	for _, o := range rc.outputs {
+		if err := o.Activate(); err != nil {
+			for _, a := range activated {
+				if e := a.Deactivate(); e != nil {
+					log.Warn(errors.Wrap(e, "prepare outputs"))
+				}
+			}
+			return nil, errors.Wrap(err, o.String())
+		}
+		activated = append(activated, o)
+	}
+	return rc, nil
+}
+
+// Commit applies the changes to the registry. Only the outputs registered from
+// the configurations are reconciled, the ones added at runtime, e.g., through
+// the console, are kept unless they are replaced by configured outputs with
+// the same IDs. An output that fails to be deactivated is removed anyway and
+// the error is returned.
+func (rc *Reconciliation) Commit() error {
+	r := rc.r
+	r.Lock()
+	defer r.Unlock()
+	r.init()
+
+	var errs []error
+	for _, tm := range r.m {
+		for id, o := range tm {
+			_, configured := r.confs[id]
+			_, wanted := rc.confs[id]
+			_, replaced := rc.outputs[id]
+			if !replaced && (wanted || !configured) {
+				continue
+			}
+			log.Infof("Removing output %s.", o)
+			if err := r.unregister(id, o); err != nil {
+				errs = append(errs, err)
+				r.forget(id, o)
+			}
+		}
+	}
+	for id, o := range rc.outputs {
+		tm, ok := r.m[o.Type()]
+		if !ok {
+			tm = make(map[ID]Output, 1)
+			r.m[o.Type()] = tm
+		}
+		tm[id] = o
+		r.confs[id] = rc.confs[id]
+		r.markActive(id, o)
+		log.Infof("Added output %s.", o)
+	}
+	return utils.CombineErrs(errs)
+}
+
+// Abort deactivates the outputs activated by Prepare.
+func (rc *Reconciliation) Abort() {
+	for _, o := range rc.outputs {
+		if err := o.Deactivate(); err != nil {
+			log.Warn(errors.Wrap(err, "abort reconciliation"))
+		}
+	}
+}
+
+// confKey returns the configuration in a canonical form to be compared.
+func confKey(w Wrapper) string {
+	var b bytes.Buffer
+	if err := json.Compact(&b, w.Raw); err != nil {
+		return fmt.Sprintf("%d %s", w.T, w.Raw)
+	}
+	return fmt.Sprintf("%d %s", w.T, b.Bytes())
+}
@@ -1,9 +1,17 @@
 package output
 
 import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
 	"testing"
 
+	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
+
+	"github.com/jiwen624/logspout/metrics"
 )
 
 func TestRegistry(t *testing.T) {
@@ -91,3 +99,323 @@ func TestRegistryFromConf(t *testing.T) {
 
 	assert.Nil(t, r.ForEach(apply, predicate))
 }
+
+// countingOutput counts the writes, a write to it when it's inactive fails.
+type countingOutput struct {
+	name   string
+	mu     sync.Mutex
+	active bool
+	writes int
+}
+
+func (c *countingOutput) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.active {
+		return 0, errOutputNull
+	}
+	c.writes++
+	return len(p), nil
+}
+
+func (c *countingOutput) ID() ID         { return id(c.String()) }
+func (c *countingOutput) Type() Type     { return discard }
+func (c *countingOutput) String() string { return "counting" + c.name }
+
+func (c *countingOutput) Activate() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.active = true
+	return nil
+}
+
+func (c *countingOutput) Deactivate() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.active = false
+	return nil
+}
+
+func TestRegistryActivate(t *testing.T) {
+	r := NewRegistry()
+	a, b := &countingOutput{name: "a"}, &countingOutput{name: "b"}
+	assert.Nil(t, r.Register(a))
+	assert.Nil(t, r.Register(b))
+
+	// only the active outputs are written to
+	assert.Nil(t, r.Activate(a.ID()))
+	assert.Nil(t, r.Write("hello"))
+	assert.Equal(t, 1, a.writes)
+	assert.Equal(t, 0, b.writes)
+
+	assert.Equal(t, ErrAlreadyActive, errors.Cause(r.Activate(a.ID())))
+	assert.Equal(t, ErrNotActive, errors.Cause(r.Deactivate(b.ID())))
+	assert.Equal(t, ErrNotFound, errors.Cause(r.Activate("nonexist")))
+
+	assert.Nil(t, r.ActivateAll())
+	assert.True(t, r.IsActive(b.ID()))
+	assert.Nil(t, r.Write("hello"))
+	assert.Equal(t, 2, a.writes)
+	assert.Equal(t, 1, b.writes)
+
+	infos := r.List()
+	assert.Len(t, infos, 2)
+	assert.True(t, infos[0].Active)
+
+	assert.Nil(t, r.Deactivate(a.ID()))
+	assert.False(t, a.active)
+	info, err := r.Info(a.ID())
+	assert.Nil(t, err)
+	assert.False(t, info.Active)
+	assert.Equal(t, a.String(), info.Desc)
+
+	// an active output is deactivated when it's removed
+	assert.Nil(t, r.Remove(b.ID()))
+	assert.False(t, b.active)
+	assert.Equal(t, 1, r.Size())
+	assert.NotNil(t, r.Remove(b.ID()))
+
+	assert.Nil(t, r.DeactivateAll())
+}
+
+// bufferingOutput buffers the writes until it's flushed.
+type bufferingOutput struct {
+	countingOutput
+	buffered int
+}
+
+func (b *bufferingOutput) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.buffered++
+	return len(p), nil
+}
+
+func (b *bufferingOutput) Flush() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.writes += b.buffered
+	b.buffered = 0
+	return nil
+}
+
+func TestRegistryFlushAll(t *testing.T) {
+	r := NewRegistry()
+	a, b := &bufferingOutput{countingOutput: countingOutput{name: "a"}}, &countingOutput{name: "b"}
+	assert.Nil(t, r.Register(a))
+	assert.Nil(t, r.Register(b))
+	assert.Nil(t, r.ActivateAll())
+
+	assert.Nil(t, r.Write("hello"))
+	assert.Nil(t, r.Write("hello"))
+	assert.Equal(t, 0, a.writes)
+	assert.Equal(t, 2, b.writes)
+
+	// only the outputs which buffer the events are flushed
+	assert.Nil(t, r.FlushAll())
+	assert.Equal(t, 2, a.writes)
+	assert.Equal(t, 0, a.buffered)
+
+	// the inactive outputs are left alone
+	assert.Nil(t, r.Write("hello"))
+	assert.Nil(t, r.Deactivate(a.ID()))
+	assert.Nil(t, r.FlushAll())
+	assert.Equal(t, 1, a.buffered)
+	assert.Nil(t, r.DeactivateAll())
+}
+
+func TestRegistryConcurrentToggle(t *testing.T) {
+	r := NewRegistry()
+	a := &countingOutput{name: "a"}
+	assert.Nil(t, r.Register(a))
+	assert.Nil(t, r.Activate(a.ID()))
+
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				// never written to when it's inactive
+				assert.Nil(t, r.Write("hello"))
+			}
+		}()
+	}
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, r.Deactivate(a.ID()))
+		assert.Nil(t, r.Activate(a.ID()))
+	}
+	close(done)
+	wg.Wait()
+}
+
+// defaultedOutput fills its defaults on activation, which changes its ID.
+type defaultedOutput struct {
+	countingOutput
+	path string
+}
+
+func (d *defaultedOutput) ID() ID         { return id(d.String()) }
+func (d *defaultedOutput) String() string { return "defaulted" + d.path }
+
+func (d *defaultedOutput) Activate() error {
+	if d.path == "" {
+		d.path = "/default"
+	}
+	return d.countingOutput.Activate()
+}
+
+func TestRegistryIDChanged(t *testing.T) {
+	r := NewRegistry()
+	d := &defaultedOutput{}
+	assert.Nil(t, r.Register(d))
+	rid := d.ID()
+
+	assert.Nil(t, r.ActivateAll())
+	assert.NotEqual(t, rid, d.ID())
+	assert.True(t, r.IsActive(rid))
+	assert.Nil(t, r.ActivateAll())
+
+	info, err := r.Info(rid)
+	assert.Nil(t, err)
+	assert.Equal(t, rid, info.ID)
+	assert.True(t, info.Active)
+
+	assert.Nil(t, r.Write("hello"))
+	assert.Equal(t, 1, d.writes)
+
+	assert.Nil(t, r.Remove(rid))
+	assert.False(t, d.active)
+	assert.Equal(t, 0, r.Size())
+}
+
+func TestRegistryMetrics(t *testing.T) {
+	r := NewRegistry()
+	a, b := &countingOutput{name: "metrics-a"}, &countingOutput{name: "metrics-b"}
+	assert.Nil(t, r.Register(a))
+	assert.Nil(t, r.Register(b))
+	assert.Nil(t, r.Activate(a.ID()))
+	assert.Nil(t, r.Activate(b.ID()))
+
+	// b fails as it's deactivated behind the registry's back
+	assert.Nil(t, b.Deactivate())
+	assert.NotNil(t, r.Write("hello"))
+	assert.NotNil(t, r.Write("hello"))
+
+	sa := metrics.Output(a.String()).Snapshot()
+	assert.Equal(t, int64(2), sa.Events)
+	assert.Equal(t, int64(10), sa.Bytes)
+	assert.Equal(t, int64(0), sa.Errors)
+	assert.Equal(t, uint64(2), sa.Latency.Count)
+
+	sb := metrics.Output(b.String()).Snapshot()
+	assert.Equal(t, int64(0), sb.Events)
+	assert.Equal(t, int64(2), sb.Errors)
+
+	// nothing is recorded for an inactive output
+	assert.Nil(t, r.Deactivate(a.ID()))
+	assert.NotNil(t, r.Write("hello"))
+	assert.Equal(t, int64(2), metrics.Output(a.String()).Snapshot().Events)
+}
+
+func TestRegistryReconcile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "reconcile")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	fileConf := func(name string, maxSize int) Wrapper {
+		return Wrapper{T: file, Raw: []byte(fmt.Sprintf(`{"fileName": %q, "directory": %q, "maxSize": %d}`, name, dir, maxSize))}
+	}
+	desc := func(name string) string {
+		return (&File{FileName: name, Directory: dir}).String()
+	}
+	r, err := RegistryFromConf(map[string]Wrapper{"a": fileConf("a.log", 1), "b": fileConf("b.log", 1)})
+	assert.Nil(t, err)
+	assert.Nil(t, r.ActivateAll())
+	defer r.DeactivateAll()
+	descs := func() []string {
+		var ds []string
+		for _, info := range r.List() {
+			assert.True(t, info.Active, info.Desc)
+			ds = append(ds, info.Desc)
+		}
+		sort.Strings(ds)
+		return ds
+	}
+	aid := (&File{FileName: "a.log", Directory: dir}).ID()
+	a, err := r.Get(aid)
+	assert.Nil(t, err)
+
+	// b is removed, c is added and a is kept
+	assert.Nil(t, r.Reconcile(map[string]Wrapper{"a": fileConf("a.log", 1), "c": fileConf("c.log", 1)}))
+	assert.Equal(t, []string{desc("a.log"), desc("c.log")}, descs())
+	got, err := r.Get(aid)
+	assert.Nil(t, err)
+	assert.True(t, a == got)
+
+	// a is replaced as it's changed
+	assert.Nil(t, r.Reconcile(map[string]Wrapper{"a": fileConf("a.log", 2), "c": fileConf("c.log", 1)}))
+	assert.Equal(t, []string{desc("a.log"), desc("c.log")}, descs())
+	got, err = r.Get(aid)
+	assert.Nil(t, err)
+	assert.False(t, a == got)
+
+	// nothing is changed if any of them is invalid
+	assert.NotNil(t, r.Reconcile(map[string]Wrapper{"a": fileConf("a.log", 3), "bad": {T: Type(-1)}}))
+	assert.Equal(t, []string{desc("a.log"), desc("c.log")}, descs())
+	a, err = r.Get(aid)
+	assert.Nil(t, err)
+	assert.True(t, a == got)
+
+	// nor if any of them fails to be activated
+	bad := Wrapper{T: pcap, Raw: []byte(fmt.Sprintf(`{"directory": %q}`, dir+"/nonexist"))}
+	assert.NotNil(t, r.Reconcile(map[string]Wrapper{"a": fileConf("a.log", 3), "bad": bad}))
+	assert.Equal(t, []string{desc("a.log"), desc("c.log")}, descs())
+	a, err = r.Get(aid)
+	assert.Nil(t, err)
+	assert.True(t, a == got)
+}
+
+// stuckOutput fails to be deactivated.
+type stuckOutput struct {
+	countingOutput
+}
+
+func (s *stuckOutput) Deactivate() error { return errors.New("stuck") }
+
+func TestRegistryReconcileRuntime(t *testing.T) {
+	dir, err := ioutil.TempDir("", "reconcile")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	conf := Wrapper{T: file, Raw: []byte(fmt.Sprintf(`{"fileName": "a.log", "directory": %q}`, dir))}
+	r, err := RegistryFromConf(map[string]Wrapper{"a": conf})
+	assert.Nil(t, err)
+	assert.Nil(t, r.ActivateAll())
+	defer r.DeactivateAll()
+
+	// the output added at runtime is kept
+	c := &countingOutput{name: "runtime"}
+	assert.Nil(t, r.Register(c))
+	assert.Nil(t, r.Activate(c.ID()))
+	assert.Nil(t, r.Reconcile(map[string]Wrapper{}))
+	assert.Equal(t, 1, r.Size())
+	assert.True(t, r.IsActive(c.ID()))
+
+	// the configured output is removed even if it fails to be deactivated
+	s := &stuckOutput{countingOutput{name: "stuck"}}
+	assert.Nil(t, r.Register(s))
+	assert.Nil(t, r.Activate(s.ID()))
+	r.Lock()
+	r.confs[s.ID()] = "stuck"
+	r.Unlock()
+	assert.NotNil(t, r.Reconcile(map[string]Wrapper{}))
+	assert.Equal(t, 1, r.Size())
+	assert.False(t, r.IsActive(s.ID()))
+	_, err = r.Get(s.ID())
+	assert.Equal(t, ErrNotFound, errors.Cause(err))
+}
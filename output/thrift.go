@@ -0,0 +1,127 @@
+package output
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// The types of the thrift compact protocol
+const (
+	thriftTrue   = 1
+	thriftFalse  = 2
+	thriftI16    = 4
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftBinary = 8
+	thriftList   = 9
+	thriftStruct = 12
+)
+
+// thriftWriter encodes structs with the thrift compact protocol, which is used
+// by the metadata of Parquet files. Only the types Parquet needs are supported.
+// The fields of a struct must be written in the ascending order of their IDs.
+type thriftWriter struct {
+	bytes.Buffer
+	// the ID of the last field written in the current struct
+	lastID int16
+	// the last field IDs of the outer structs
+	stack []int16
+}
+
+func (w *thriftWriter) varint(v uint64) {
+	var b [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(b[:], v)
+	w.Write(b[:n])
+}
+
+func (w *thriftWriter) zigzag(v int64) {
+	w.varint(uint64((v << 1) ^ (v >> 63)))
+}
+
+func (w *thriftWriter) fieldHeader(id int16, typ byte) {
+	if delta := id - w.lastID; delta > 0 && delta <= 15 {
+		w.WriteByte(byte(delta)<<4 | typ)
+	} else {
+		w.WriteByte(typ)
+		w.zigzag(int64(id))
+	}
+	w.lastID = id
+}
+
+func (w *thriftWriter) boolField(id int16, v bool) {
+	if v {
+		w.fieldHeader(id, thriftTrue)
+	} else {
+		w.fieldHeader(id, thriftFalse)
+	}
+}
+
+func (w *thriftWriter) i16Field(id int16, v int16) {
+	w.fieldHeader(id, thriftI16)
+	w.zigzag(int64(v))
+}
+
+func (w *thriftWriter) i32Field(id int16, v int32) {
+	w.fieldHeader(id, thriftI32)
+	w.zigzag(int64(v))
+}
+
+func (w *thriftWriter) i64Field(id int16, v int64) {
+	w.fieldHeader(id, thriftI64)
+	w.zigzag(v)
+}
+
+func (w *thriftWriter) stringField(id int16, v string) {
+	w.fieldHeader(id, thriftBinary)
+	w.str(v)
+}
+
+func (w *thriftWriter) str(v string) {
+	w.varint(uint64(len(v)))
+	w.WriteString(v)
+}
+
+// listField writes the header of a list field, the elements are written by the
+// caller afterwards.
+func (w *thriftWriter) listField(id int16, elemType byte, size int) {
+	w.fieldHeader(id, thriftList)
+	if size < 15 {
+		w.WriteByte(byte(size)<<4 | elemType)
+	} else {
+		w.WriteByte(0xF0 | elemType)
+		w.varint(uint64(size))
+	}
+}
+
+func (w *thriftWriter) i32List(id int16, vs ...int32) {
+	w.listField(id, thriftI32, len(vs))
+	for _, v := range vs {
+		w.zigzag(int64(v))
+	}
+}
+
+func (w *thriftWriter) stringList(id int16, vs ...string) {
+	w.listField(id, thriftBinary, len(vs))
+	for _, v := range vs {
+		w.str(v)
+	}
+}
+
+// structField writes the header of a struct field and begins the struct.
+func (w *thriftWriter) structField(id int16) {
+	w.fieldHeader(id, thriftStruct)
+	w.beginStruct()
+}
+
+// beginStruct begins a struct, which is either a field or an element of a list.
+func (w *thriftWriter) beginStruct() {
+	w.stack = append(w.stack, w.lastID)
+	w.lastID = 0
+}
+
+// endStruct writes the stop field and ends the current struct.
+func (w *thriftWriter) endStruct() {
+	w.WriteByte(0)
+	w.lastID = w.stack[len(w.stack)-1]
+	w.stack = w.stack[:len(w.stack)-1]
+}
@@ -15,6 +15,11 @@ var (
 		"syslog":      syslog,
 		"kafka":       kafka,
 		"discard":     discard,
+		"clickhouse":  clickhouse,
+		"parquet":     parquet,
+		"pcap":        pcap,
+		"influx":      influx,
+		"websocket":   websocket,
 		"upperbound":  upperbound,
 	}
 
@@ -25,6 +30,11 @@ var (
 		syslog:      "syslog",
 		kafka:       "kafka",
 		discard:     "discard",
+		clickhouse:  "clickhouse",
+		parquet:     "parquet",
+		pcap:        "pcap",
+		influx:      "influx",
+		websocket:   "websocket",
 		upperbound:  "upperbound",
 	}
 )
@@ -39,6 +49,11 @@ func init() {
 			interface{}(syslog).(fmt.Stringer).String():      syslog,
 			interface{}(kafka).(fmt.Stringer).String():       kafka,
 			interface{}(discard).(fmt.Stringer).String():     discard,
+			interface{}(clickhouse).(fmt.Stringer).String():  clickhouse,
+			interface{}(parquet).(fmt.Stringer).String():     parquet,
+			interface{}(pcap).(fmt.Stringer).String():        pcap,
+			interface{}(influx).(fmt.Stringer).String():      influx,
+			interface{}(websocket).(fmt.Stringer).String():   websocket,
 			interface{}(upperbound).(fmt.Stringer).String():  upperbound,
 		}
 	}
@@ -25,11 +25,26 @@ const (
 	// To /dev/null
 	discard
 
+	// To a ClickHouse table via the HTTP interface
+	clickhouse
+
+	// To Parquet files
+	parquet
+
+	// To a libpcap file as synthetic network packets
+	pcap
+
+	// To InfluxDB (or a file) in the line protocol
+	influx
+
+	// To the clients of an embedded WebSocket endpoint
+	websocket
+
 	// the upper bound of the types enumeration
 	upperbound
 )
 
 // Types returns all output types
 func Types() []Type {
-	return []Type{console, file, syslog, kafka, discard}
+	return []Type{console, file, syslog, kafka, discard, clickhouse, parquet, pcap, influx, websocket}
 }
@@ -0,0 +1,306 @@
+package output
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/jiwen624/logspout/log"
+	"github.com/jiwen624/logspout/metrics"
+)
+
+// WebSocket runs a WebSocket endpoint and broadcasts the events to all the
+// connected clients. Each client has a bounded buffer, a client which can't
+// keep up is dropped so that it never stalls the workers.
+//
+// A client may subscribe to a subset of the events with the query parameters,
+// each of them is a field (a capture group or a pseudo field such as _logType)
+// and the values expected, e.g., ws://localhost:10307/events?_logType=weblogic&user=GuoJing.
+// The values of the same field are ORed and the fields are ANDed. The format
+// parameter overrides the message format for the client.
+type WebSocket struct {
+	// Addr is the address the endpoint listens on.
+	Addr string `json:"addr"`
+	// Path is the path of the endpoint.
+	Path string `json:"path"`
+	// Format is the message format, which can be json (the default) or raw.
+	// A json message has the time, the log type, the raw event and the named
+	// capture groups.
+	Format string `json:"format"`
+	// BufferSize is the number of messages buffered for each client.
+	BufferSize int `json:"bufferSize"`
+	// AllowedOrigins are the origins allowed to connect, all origins are
+	// allowed if it's empty.
+	AllowedOrigins []string `json:"allowedOrigins"`
+
+	server *http.Server
+	// the address actually listened on
+	addr net.Addr
+	// where the events not delivered to the slow clients are recorded
+	stats *metrics.OutputStats
+
+	// mu protects the clients
+	mu      sync.RWMutex
+	clients map[*wsClient]struct{}
+}
+
+// wsClient is a connected client.
+type wsClient struct {
+	addr    string
+	conn    *wsConn
+	filters url.Values
+	format  string
+	msgs    chan []byte
+	// dropped is closed when the client is removed
+	dropped  chan struct{}
+	dropOnce sync.Once
+}
+
+// default parameters
+const (
+	defaultWSAddr       = "localhost:10307"
+	defaultWSPath       = "/events"
+	defaultWSBufferSize = 256
+	// the query parameter of the message format
+	wsFormatParam = "format"
+)
+
+var errUnknownWSFormat = errors.New("unknown websocket message format")
+
+func (w *WebSocket) String() string {
+	return fmt.Sprintf("WebSocket{Addr:%s, Path:%s}", w.Addr, w.Path)
+}
+
+func (w *WebSocket) ID() ID {
+	return id(w.String())
+}
+
+func (w *WebSocket) Type() Type {
+	return websocket
+}
+
+// Write broadcasts a log event which has no capture groups.
+func (w *WebSocket) Write(p []byte) (n int, err error) {
+	return w.WriteEvent(&Event{Raw: string(p), Time: time.Now()})
+}
+
+// WriteEvent broadcasts the event to the clients subscribing to it. It never
+// blocks, a client whose buffer is full is dropped.
+func (w *WebSocket) WriteEvent(e *Event) (n int, err error) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	if w.server == nil {
+		return 0, errors.Wrap(errOutputNull, w.String())
+	}
+
+	// the messages are encoded at most once for each format
+	var msgs = make(map[string][]byte, 2)
+	for c := range w.clients {
+		if !c.subscribes(e) {
+			continue
+		}
+		msg, ok := msgs[c.format]
+		if !ok {
+			msg = EncodeEvent(e, c.format)
+			msgs[c.format] = msg
+		}
+
+		select {
+		case c.msgs <- msg:
+		default:
+			// It's removed by its writer, as the write lock can't be
+			// taken here.
+			if c.drop() {
+				log.Warnf("%s: dropping slow client %s", w, c.addr)
+			}
+			w.stats.Drop(1)
+		}
+	}
+	return len(e.Raw), nil
+}
+
+// subscribes tells if the event matches the filters of the client.
+func (c *wsClient) subscribes(e *Event) bool {
+	return e.Matches(c.filters)
+}
+
+// drop marks the client as dropped. It's safe to be called more than once,
+// only the first call returns true.
+func (c *wsClient) drop() (dropped bool) {
+	c.dropOnce.Do(func() {
+		close(c.dropped)
+		dropped = true
+	})
+	return dropped
+}
+
+// ServeHTTP upgrades the request to a WebSocket connection and serves the
+// client until it's disconnected or dropped.
+func (w *WebSocket) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	if !w.allowOrigin(r.Header.Get("Origin")) {
+		http.Error(rw, "origin not allowed", http.StatusForbidden)
+		return
+	}
+
+	filters := r.URL.Query()
+	format := w.Format
+	if f := filters.Get(wsFormatParam); f != "" {
+		format = f
+	}
+	filters.Del(wsFormatParam)
+	if format != FormatJSON && format != FormatRaw {
+		http.Error(rw, errUnknownWSFormat.Error(), http.StatusBadRequest)
+		return
+	}
+
+	conn, err := wsUpgrade(rw, r)
+	if err != nil {
+		log.Debugf("%s: %v", w, err)
+		return
+	}
+
+	c := &wsClient{
+		addr:    r.RemoteAddr,
+		conn:    conn,
+		filters: filters,
+		format:  format,
+		msgs:    make(chan []byte, w.BufferSize),
+		dropped: make(chan struct{}),
+	}
+	if !w.addClient(c) {
+		conn.close(wsCloseGoingAway)
+		return
+	}
+	log.Debugf("%s: client %s connected", w, c.addr)
+
+	go func() {
+		conn.readLoop()
+		c.drop()
+	}()
+	w.writeLoop(c)
+
+	w.removeClient(c)
+	log.Debugf("%s: client %s disconnected", w, c.addr)
+}
+
+// writeLoop sends the messages to the client until it's dropped.
+func (w *WebSocket) writeLoop(c *wsClient) {
+	for {
+		select {
+		case msg := <-c.msgs:
+			if err := c.conn.writeText(msg); err != nil {
+				c.conn.close(wsCloseGoingAway)
+				return
+			}
+		case <-c.dropped:
+			c.conn.close(wsCloseGoingAway)
+			return
+		}
+	}
+}
+
+func (w *WebSocket) allowOrigin(origin string) bool {
+	if len(w.AllowedOrigins) == 0 || origin == "" {
+		return true
+	}
+	for _, o := range w.AllowedOrigins {
+		if o == "*" || o == origin {
+			return true
+		}
+	}
+	return false
+}
+
+// addClient adds a client unless the output is being deactivated.
+func (w *WebSocket) addClient(c *wsClient) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.server == nil {
+		return false
+	}
+	w.clients[c] = struct{}{}
+	return true
+}
+
+func (w *WebSocket) removeClient(c *wsClient) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	delete(w.clients, c)
+}
+
+func (w *WebSocket) Activate() error {
+	log.Infof("Activating output %s", w)
+
+	if err := w.buildWebSocket(); err != nil {
+		return errors.Wrap(err, "activate websocket")
+	}
+
+	l, err := net.Listen("tcp", w.Addr)
+	if err != nil {
+		return errors.Wrap(err, "activate websocket")
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle(w.Path, w)
+	srv := &http.Server{Handler: mux}
+
+	w.mu.Lock()
+	w.server = srv
+	w.addr = l.Addr()
+	w.stats = metrics.Output(w.String())
+	w.clients = make(map[*wsClient]struct{})
+	w.mu.Unlock()
+
+	go func() {
+		if err := srv.Serve(l); err != nil && err != http.ErrServerClosed {
+			log.Warn(errors.Wrap(err, w.String()))
+		}
+	}()
+	return nil
+}
+
+func (w *WebSocket) Deactivate() error {
+	w.mu.Lock()
+	srv := w.server
+	if srv == nil {
+		w.mu.Unlock()
+		return errors.Wrap(errOutputNull, w.String())
+	}
+	log.Infof("Deactivating output %s", w)
+
+	w.server = nil
+	for c := range w.clients {
+		c.drop()
+	}
+	w.mu.Unlock()
+
+	return errors.Wrap(srv.Close(), "deactivate websocket")
+}
+
+// buildWebSocket fills in the default parameters.
+func (w *WebSocket) buildWebSocket() error {
+	if w.Addr == "" {
+		w.Addr = defaultWSAddr
+	}
+	if w.Path == "" {
+		w.Path = defaultWSPath
+	}
+	if w.Format == "" {
+		w.Format = FormatJSON
+	}
+	if w.BufferSize <= 0 {
+		w.BufferSize = defaultWSBufferSize
+	}
+	if w.Format != FormatJSON && w.Format != FormatRaw {
+		return errors.Wrap(errUnknownWSFormat, w.Format)
+	}
+	return nil
+}
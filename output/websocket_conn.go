@@ -0,0 +1,231 @@
+package output
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// This file implements the server side of the WebSocket protocol (RFC 6455)
+// which is needed to push text messages to the clients. Fragmented messages
+// and extensions are not supported, the data frames from the clients are read
+// and discarded.
+
+// the GUID to compute Sec-WebSocket-Accept
+const wsGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// The opcodes of the frames
+const (
+	wsOpContinuation = 0x0
+	wsOpText         = 0x1
+	wsOpBinary       = 0x2
+	wsOpClose        = 0x8
+	wsOpPing         = 0x9
+	wsOpPong         = 0xA
+)
+
+const (
+	// the maximum size of a frame from a client
+	wsMaxReadFrame = 1 << 16
+	// the close status codes
+	wsCloseNormal    = 1000
+	wsCloseGoingAway = 1001
+	wsCloseTooBig    = 1009
+	// the timeout of writing a frame
+	wsWriteTimeout = 10 * time.Second
+)
+
+var (
+	errNotWebSocket = errors.New("not a websocket handshake")
+	errFrameTooBig  = errors.New("websocket frame too big")
+	errNotMasked    = errors.New("websocket frame from client not masked")
+)
+
+// wsConn is an established WebSocket connection.
+type wsConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+
+	// wmu serializes the frames written
+	wmu       sync.Mutex
+	closeOnce sync.Once
+}
+
+// wsAccept computes the Sec-WebSocket-Accept of a Sec-WebSocket-Key.
+func wsAccept(key string) string {
+	h := sha1.New()
+	h.Write([]byte(key + wsGUID))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+// headerContains tells if a comma separated header contains the token.
+func headerContains(h http.Header, name, token string) bool {
+	for _, v := range h[http.CanonicalHeaderKey(name)] {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// wsUpgrade completes the opening handshake and takes over the connection.
+func wsUpgrade(w http.ResponseWriter, r *http.Request) (*wsConn, error) {
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if r.Method != http.MethodGet || key == "" ||
+		!headerContains(r.Header, "Connection", "upgrade") ||
+		!headerContains(r.Header, "Upgrade", "websocket") {
+		http.Error(w, errNotWebSocket.Error(), http.StatusBadRequest)
+		return nil, errNotWebSocket
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "unsupported websocket version", http.StatusUpgradeRequired)
+		return nil, errNotWebSocket
+	}
+
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "websocket not supported", http.StatusInternalServerError)
+		return nil, errNotWebSocket
+	}
+	conn, rw, err := hj.Hijack()
+	if err != nil {
+		return nil, errors.Wrap(err, "websocket upgrade")
+	}
+
+	rsp := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + wsAccept(key) + "\r\n\r\n"
+	conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+	if _, err := conn.Write([]byte(rsp)); err != nil {
+		conn.Close()
+		return nil, errors.Wrap(err, "websocket upgrade")
+	}
+	return &wsConn{conn: conn, r: rw.Reader}, nil
+}
+
+// writeFrame writes an unmasked frame with the FIN bit set.
+func (c *wsConn) writeFrame(op byte, payload []byte) error {
+	var h [10]byte
+	h[0] = 0x80 | op
+	n := 2
+	switch l := len(payload); {
+	case l < 126:
+		h[1] = byte(l)
+	case l <= 0xFFFF:
+		h[1] = 126
+		binary.BigEndian.PutUint16(h[2:], uint16(l))
+		n = 4
+	default:
+		h[1] = 127
+		binary.BigEndian.PutUint64(h[2:], uint64(l))
+		n = 10
+	}
+
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+
+	c.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+	if _, err := c.conn.Write(h[:n]); err != nil {
+		return err
+	}
+	_, err := c.conn.Write(payload)
+	return err
+}
+
+// writeText sends a text message.
+func (c *wsConn) writeText(msg []byte) error {
+	return c.writeFrame(wsOpText, msg)
+}
+
+// readFrame reads a frame from the client and returns its opcode and the
+// unmasked payload.
+func (c *wsConn) readFrame() (byte, []byte, error) {
+	var h [8]byte
+	if _, err := io.ReadFull(c.r, h[:2]); err != nil {
+		return 0, nil, err
+	}
+	op := h[0] & 0x0F
+	if h[1]&0x80 == 0 {
+		return 0, nil, errNotMasked
+	}
+
+	l := uint64(h[1] & 0x7F)
+	switch l {
+	case 126:
+		if _, err := io.ReadFull(c.r, h[:2]); err != nil {
+			return 0, nil, err
+		}
+		l = uint64(binary.BigEndian.Uint16(h[:2]))
+	case 127:
+		if _, err := io.ReadFull(c.r, h[:8]); err != nil {
+			return 0, nil, err
+		}
+		l = binary.BigEndian.Uint64(h[:8])
+	}
+	if l > wsMaxReadFrame {
+		return 0, nil, errFrameTooBig
+	}
+
+	var mask [4]byte
+	if _, err := io.ReadFull(c.r, mask[:]); err != nil {
+		return 0, nil, err
+	}
+	payload := make([]byte, l)
+	if _, err := io.ReadFull(c.r, payload); err != nil {
+		return 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return op, payload, nil
+}
+
+// readLoop handles the control frames from the client until the connection is
+// closed, the data frames are discarded.
+func (c *wsConn) readLoop() {
+	defer c.close(wsCloseNormal)
+
+	for {
+		op, payload, err := c.readFrame()
+		switch {
+		case err == errFrameTooBig:
+			c.close(wsCloseTooBig)
+			return
+		case err != nil:
+			return
+		}
+
+		switch op {
+		case wsOpClose:
+			return
+		case wsOpPing:
+			if c.writeFrame(wsOpPong, payload) != nil {
+				return
+			}
+		}
+	}
+}
+
+// close sends a close frame with the status code and closes the connection.
+// It's safe to be called more than once.
+func (c *wsConn) close(code uint16) {
+	c.closeOnce.Do(func() {
+		var p [2]byte
+		binary.BigEndian.PutUint16(p[:], code)
+		c.writeFrame(wsOpClose, p[:])
+		c.conn.Close()
+	})
+}
@@ -0,0 +1,184 @@
+package output
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// wsTestClient is a minimal WebSocket client to test the output.
+type wsTestClient struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func dialWS(t *testing.T, w *WebSocket, query string) (*wsTestClient, *http.Response) {
+	conn, err := net.Dial("tcp", w.addr.String())
+	assert.Nil(t, err)
+
+	req, _ := http.NewRequest(http.MethodGet, "http://"+w.addr.String()+w.Path+query, nil)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+	assert.Nil(t, req.Write(conn))
+
+	r := bufio.NewReader(conn)
+	rsp, err := http.ReadResponse(r, req)
+	assert.Nil(t, err)
+	return &wsTestClient{conn: conn, r: r}, rsp
+}
+
+// read reads a frame from the server.
+func (c *wsTestClient) read() (byte, []byte, error) {
+	c.conn.SetReadDeadline(time.Now().Add(time.Second))
+	var h [2]byte
+	if _, err := io.ReadFull(c.r, h[:]); err != nil {
+		return 0, nil, err
+	}
+	l := int(h[1] & 0x7F)
+	if l == 126 {
+		var b [2]byte
+		io.ReadFull(c.r, b[:])
+		l = int(binary.BigEndian.Uint16(b[:]))
+	}
+	p := make([]byte, l)
+	_, err := io.ReadFull(c.r, p)
+	return h[0] & 0x0F, p, err
+}
+
+// write writes a masked frame to the server.
+func (c *wsTestClient) write(op byte, payload []byte) {
+	mask := []byte{1, 2, 3, 4}
+	b := []byte{0x80 | op, 0x80 | byte(len(payload))}
+	b = append(b, mask...)
+	for i, v := range payload {
+		b = append(b, v^mask[i%4])
+	}
+	c.conn.Write(b)
+}
+
+// waitClients waits until the number of connected clients is n.
+func waitClients(w *WebSocket, n int) bool {
+	for i := 0; i < 100; i++ {
+		w.mu.RLock()
+		l := len(w.clients)
+		w.mu.RUnlock()
+		if l == n {
+			return true
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return false
+}
+
+func TestWebSocket_Type(t *testing.T) {
+	w := &WebSocket{}
+	assert.Equal(t, websocket, w.Type())
+	assert.Equal(t, id(w.String()), w.ID())
+}
+
+func TestWebSocket_WriteInactive(t *testing.T) {
+	w := &WebSocket{}
+	n, err := w.WriteEvent(testEvent())
+	assert.Equal(t, 0, n)
+	assert.Contains(t, err.Error(), errOutputNull.Error())
+	assert.NotNil(t, w.Deactivate())
+
+	w = &WebSocket{Format: "xml"}
+	assert.NotNil(t, w.Activate())
+}
+
+func TestWSAccept(t *testing.T) {
+	// the example of RFC 6455
+	assert.Equal(t, "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=", wsAccept("dGhlIHNhbXBsZSBub25jZQ=="))
+}
+
+func TestWebSocket_Broadcast(t *testing.T) {
+	w := &WebSocket{Addr: "127.0.0.1:0"}
+	assert.Nil(t, w.Activate())
+	defer w.Deactivate()
+
+	all, rsp := dialWS(t, w, "")
+	assert.Equal(t, http.StatusSwitchingProtocols, rsp.StatusCode)
+	assert.Equal(t, wsAccept("dGhlIHNhbXBsZSBub25jZQ=="), rsp.Header.Get("Sec-WebSocket-Accept"))
+	raw, _ := dialWS(t, w, "?format=raw&user=nobody&user=GuoJing")
+	none, _ := dialWS(t, w, "?user=nobody")
+	assert.True(t, waitClients(w, 3))
+
+	e := testEvent()
+	e.LogType = "weblogic"
+	_, err := w.WriteEvent(e)
+	assert.Nil(t, err)
+
+	op, p, err := all.read()
+	assert.Nil(t, err)
+	assert.Equal(t, byte(wsOpText), op)
+	var m EventMessage
+	assert.Nil(t, json.Unmarshal(p, &m))
+	assert.Equal(t, "weblogic", m.LogType)
+	assert.Equal(t, e.Raw, m.Raw)
+	assert.Equal(t, "42", m.Fields["thread"])
+
+	_, p, err = raw.read()
+	assert.Nil(t, err)
+	assert.Equal(t, e.Raw, string(p))
+
+	// ping is answered and the filtered client receives nothing
+	none.write(wsOpPing, []byte("hi"))
+	op, p, err = none.read()
+	assert.Nil(t, err)
+	assert.Equal(t, byte(wsOpPong), op)
+	assert.Equal(t, "hi", string(p))
+
+	// a client is removed after it closes the connection
+	raw.write(wsOpClose, nil)
+	op, _, _ = raw.read()
+	assert.Equal(t, byte(wsOpClose), op)
+	assert.True(t, waitClients(w, 2))
+}
+
+func TestWebSocket_BadRequest(t *testing.T) {
+	w := &WebSocket{Addr: "127.0.0.1:0", AllowedOrigins: []string{"http://a.com"}}
+	assert.Nil(t, w.Activate())
+	defer w.Deactivate()
+
+	rsp, err := http.Get("http://" + w.addr.String() + w.Path)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusBadRequest, rsp.StatusCode)
+
+	_, rsp = dialWS(t, w, "?format=xml")
+	assert.Equal(t, http.StatusBadRequest, rsp.StatusCode)
+
+	req, _ := http.NewRequest(http.MethodGet, "http://"+w.addr.String()+w.Path, nil)
+	req.Header.Set("Origin", "http://b.com")
+	rsp, err = http.DefaultClient.Do(req)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusForbidden, rsp.StatusCode)
+}
+
+func TestWebSocket_SlowClient(t *testing.T) {
+	w := &WebSocket{clients: make(map[*wsClient]struct{}), server: &http.Server{}}
+	c := &wsClient{msgs: make(chan []byte, 1), dropped: make(chan struct{})}
+	w.clients[c] = struct{}{}
+
+	_, err := w.Write([]byte("a"))
+	assert.Nil(t, err)
+	// the buffer is full, the client is dropped rather than blocking
+	_, err = w.Write([]byte("b"))
+	assert.Nil(t, err)
+
+	select {
+	case <-c.dropped:
+	default:
+		t.Error("slow client not dropped")
+	}
+	assert.False(t, c.drop())
+}
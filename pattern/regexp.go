@@ -10,12 +10,25 @@ type Re struct {
 	re *regexp.Regexp
 }
 
-// New returns a new Re object
+// New returns a new Re object, it panics if the pattern can't be compiled.
 func New(pattern string) *Re {
+	r, err := Compile(pattern)
+	if err != nil {
+		panic(err)
+	}
+	return r
+}
+
+// Compile returns a new Re object, or an error if the pattern can't be
+// compiled.
+func Compile(pattern string) (*Re, error) {
 	// TODO: support pcre with auto detection/fallback
 	// TODO: support fixed arrays
-	ptn := reConvert(pattern)
-	return &Re{re: regexp.MustCompile(ptn)}
+	re, err := regexp.Compile(reConvert(pattern))
+	if err != nil {
+		return nil, err
+	}
+	return &Re{re: re}, nil
 }
 
 // Matches parses the input string and returns the slice of matches sub-strings
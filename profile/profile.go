@@ -0,0 +1,259 @@
+// Package profile defines the load profiles, which shape the target rate of
+// the generation over time, e.g., a linear ramp or a 24-hour diurnal curve. A
+// profile returns a factor of the configured rate (eps or bytesPerSecond) at
+// each point of time, so that the same profile works for any rate.
+package profile
+
+import (
+	"encoding/json"
+	"math"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// The types of the profiles.
+const (
+	TypeRamp    = "ramp"
+	TypeStep    = "step"
+	TypeSine    = "sine"
+	TypeSpike   = "spike"
+	TypeDiurnal = "diurnal"
+)
+
+var (
+	errUnknownType = errors.New("unknown load profile type")
+	errBadProfile  = errors.New("bad load profile")
+)
+
+// Config is the type of a profile and its attributes, e.g.,
+//
+//	{"type": "ramp", "attrs": {"from": 0.1, "to": 1, "seconds": 600}}
+type Config struct {
+	Type  string          `json:"type"`
+	Attrs json.RawMessage `json:"attrs"`
+}
+
+// Profile is a load profile.
+type Profile interface {
+	// Factor returns the factor of the configured rate at the time since the
+	// generation is started. It's non-negative, 0 means no events.
+	Factor(elapsed time.Duration) float64
+	// String returns the type of the profile.
+	String() string
+}
+
+// initializers create the profiles of the types with the defaults.
+var initializers = map[string]func() profile{
+	TypeRamp:    func() profile { return &Ramp{} },
+	TypeStep:    func() profile { return &Step{} },
+	TypeSine:    func() profile { return &Sine{Base: 1} },
+	TypeSpike:   func() profile { return &Spike{Base: 1} },
+	TypeDiurnal: func() profile { return &Diurnal{Max: 1, PeakHour: 14, Day: secondsPerDay} },
+}
+
+// profile is a Profile which checks its attributes.
+type profile interface {
+	Profile
+	check() error
+}
+
+// New creates a profile from the config.
+func New(c Config) (Profile, error) {
+	init, ok := initializers[c.Type]
+	if !ok {
+		return nil, errors.Wrap(errUnknownType, c.Type)
+	}
+	p := init()
+	if len(c.Attrs) != 0 {
+		if err := json.Unmarshal(c.Attrs, p); err != nil {
+			return nil, errors.Wrap(err, "new load profile")
+		}
+	}
+	if err := p.check(); err != nil {
+		return nil, errors.Wrap(err, c.Type)
+	}
+	return p, nil
+}
+
+// badProfile returns an error of a bad attribute.
+func badProfile(msg string) error {
+	return errors.Wrap(errBadProfile, msg)
+}
+
+func checkFactors(fs ...float64) error {
+	for _, f := range fs {
+		if f < 0 || math.IsNaN(f) || math.IsInf(f, 0) {
+			return badProfile("factors must be non-negative")
+		}
+	}
+	return nil
+}
+
+// Ramp changes the factor linearly from From to To in Seconds, then keeps it
+// at To, or starts over if Repeat is set.
+type Ramp struct {
+	From    float64 `json:"from"`
+	To      float64 `json:"to"`
+	Seconds int     `json:"seconds"`
+	Repeat  bool    `json:"repeat"`
+}
+
+func (r *Ramp) String() string { return TypeRamp }
+
+func (r *Ramp) check() error {
+	if r.Seconds <= 0 {
+		return badProfile("seconds must be positive")
+	}
+	return checkFactors(r.From, r.To)
+}
+
+// Factor implements Profile.
+func (r *Ramp) Factor(elapsed time.Duration) float64 {
+	t := elapsed.Seconds()
+	d := float64(r.Seconds)
+	if t >= d {
+		if !r.Repeat {
+			return r.To
+		}
+		t = math.Mod(t, d)
+	}
+	return r.From + (r.To-r.From)*t/d
+}
+
+// StepLevel is a factor kept for a number of seconds.
+type StepLevel struct {
+	Factor  float64 `json:"factor"`
+	Seconds int     `json:"seconds"`
+}
+
+// Step goes through the levels one after another, then keeps the factor of the
+// last level, or starts over if Repeat is set.
+type Step struct {
+	Levels []StepLevel `json:"levels"`
+	Repeat bool        `json:"repeat"`
+}
+
+func (s *Step) String() string { return TypeStep }
+
+func (s *Step) check() error {
+	if len(s.Levels) == 0 {
+		return badProfile("no levels")
+	}
+	for _, l := range s.Levels {
+		if l.Seconds <= 0 {
+			return badProfile("seconds must be positive")
+		}
+		if err := checkFactors(l.Factor); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Factor implements Profile.
+func (s *Step) Factor(elapsed time.Duration) float64 {
+	var total float64
+	for _, l := range s.Levels {
+		total += float64(l.Seconds)
+	}
+	t := elapsed.Seconds()
+	if t >= total {
+		if !s.Repeat {
+			return s.Levels[len(s.Levels)-1].Factor
+		}
+		t = math.Mod(t, total)
+	}
+	for _, l := range s.Levels {
+		if t < float64(l.Seconds) {
+			return l.Factor
+		}
+		t -= float64(l.Seconds)
+	}
+	return s.Levels[len(s.Levels)-1].Factor
+}
+
+// Sine swings the factor around Base by Amplitude in each Period of seconds,
+// the negative factors are taken as 0.
+type Sine struct {
+	Base      float64 `json:"base"`
+	Amplitude float64 `json:"amplitude"`
+	Period    int     `json:"period"`
+}
+
+func (s *Sine) String() string { return TypeSine }
+
+func (s *Sine) check() error {
+	if s.Period <= 0 {
+		return badProfile("period must be positive")
+	}
+	return checkFactors(s.Base, math.Abs(s.Amplitude))
+}
+
+// Factor implements Profile.
+func (s *Sine) Factor(elapsed time.Duration) float64 {
+	f := s.Base + s.Amplitude*math.Sin(2*math.Pi*elapsed.Seconds()/float64(s.Period))
+	if f < 0 {
+		return 0
+	}
+	return f
+}
+
+// Spike raises the factor from Base to Peak for Length seconds every Every
+// seconds, the first spike is Every seconds after the start.
+type Spike struct {
+	Base   float64 `json:"base"`
+	Peak   float64 `json:"peak"`
+	Every  int     `json:"every"`
+	Length int     `json:"length"`
+}
+
+func (s *Spike) String() string { return TypeSpike }
+
+func (s *Spike) check() error {
+	if s.Length <= 0 || s.Every <= s.Length {
+		return badProfile("length must be positive and less than every")
+	}
+	return checkFactors(s.Base, s.Peak)
+}
+
+// Factor implements Profile.
+func (s *Spike) Factor(elapsed time.Duration) float64 {
+	t := elapsed.Seconds()
+	if t >= float64(s.Every) && math.Mod(t, float64(s.Every)) < float64(s.Length) {
+		return s.Peak
+	}
+	return s.Base
+}
+
+const secondsPerDay = 24 * 60 * 60
+
+// Diurnal follows a 24-hour curve which is the lowest at Min 12 hours from the
+// peak and the highest at Max at PeakHour. A day takes Day seconds, which
+// compresses the time if it's less than 86400, e.g., a day in 10 minutes with
+// 600. The generation starts at StartHour of the day.
+type Diurnal struct {
+	Min       float64 `json:"min"`
+	Max       float64 `json:"max"`
+	PeakHour  float64 `json:"peakHour"`
+	StartHour float64 `json:"startHour"`
+	Day       int     `json:"day"`
+}
+
+func (d *Diurnal) String() string { return TypeDiurnal }
+
+func (d *Diurnal) check() error {
+	if d.Day <= 0 {
+		return badProfile("day must be positive")
+	}
+	if d.Min > d.Max {
+		return badProfile("min must not be greater than max")
+	}
+	return checkFactors(d.Min, d.Max)
+}
+
+// Factor implements Profile.
+func (d *Diurnal) Factor(elapsed time.Duration) float64 {
+	hour := d.StartHour + 24*elapsed.Seconds()/float64(d.Day)
+	return d.Min + (d.Max-d.Min)*(1+math.Cos(2*math.Pi*(hour-d.PeakHour)/24))/2
+}
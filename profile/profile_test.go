@@ -0,0 +1,86 @@
+package profile
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func newProfile(t *testing.T, typ, attrs string) Profile {
+	p, err := New(Config{Type: typ, Attrs: json.RawMessage(attrs)})
+	assert.Nil(t, err)
+	return p
+}
+
+func TestRamp(t *testing.T) {
+	p := newProfile(t, TypeRamp, `{"from": 0, "to": 1, "seconds": 10}`)
+	assert.Equal(t, "ramp", p.String())
+	assert.Equal(t, 0.0, p.Factor(0))
+	assert.InDelta(t, 0.5, p.Factor(5*time.Second), 1e-9)
+	assert.Equal(t, 1.0, p.Factor(time.Minute))
+
+	p = newProfile(t, TypeRamp, `{"from": 1, "to": 0, "seconds": 10, "repeat": true}`)
+	assert.InDelta(t, 0.8, p.Factor(12*time.Second), 1e-9)
+}
+
+func TestStep(t *testing.T) {
+	p := newProfile(t, TypeStep, `{"levels": [{"factor": 0.5, "seconds": 2}, {"factor": 2, "seconds": 3}]}`)
+	assert.Equal(t, 0.5, p.Factor(time.Second))
+	assert.Equal(t, 2.0, p.Factor(2*time.Second))
+	assert.Equal(t, 2.0, p.Factor(time.Hour))
+
+	p = newProfile(t, TypeStep, `{"levels": [{"factor": 0.5, "seconds": 2}, {"factor": 2, "seconds": 3}], "repeat": true}`)
+	assert.Equal(t, 0.5, p.Factor(6*time.Second))
+}
+
+func TestSine(t *testing.T) {
+	p := newProfile(t, TypeSine, `{"amplitude": 0.5, "period": 60}`)
+	assert.InDelta(t, 1.0, p.Factor(0), 1e-9)
+	assert.InDelta(t, 1.5, p.Factor(15*time.Second), 1e-9)
+	assert.InDelta(t, 0.5, p.Factor(45*time.Second), 1e-9)
+
+	p = newProfile(t, TypeSine, `{"base": 0.5, "amplitude": 1, "period": 60}`)
+	assert.Equal(t, 0.0, p.Factor(45*time.Second))
+}
+
+func TestSpike(t *testing.T) {
+	p := newProfile(t, TypeSpike, `{"peak": 5, "every": 60, "length": 10}`)
+	assert.Equal(t, 1.0, p.Factor(5*time.Second))
+	assert.Equal(t, 5.0, p.Factor(65*time.Second))
+	assert.Equal(t, 1.0, p.Factor(70*time.Second))
+	assert.Equal(t, 5.0, p.Factor(120*time.Second))
+}
+
+func TestDiurnal(t *testing.T) {
+	// a day in 10 minutes
+	p := newProfile(t, TypeDiurnal, `{"min": 0.2, "day": 600}`)
+	assert.InDelta(t, 0.2, p.Factor(50*time.Second), 1e-9)  // 2am
+	assert.InDelta(t, 1.0, p.Factor(350*time.Second), 1e-9) // 2pm
+	assert.InDelta(t, 0.6, p.Factor(200*time.Second), 1e-9) // 8am
+
+	p = newProfile(t, TypeDiurnal, `{"startHour": 14}`)
+	assert.InDelta(t, 1.0, p.Factor(0), 1e-9)
+	assert.InDelta(t, 0.0, p.Factor(12*time.Hour), 1e-9)
+}
+
+func TestNewBadConfig(t *testing.T) {
+	_, err := New(Config{Type: "square"})
+	assert.Equal(t, errUnknownType, errors.Cause(err))
+
+	for typ, attrs := range map[string]string{
+		TypeRamp:    `{"to": 1}`,
+		TypeStep:    `{"levels": [{"factor": -1, "seconds": 1}]}`,
+		TypeSine:    `{"period": 0}`,
+		TypeSpike:   `{"every": 10, "length": 10}`,
+		TypeDiurnal: `{"min": 2, "max": 1}`,
+	} {
+		_, err := New(Config{Type: typ, Attrs: json.RawMessage(attrs)})
+		assert.Equal(t, errBadProfile, errors.Cause(err), typ)
+	}
+
+	_, err = New(Config{Type: TypeRamp, Attrs: json.RawMessage(`{"seconds": "10"}`)})
+	assert.NotNil(t, err)
+}
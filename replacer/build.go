@@ -166,3 +166,23 @@ func Build(replace []byte) (map[string]Replacer, error) {
 	err := jsonparser.ObjectEach(replace, handler)
 	return replacerMap, err
 }
+
+// ListFiles returns the files the fixedList replacers read their lists from,
+// in the same form as Build takes. The replacers which are invalid are skipped.
+func ListFiles(replace []byte) []string {
+	var files []string
+	jsonparser.ObjectEach(replace, func(key []byte, value []byte, dataType jsonparser.ValueType, offset int) error {
+		if t, _ := jsonparser.GetString(value, config.TYPE); t != config.FIXEDLIST {
+			return nil
+		}
+		// The list takes precedence over the list file.
+		if _, _, _, err := jsonparser.Get(value, config.ATTRS, config.LIST); err == nil {
+			return nil
+		}
+		if f, err := jsonparser.GetString(value, config.ATTRS, config.LISTFILE); err == nil {
+			files = append(files, f)
+		}
+		return nil
+	})
+	return files
+}
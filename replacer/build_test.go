@@ -278,3 +278,14 @@ func TestInvalidBuild(t *testing.T) {
 	_, err = Build(rawMsg)
 	assert.NotNil(t, err)
 }
+
+func TestListFiles(t *testing.T) {
+	rawMsg := []byte(`{
+    "severity": {"type": "fixedList", "attrs": {"method": "random", "listFile": "severity.sample"}},
+    "user": {"type": "fixedList", "attrs": {"method": "random", "list": ["alice"], "listFile": "user.sample"}},
+    "host": {"type": "fixedList", "attrs": {"method": "next", "listFile": "host.sample"}},
+    "thread": {"type": "integer", "attrs": {"method": "next", "min": 1, "max": 100}}
+  }`)
+	assert.Equal(t, []string{"severity.sample", "host.sample"}, ListFiles(rawMsg))
+	assert.Nil(t, ListFiles(nil))
+}
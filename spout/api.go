@@ -0,0 +1,329 @@
+package spout
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/jiwen624/logspout/config"
+	"github.com/jiwen624/logspout/console"
+	"github.com/jiwen624/logspout/log"
+	"github.com/jiwen624/logspout/metrics"
+)
+
+// APIEndPoint is the prefix of the control API of the console:
+//
+//	GET   /api/v1/status     the state and the progress of the generation
+//	GET   /api/v1/config     the effective configuration
+//	PATCH /api/v1/config     changes the concurrency, the intervals and the
+//	                         rate, e.g., {"concurrency": 8, "minInterval": 0,
+//	                         "maxInterval": 10} or {"eps": 25000}, the rate
+//	                         is either in events or in bytes per second, e.g.,
+//	                         {"bytesPerSecond": 1e6}, setting one clears the
+//	                         other
+//	GET   /api/v1/report     the report of the run so far
+//	GET   /api/v1/tail       streams the events generated as Server-Sent
+//	                         Events, e.g., ?sample=0.01&seed=0&user=bob, see
+//	                         serveTail
+//	POST  /api/v1/pause      pauses the generation
+//	POST  /api/v1/resume     resumes the paused generation
+//	POST  /api/v1/stop       stops the generation, the console keeps serving
+//	POST  /api/v1/shutdown   stops the generation, closes the outputs and
+//	                         exits, the report of the run is returned
+const APIEndPoint = "/api/v1/"
+
+// the maximum size of the body of a request
+const maxAPIBody = 1 << 16
+
+// Status is the state and the progress of the generation.
+type Status struct {
+	State     State     `json:"state"`
+	Version   string    `json:"version"`
+	Commit    string    `json:"commit"`
+	StartTime time.Time `json:"startTime"`
+	// Uptime is the number of seconds since the workers are started
+	Uptime float64 `json:"uptime"`
+	// Duration is the number of seconds to run for, 0 means forever
+	Duration int `json:"duration"`
+	// MaxEvents is the maximum number of events to generate
+	MaxEvents int `json:"maxEvents"`
+	// Workers is the number of the workers which haven't exited
+	Workers     int `json:"workers"`
+	Concurrency int `json:"concurrency"`
+	MinInterval int `json:"minInterval"`
+	MaxInterval int `json:"maxInterval"`
+	// EPS is the rate in events per second the workers are paced at, 0 means
+	// they are not paced.
+	EPS float64 `json:"eps"`
+	// BytesPerSecond is the rate in bytes per second the workers are paced
+	// at, 0 means they are not paced.
+	BytesPerSecond float64 `json:"bytesPerSecond"`
+	// LoadProfile is the type of the load profile which shapes the rate, it's
+	// empty if the rate is flat.
+	LoadProfile string `json:"loadProfile"`
+	// LoadFactor is the current factor of the rate by the load profile
+	LoadFactor float64 `json:"loadFactor"`
+	// WorkerProfile is the type of the worker profile which shapes the
+	// concurrency, it's empty if the concurrency is flat.
+	WorkerProfile string `json:"workerProfile"`
+	// WorkerFactor is the current factor of the concurrency by the worker
+	// profile
+	WorkerFactor float64 `json:"workerFactor"`
+	// Events is the number of events generated
+	Events int64 `json:"events"`
+	// Rate is the number of events generated in the last second
+	Rate int64 `json:"rate"`
+	// ConfiguredRate is the rate in events per second the workers are
+	// configured to generate at, 0 means unlimited.
+	ConfiguredRate float64 `json:"configuredRate"`
+}
+
+// Settings are the settings which can be changed at runtime, the absent ones
+// are left unchanged.
+type Settings struct {
+	Concurrency    *int     `json:"concurrency"`
+	MinInterval    *int     `json:"minInterval"`
+	MaxInterval    *int     `json:"maxInterval"`
+	EPS            *float64 `json:"eps"`
+	BytesPerSecond *float64 `json:"bytesPerSecond"`
+}
+
+// Status returns the state and the progress of the generation.
+func (s *Spout) Status() Status {
+	st := s.State()
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	status := Status{
+		State:          st,
+		Version:        Version(),
+		Commit:         Commit(),
+		StartTime:      s.startTime,
+		Duration:       s.Duration,
+		MaxEvents:      s.MaxEvents,
+		Workers:        s.running,
+		Concurrency:    s.Concurrency,
+		MinInterval:    s.MinInterval,
+		MaxInterval:    s.MaxInterval,
+		EPS:            s.EPS,
+		BytesPerSecond: s.BytesPerSecond,
+		LoadFactor:     s.factor,
+		WorkerFactor:   s.workerFactor,
+		Events:         metrics.TotalEvents(),
+		Rate:           metrics.AchievedRate(),
+		ConfiguredRate: metrics.ConfiguredRate(),
+	}
+	if s.LoadProfile != nil {
+		status.LoadProfile = s.LoadProfile.String()
+	}
+	if s.WorkerProfile != nil {
+		status.WorkerProfile = s.WorkerProfile.String()
+	}
+	if !s.startTime.IsZero() {
+		status.Uptime = time.Since(s.startTime).Seconds()
+	}
+	return status
+}
+
+// Config returns the effective configuration, which is the one the spout is
+// built from with the defaults and the runtime changes applied. The outputs
+// are the configured ones, see the output management endpoints for the
+// current ones.
+func (s *Spout) Config() config.SpoutConfig {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var c config.SpoutConfig
+	if s.conf != nil {
+		c = *s.conf
+	}
+	c.MaxEvents = s.MaxEvents
+	c.ConsolePort = s.ConsolePort
+	c.Console = s.ConsoleConfig.Redacted()
+	c.Concurrency = s.Concurrency
+	c.MinInterval = s.MinInterval
+	c.MaxInterval = s.MaxInterval
+	c.EPS = s.EPS
+	c.BytesPerSecond = s.BytesPerSecond
+	c.GBPerDay = 0
+	return c
+}
+
+// Apply applies the settings. They are checked as a whole before any change
+// is made, so none of them is applied if any of them is invalid, or if the
+// concurrency is changed after the generation is done.
+func (s *Spout) Apply(st Settings) error {
+	if st.Concurrency != nil && *st.Concurrency <= 0 {
+		return ErrBadConcurrency
+	}
+	var eps, bps float64
+	if st.EPS != nil {
+		eps = *st.EPS
+	}
+	if st.BytesPerSecond != nil {
+		bps = *st.BytesPerSecond
+	}
+	if err := checkRate(eps, bps); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	min, max := s.MinInterval, s.MaxInterval
+	if st.MinInterval != nil {
+		min = *st.MinInterval
+	}
+	if st.MaxInterval != nil {
+		max = *st.MaxInterval
+	}
+	if err := checkIntervals(min, max); err != nil {
+		return err
+	}
+	if st.Concurrency != nil && s.done() {
+		return ErrStopped
+	}
+
+	if st.MinInterval != nil || st.MaxInterval != nil {
+		s.setIntervals(min, max)
+	}
+	if st.EPS != nil || st.BytesPerSecond != nil {
+		s.setRate(eps, bps)
+	}
+	if st.Concurrency != nil {
+		s.setConcurrency(*st.Concurrency)
+	}
+	metrics.SetConfiguredRate(s.nominalRate())
+	return nil
+}
+
+// APIHandler returns the HTTP handler of the control API, which should be
+// registered to APIEndPoint.
+func APIHandler(s *Spout) http.Handler {
+	return &apiHandler{s: s}
+}
+
+type apiHandler struct {
+	s *Spout
+}
+
+func (h *apiHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, APIEndPoint), "/")
+
+	var method string
+	var serve func(http.ResponseWriter, *http.Request)
+	switch path {
+	case "status":
+		method = http.MethodGet
+		serve = func(w http.ResponseWriter, r *http.Request) {
+			console.WriteJSON(w, http.StatusOK, h.s.Status())
+		}
+	case "config":
+		if r.Method == http.MethodPatch {
+			method, serve = http.MethodPatch, h.patchConfig
+			break
+		}
+		method = http.MethodGet
+		serve = func(w http.ResponseWriter, r *http.Request) {
+			console.WriteJSON(w, http.StatusOK, h.s.Config())
+		}
+	case "report":
+		method = http.MethodGet
+		serve = func(w http.ResponseWriter, r *http.Request) {
+			console.WriteJSON(w, http.StatusOK, h.s.Report())
+		}
+	case "tail":
+		method, serve = http.MethodGet, h.s.serveTail
+	case "pause":
+		method, serve = http.MethodPost, h.control(h.s.Pause)
+	case "resume":
+		method, serve = http.MethodPost, h.control(h.s.Resume)
+	case "stop":
+		method, serve = http.MethodPost, h.control(h.s.Halt)
+	case "shutdown":
+		method, serve = http.MethodPost, h.shutdown
+	default:
+		console.WriteStatus(w, http.StatusNotFound)
+		return
+	}
+
+	if r.Method != method {
+		w.Header().Set("Allow", method)
+		console.WriteStatus(w, http.StatusMethodNotAllowed)
+		return
+	}
+	serve(w, r)
+}
+
+// control returns a handler which calls the operation and writes the status.
+func (h *apiHandler) control(op func() error) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := op(); err != nil {
+			console.WriteError(w, errorStatus(err), err)
+			return
+		}
+		console.WriteJSON(w, http.StatusOK, h.s.Status())
+	}
+}
+
+func (h *apiHandler) patchConfig(w http.ResponseWriter, r *http.Request) {
+	var st Settings
+	dec := json.NewDecoder(io.LimitReader(r.Body, maxAPIBody))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&st); err != nil {
+		console.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := h.s.Apply(st); err != nil {
+		console.WriteError(w, errorStatus(err), err)
+		return
+	}
+	console.WriteJSON(w, http.StatusOK, h.s.Config())
+}
+
+// trackShutdown tracks a shutdown request being served unless the spout is
+// closed, and returns the function to call once it's served. It's tracked
+// under the lock, so it never races with the wait for the requests which
+// starts after the spout is closed.
+func (s *Spout) trackShutdown() (done func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed() {
+		return func() {}
+	}
+	s.shutdowns.Add(1)
+	return s.shutdowns.Done
+}
+
+func (h *apiHandler) shutdown(w http.ResponseWriter, r *http.Request) {
+	// The spout doesn't exit until the response is sent.
+	defer h.s.trackShutdown()()
+
+	log.Info("Shutting down through the console.")
+	report, err := h.s.Shutdown()
+	if err != nil {
+		log.Warn(errors.Wrap(err, "shutdown"))
+	}
+	console.WriteJSON(w, http.StatusOK, report)
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// errorStatus returns the status code of the cause of the error.
+func errorStatus(err error) int {
+	status := http.StatusInternalServerError
+	switch errors.Cause(err) {
+	case ErrNotRunning, ErrNotPaused, ErrStopped:
+		status = http.StatusConflict
+	case ErrBadConcurrency, ErrBadIntervals, ErrBadRate, errRateConflict:
+		status = http.StatusBadRequest
+	}
+	return status
+}
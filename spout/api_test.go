@@ -0,0 +1,178 @@
+package spout
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/jiwen624/logspout/output"
+	"github.com/jiwen624/logspout/replacer"
+)
+
+// testSpout returns a spout whose workers write to a discard output.
+func testSpout(t *testing.T) *Spout {
+	s := NewDefault()
+	s.Concurrency = 2
+	s.MaxEvents = 1 << 30
+	s.MinInterval, s.MaxInterval = 1, 2
+	s.ConsolePort = defaultConsolePort
+	s.seedLogs = []string{"hello world"}
+	s.Replacers = replacer.Replacers{}
+	s.Output = output.NewRegistry()
+	assert.Nil(t, s.Output.Register(&output.Discard{}))
+	assert.Nil(t, s.StartAllOutputs())
+	return s
+}
+
+func request(h http.Handler, method, path, body string) (int, map[string]interface{}) {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(method, APIEndPoint+path, strings.NewReader(body)))
+	var m map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &m)
+	return w.Code, m
+}
+
+func TestAPI(t *testing.T) {
+	s := testSpout(t)
+	h := APIHandler(s)
+	s.StartWorkers([][]string{{"hello", " world"}}, [][]string{{"", ""}})
+
+	done := make(chan struct{})
+	go func() {
+		s.WaitForWorkers()
+		close(done)
+	}()
+
+	code, m := request(h, http.MethodGet, "status", "")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "running", m["state"])
+	assert.Equal(t, float64(2), m["workers"])
+
+	code, m = request(h, http.MethodPost, "pause", "")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "paused", m["state"])
+	code, _ = request(h, http.MethodPost, "pause", "")
+	assert.Equal(t, http.StatusConflict, code)
+
+	// the settings can be changed while it's paused
+	code, m = request(h, http.MethodPatch, "config", `{"concurrency": 4, "maxInterval": 3}`)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, float64(4), m["concurrency"])
+	assert.Equal(t, float64(1), m["minInterval"])
+	assert.Equal(t, float64(3), m["maxInterval"])
+	assert.Equal(t, []int{1, 3}, []int{s.MinInterval, s.MaxInterval})
+
+	code, m = request(h, http.MethodPatch, "config", `{"eps": 2500.5}`)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, 2500.5, m["eps"])
+	assert.True(t, s.limiter.limited())
+	code, m = request(h, http.MethodGet, "status", "")
+	assert.Equal(t, 2500.5, m["eps"])
+	assert.Equal(t, 2500.5, m["configuredRate"])
+
+	// the rate in bytes per second replaces the one in events per second
+	code, m = request(h, http.MethodPatch, "config", `{"bytesPerSecond": 1e6}`)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, 1e6, m["bytesPerSecond"])
+	assert.Equal(t, float64(0), m["eps"])
+	code, m = request(h, http.MethodGet, "status", "")
+	// the events are about the size of the seed log
+	assert.Equal(t, 1e6/float64(len("hello world")), m["configuredRate"])
+
+	for _, body := range []string{`{"concurrency": 0}`, `{"minInterval": 5}`, `{"eps": -1}`, `{"eps": 1, "bytesPerSecond": 1}`, `{"bad": 1}`, `{`} {
+		code, _ = request(h, http.MethodPatch, "config", body)
+		assert.Equal(t, http.StatusBadRequest, code, body)
+	}
+	assert.Equal(t, 4, s.Concurrency)
+
+	code, m = request(h, http.MethodPost, "resume", "")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "running", m["state"])
+	assert.Equal(t, float64(4), m["workers"])
+
+	code, _ = request(h, http.MethodPatch, "config", `{"concurrency": 1}`)
+	assert.Equal(t, http.StatusOK, code)
+
+	code, m = request(h, http.MethodPost, "stop", "")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "stopped", m["state"])
+	code, _ = request(h, http.MethodPatch, "config", `{"concurrency": 2}`)
+	assert.Equal(t, http.StatusConflict, code)
+	// nothing is changed if any of them can't be applied
+	code, _ = request(h, http.MethodPatch, "config", `{"maxInterval": 5, "eps": 10, "concurrency": 2}`)
+	assert.Equal(t, http.StatusConflict, code)
+	assert.Equal(t, 3, s.MaxInterval)
+	assert.Equal(t, 1e6, s.BytesPerSecond)
+
+	// it doesn't exit until it's shut down
+	select {
+	case <-done:
+		t.Fatal("exited before shutdown")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	code, m = request(h, http.MethodPost, "shutdown", "")
+	assert.Equal(t, http.StatusOK, code)
+	assert.NotNil(t, m["outputs"])
+	assert.Equal(t, StateClosed, s.State())
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("not exited after shutdown")
+	}
+}
+
+func TestAPIRoutes(t *testing.T) {
+	s := testSpout(t)
+	h := APIHandler(s)
+
+	code, _ := request(h, http.MethodGet, "nonexist", "")
+	assert.Equal(t, http.StatusNotFound, code)
+	code, _ = request(h, http.MethodGet, "pause", "")
+	assert.Equal(t, http.StatusMethodNotAllowed, code)
+	code, _ = request(h, http.MethodPost, "resume", "")
+	assert.Equal(t, http.StatusConflict, code)
+
+	code, m := request(h, http.MethodGet, "config", "")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, float64(defaultConsolePort), m["consolePort"])
+
+	code, m = request(h, http.MethodGet, "report", "")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Contains(t, m, "events")
+}
+
+func TestAPIShutdownConcurrent(t *testing.T) {
+	s := testSpout(t)
+	h := APIHandler(s)
+	s.StartWorkers([][]string{{"hello", " world"}}, [][]string{{"", ""}})
+
+	done := make(chan struct{})
+	go func() {
+		s.WaitForWorkers()
+		close(done)
+	}()
+
+	// the requests racing with the spout closing are all served
+	codes := make(chan int, 8)
+	for i := 0; i < cap(codes); i++ {
+		go func() {
+			code, _ := request(h, http.MethodPost, "shutdown", "")
+			codes <- code
+		}()
+	}
+	for i := 0; i < cap(codes); i++ {
+		assert.Equal(t, http.StatusOK, <-codes)
+	}
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("not exited after shutdown")
+	}
+}
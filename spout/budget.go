@@ -0,0 +1,46 @@
+package spout
+
+import "sync/atomic"
+
+// budget is the number of events left to generate, which is shared by all the
+// workers, so that exactly MaxEvents are generated in total however many
+// workers there are and however fast each of them is.
+type budget struct {
+	left int64
+}
+
+func newBudget(n int) *budget {
+	return &budget{left: int64(n)}
+}
+
+// take takes n events from the budget and returns the number taken. If fewer
+// are left, it takes all of them unless whole is set, then it takes none.
+func (b *budget) take(n int64, whole bool) int64 {
+	for {
+		left := atomic.LoadInt64(&b.left)
+		if left <= 0 {
+			return 0
+		}
+		if left < n {
+			if whole {
+				return 0
+			}
+			n = left
+		}
+		if atomic.CompareAndSwapInt64(&b.left, left, left-n) {
+			return n
+		}
+	}
+}
+
+// refund gives back the events taken but not generated.
+func (b *budget) refund(n int64) {
+	if n > 0 {
+		atomic.AddInt64(&b.left, n)
+	}
+}
+
+// remaining returns the number of events left.
+func (b *budget) remaining() int64 {
+	return atomic.LoadInt64(&b.left)
+}
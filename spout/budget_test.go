@@ -0,0 +1,42 @@
+package spout
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBudgetTake(t *testing.T) {
+	b := newBudget(5)
+	assert.Equal(t, int64(3), b.take(3, true))
+	assert.Equal(t, int64(0), b.take(3, true))
+	assert.Equal(t, int64(2), b.take(3, false))
+	assert.Equal(t, int64(0), b.take(1, false))
+
+	b.refund(2)
+	assert.Equal(t, int64(2), b.remaining())
+	b.refund(0)
+	assert.Equal(t, int64(2), b.remaining())
+
+	// exactly the budget is taken however many take it concurrently
+	b = newBudget(1000)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var total int64
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for b.take(3, false) != 0 {
+				mu.Lock()
+				total += 3
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int64(0), b.remaining())
+	// the last take may be less than 3
+	assert.Equal(t, int64(1002), total)
+}
@@ -0,0 +1,304 @@
+package spout
+
+import (
+	"math"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/jiwen624/logspout/log"
+)
+
+// State is the state of the generation.
+type State string
+
+const (
+	StateRunning State = "running"
+	StatePaused  State = "paused"
+	// The workers have exited but the spout isn't shut down yet.
+	StateStopped State = "stopped"
+	// The spout is being shut down or it has been.
+	StateClosed State = "closed"
+)
+
+var (
+	ErrNotRunning      = errors.New("generation is not running")
+	ErrNotPaused       = errors.New("generation is not paused")
+	ErrStopped         = errors.New("generation is stopped")
+	ErrBadConcurrency  = errors.New("concurrency must be positive")
+	ErrBadIntervals    = errors.New("intervals must be non-negative and min <= max")
+	ErrBadRate         = errors.New("rate must be non-negative")
+	errRateConflict    = errors.New("only one of eps, bytesPerSecond and gbPerDay may be set")
+	errNoRateToShape   = errors.New("loadProfile needs eps, bytesPerSecond or gbPerDay")
+	errShutdownTimeout = errors.New("timed out waiting for the workers to exit")
+)
+
+// defaultDrainTimeout is how long a shutdown waits for the workers to exit by
+// default.
+const defaultDrainTimeout = 30 * time.Second
+
+const (
+	bytesPerGB    = 1e9
+	secondsPerDay = 24 * 60 * 60
+)
+
+// profileTick is how often the rate is scaled by the load profile.
+const profileTick = 100 * time.Millisecond
+
+// State returns the current state of the generation.
+func (s *Spout) State() State {
+	select {
+	case <-s.close:
+		return StateClosed
+	default:
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.state()
+}
+
+// state returns the state when the spout is not closed. The caller must hold
+// the lock.
+func (s *Spout) state() State {
+	if s.halted || s.shuttingDown {
+		return StateStopped
+	}
+	select {
+	case <-s.idle:
+		return StateStopped
+	default:
+	}
+	if s.paused != nil {
+		return StatePaused
+	}
+	return StateRunning
+}
+
+// Pause pauses the generation, the workers block until it's resumed.
+func (s *Spout) Pause() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed() || s.state() != StateRunning {
+		return ErrNotRunning
+	}
+	s.paused = make(chan struct{})
+	log.Info("Generation is paused.")
+	return nil
+}
+
+// Resume resumes the paused generation.
+func (s *Spout) Resume() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.paused == nil {
+		return ErrNotPaused
+	}
+	close(s.paused)
+	s.paused = nil
+	// The events not generated while it's paused are not made up for.
+	s.limiter.reset()
+	log.Info("Generation is resumed.")
+	return nil
+}
+
+// togglePause pauses the generation if it's running, or resumes it if it's
+// paused.
+func (s *Spout) togglePause() error {
+	if err := s.Pause(); err != ErrNotRunning {
+		return err
+	}
+	return s.Resume()
+}
+
+// Halt stops the generation but keeps the spout, along with the console, up
+// until it's shut down.
+func (s *Spout) Halt() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed() || s.state() == StateStopped {
+		return ErrStopped
+	}
+	s.halted = true
+	s.stopWorkers(len(s.workers))
+	if s.paused != nil {
+		close(s.paused)
+		s.paused = nil
+	}
+	log.Info("Generation is stopped.")
+	return nil
+}
+
+// SetConcurrency changes the number of the workers, which is scaled by the
+// worker profile if any. The workers started the last are stopped first, and
+// the events left in the budget are generated by the others.
+func (s *Spout) SetConcurrency(n int) error {
+	return s.Apply(Settings{Concurrency: &n})
+}
+
+// done tells if the generation is done, after which no worker can be started
+// as all of them exit. The caller must hold the lock.
+func (s *Spout) done() bool {
+	return s.closed() || s.state() == StateStopped
+}
+
+// setConcurrency changes the number of the workers. The caller must hold the
+// lock.
+func (s *Spout) setConcurrency(n int) {
+	if n != s.Concurrency {
+		log.Infof("Concurrency is changed from %d to %d.", s.Concurrency, n)
+	}
+	s.Concurrency = n
+	s.scaleWorkers()
+}
+
+// stopWorkers stops the last n workers, which finish their transactions before
+// they exit unless they are paused. The caller must hold the lock.
+func (s *Spout) stopWorkers(n int) {
+	for _, w := range s.workers[len(s.workers)-n:] {
+		select {
+		case <-w.quit:
+		default:
+			close(w.quit)
+		}
+	}
+	// They are removed here rather than when they exit, so that they aren't
+	// counted in the concurrency any more.
+	s.workers = s.workers[:len(s.workers)-n]
+}
+
+// SetIntervals changes the minimum and maximum intervals, which take effect in
+// the next think time of the workers.
+func (s *Spout) SetIntervals(min, max int) error {
+	return s.Apply(Settings{MinInterval: &min, MaxInterval: &max})
+}
+
+// setIntervals changes the intervals. The caller must hold the lock.
+func (s *Spout) setIntervals(min, max int) {
+	s.MinInterval, s.MaxInterval = min, max
+	log.Infof("Intervals are changed to [%d, %d].", min, max)
+}
+
+// checkIntervals checks the minimum and maximum intervals.
+func checkIntervals(min, max int) error {
+	if min < 0 || max < 0 || (max > 0 && min > max) {
+		return ErrBadIntervals
+	}
+	return nil
+}
+
+// SetEPS changes the rate in events per second of all the workers, which
+// replaces the rate in bytes per second if any. 0 means unlimited, then the
+// workers think for the intervals again.
+func (s *Spout) SetEPS(eps float64) error {
+	zero := 0.0
+	return s.Apply(Settings{EPS: &eps, BytesPerSecond: &zero})
+}
+
+// SetBytesPerSecond changes the rate in bytes per second of all the workers,
+// which replaces the rate in events per second if any. 0 means unlimited.
+func (s *Spout) SetBytesPerSecond(bps float64) error {
+	zero := 0.0
+	return s.Apply(Settings{EPS: &zero, BytesPerSecond: &bps})
+}
+
+// setRate changes the rate. The caller must hold the lock.
+func (s *Spout) setRate(eps, bps float64) {
+	s.EPS, s.BytesPerSecond = eps, bps
+	s.limiter.set(eps, bps)
+	switch {
+	case eps > 0:
+		log.Infof("Rate is changed to %g events/s.", eps)
+	case bps > 0:
+		log.Infof("Rate is changed to %g bytes/s.", bps)
+	default:
+		log.Info("Rate is unlimited.")
+	}
+}
+
+// checkRate checks the rates in events and bytes per second.
+func checkRate(eps, bps float64) error {
+	for _, r := range []float64{eps, bps} {
+		if r < 0 || math.IsNaN(r) || math.IsInf(r, 0) {
+			return ErrBadRate
+		}
+	}
+	if eps > 0 && bps > 0 {
+		return errRateConflict
+	}
+	return nil
+}
+
+// Shutdown stops the generation, waits for the workers to exit, then flushes
+// and closes the outputs and the spout. It returns the report of the run.
+func (s *Spout) Shutdown() (*Report, error) {
+	err := s.drain()
+	s.Stop()
+	return s.Report(), err
+}
+
+// drain stops the workers and waits for them to exit for at most DrainTimeout.
+// They finish the transactions they are in the middle of if FinishTransactions
+// is set, and the ones still running after the timeout are cut.
+func (s *Spout) drain() error {
+	s.mu.Lock()
+	started := !s.startTime.IsZero()
+	s.shuttingDown = true
+	s.stopWorkers(len(s.workers))
+	if s.paused != nil {
+		close(s.paused)
+		s.paused = nil
+	}
+	s.mu.Unlock()
+
+	if !started {
+		return nil
+	}
+	if !s.FinishTransactions {
+		s.cutWorkers()
+	}
+
+	var timeout <-chan time.Time
+	if s.DrainTimeout > 0 {
+		timeout = time.After(s.DrainTimeout)
+	}
+	select {
+	case <-s.idle:
+		return nil
+	case <-timeout:
+		s.cutWorkers()
+		return errShutdownTimeout
+	}
+}
+
+// cutWorkers makes the workers exit after the events being written, even in
+// the middle of the transactions.
+func (s *Spout) cutWorkers() {
+	s.cutOnce.Do(func() { close(s.cut) })
+}
+
+// drainTimeout returns how long a shutdown waits for the workers by the config
+// in seconds, where 0 means the default and a negative one waiting until they
+// exit.
+func drainTimeout(seconds int) time.Duration {
+	switch {
+	case seconds == 0:
+		return defaultDrainTimeout
+	case seconds < 0:
+		return 0
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// closed tells if the spout is closed.
+func (s *Spout) closed() bool {
+	select {
+	case <-s.close:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,214 @@
+package spout
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/jiwen624/logspout/config"
+	"github.com/jiwen624/logspout/metrics"
+	"github.com/jiwen624/logspout/profile"
+)
+
+// waitForWorkers waits until n workers are running.
+func waitForWorkers(t *testing.T, s *Spout, n int) {
+	deadline := time.Now().Add(5 * time.Second)
+	for s.Status().Workers != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d workers running, want %d", s.Status().Workers, n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestBudget(t *testing.T) {
+	s := testSpout(t)
+	s.Concurrency = 7
+	s.MaxEvents = 100
+	s.EPS = 500
+	s.limiter.set(s.EPS, 0)
+	s.StartWorkers([][]string{{"hello world"}}, [][]string{{""}})
+	defer s.Shutdown()
+
+	time.Sleep(50 * time.Millisecond)
+	assert.Nil(t, s.SetConcurrency(10))
+	time.Sleep(50 * time.Millisecond)
+	assert.Nil(t, s.SetConcurrency(1))
+	s.WaitForWorkers()
+	assert.Equal(t, int64(100), s.sprayed())
+
+	// no worker is started after the budget is used up
+	assert.Equal(t, ErrStopped, s.SetConcurrency(2))
+}
+
+func TestBudgetTransactions(t *testing.T) {
+	for _, split := range []bool{false, true} {
+		s := testSpout(t)
+		s.Concurrency = 3
+		s.MaxEvents = 10
+		s.SplitTransactions = split
+		s.seedLogs = []string{"a", "b", "c"}
+		s.TransactionID = []string{"id"}
+		s.StartWorkers([][]string{{"a"}, {"b"}, {"c"}}, [][]string{{""}, {""}, {""}})
+		s.WaitForWorkers()
+
+		r := s.Report()
+		if split {
+			assert.Equal(t, []int64{4, 3, 3}, r.Seeds)
+			assert.Equal(t, int64(0), s.budget.remaining())
+		} else {
+			// the transactions are never cut
+			assert.Equal(t, []int64{3, 3, 3}, r.Seeds)
+			assert.Equal(t, int64(1), s.budget.remaining())
+		}
+	}
+}
+
+func TestBudgetScaleDown(t *testing.T) {
+	for i := 0; i < 10; i++ {
+		s := testSpout(t)
+		s.Concurrency = 8
+		s.MaxEvents = 90
+		s.EPS = 2000
+		s.limiter.set(s.EPS, 0)
+		s.seedLogs = []string{"a", "b", "c"}
+		s.TransactionID = []string{"id"}
+		s.StartWorkers([][]string{{"a"}, {"b"}, {"c"}}, [][]string{{""}, {""}, {""}})
+
+		// The workers are retired while the others are using up the budget.
+		for s.budget.remaining() > 0 {
+			time.Sleep(100 * time.Microsecond)
+		}
+		assert.Nil(t, s.SetConcurrency(1))
+		s.WaitForWorkers()
+		assert.Equal(t, int64(90), s.sprayed())
+		assert.Equal(t, int64(0), s.budget.remaining())
+	}
+}
+
+func TestBudgetRetirePaused(t *testing.T) {
+	s := testSpout(t)
+	s.MaxEvents = 6
+	s.seedLogs = []string{"a", "b", "c"}
+	s.TransactionID = []string{"id"}
+	s.MaxIntraTransLat = 50
+	s.StartWorkers([][]string{{"a"}, {"b"}, {"c"}}, [][]string{{""}, {""}, {""}})
+
+	// Both workers are paused in the middle of their transactions, which
+	// use up the budget, and one of them is retired then.
+	time.Sleep(10 * time.Millisecond)
+	assert.Nil(t, s.Pause())
+	time.Sleep(60 * time.Millisecond)
+	assert.Nil(t, s.SetConcurrency(1))
+	time.Sleep(10 * time.Millisecond)
+	assert.Nil(t, s.Resume())
+	s.WaitForWorkers()
+	assert.Equal(t, int64(6), s.sprayed())
+}
+
+func TestRetireWorker(t *testing.T) {
+	s := testSpout(t)
+	s.seedLogs = []string{"a", "b", "c"}
+	s.TransactionID = []string{"id"}
+	s.MaxIntraTransLat = 20
+	s.StartWorkers([][]string{{"a"}, {"b"}, {"c"}}, [][]string{{""}, {""}, {""}})
+	defer s.Shutdown()
+
+	s.mu.RLock()
+	w := s.workers[1]
+	s.mu.RUnlock()
+	time.Sleep(50 * time.Millisecond)
+	assert.Nil(t, s.SetConcurrency(1))
+	assert.Equal(t, 1, s.Status().Concurrency)
+	waitForWorkers(t, s, 1)
+	assert.Equal(t, int64(1), metrics.Workers())
+	assert.NotContains(t, metrics.Rates(), w.name)
+
+	// It finishes the transaction before it exits.
+	assert.True(t, w.generated() > 0)
+	assert.Equal(t, int64(0), w.generated()%3, w.generated())
+}
+
+func TestBuildConcurrency(t *testing.T) {
+	for _, n := range []int{0, -1} {
+		_, err := Build(&config.SpoutConfig{Concurrency: n})
+		assert.Equal(t, ErrBadConcurrency, errors.Cause(err))
+	}
+}
+
+func TestWorkerProfile(t *testing.T) {
+	s := testSpout(t)
+	s.Concurrency = 4
+	p, err := profile.New(profile.Config{Type: profile.TypeStep, Attrs: []byte(`{"levels": [{"factor": 0.5, "seconds": 1}, {"factor": 1.5, "seconds": 1}]}`)})
+	assert.Nil(t, err)
+	s.WorkerProfile = p
+	s.StartWorkers([][]string{{"hello world"}}, [][]string{{""}})
+	defer s.Shutdown()
+
+	st := s.Status()
+	assert.Equal(t, 2, st.Workers)
+	assert.Equal(t, 0.5, st.WorkerFactor)
+	assert.Equal(t, "step", st.WorkerProfile)
+
+	waitForWorkers(t, s, 6)
+	assert.Equal(t, 1.5, s.Status().WorkerFactor)
+
+	// The concurrency set through the console is scaled by the profile too.
+	assert.Nil(t, s.SetConcurrency(2))
+	waitForWorkers(t, s, 3)
+}
+
+func TestShutdownDrain(t *testing.T) {
+	for _, finish := range []bool{false, true} {
+		s := testSpout(t)
+		s.FinishTransactions = finish
+		s.seedLogs = []string{"a", "b", "c"}
+		s.TransactionID = []string{"id"}
+		s.MaxIntraTransLat = 20
+		s.StartWorkers([][]string{{"a"}, {"b"}, {"c"}}, [][]string{{""}, {""}, {""}})
+
+		s.mu.RLock()
+		workers := append([]*worker(nil), s.workers...)
+		s.mu.RUnlock()
+		time.Sleep(50 * time.Millisecond)
+		_, err := s.Shutdown()
+		assert.Nil(t, err)
+		assert.Equal(t, StateClosed, s.State())
+		assert.Equal(t, 0, s.Status().Workers)
+
+		if finish {
+			for _, w := range workers {
+				assert.Equal(t, int64(0), w.generated()%3, w.generated())
+			}
+		}
+	}
+}
+
+func TestShutdownTimeout(t *testing.T) {
+	s := testSpout(t)
+	s.FinishTransactions = true
+	s.DrainTimeout = 10 * time.Millisecond
+	// a transaction takes seconds
+	n := 50
+	s.seedLogs = make([]string, n)
+	matches, names := make([][]string, n), make([][]string, n)
+	for i := range s.seedLogs {
+		s.seedLogs[i], matches[i], names[i] = "a", []string{"a"}, []string{""}
+	}
+	s.TransactionID = []string{"id"}
+	s.MaxIntraTransLat = 100
+	s.StartWorkers(matches, names)
+
+	_, err := s.Shutdown()
+	assert.Equal(t, errShutdownTimeout, err)
+	// the workers are cut after the timeout
+	s.WaitForWorkers()
+}
+
+func TestDrainTimeout(t *testing.T) {
+	assert.Equal(t, defaultDrainTimeout, drainTimeout(0))
+	assert.Equal(t, time.Duration(0), drainTimeout(-1))
+	assert.Equal(t, 5*time.Second, drainTimeout(5))
+}
@@ -1,28 +1,225 @@
 package spout
 
+import (
+	"encoding/json"
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/jiwen624/logspout/config"
+	"github.com/jiwen624/logspout/replacer"
+)
+
+// The arrival models of the jitters. The delays drawn by all of them have a
+// mean of about the middle of the range, so the average rate is the same
+// whichever is chosen, only how bursty it is differs.
+const (
+	// JitterGaussian draws the delays from a Gaussian truncated to the range.
+	JitterGaussian = "gaussian"
+	// JitterUniform draws the delays uniformly from the range.
+	JitterUniform = "uniform"
+	// JitterPoisson makes the events a Poisson process, the delays are
+	// exponential and not bounded by the range.
+	JitterPoisson = "poisson"
+	// JitterExponential draws the delays from an exponential distribution
+	// shifted by the minimum, so that none of them is less than it.
+	JitterExponential = "exponential"
+	// JitterPareto draws the delays from a Pareto distribution, which is
+	// heavy-tailed: most of the delays are short and some are very long. The
+	// delays are capped at cap times the mean.
+	JitterPareto = "pareto"
+	// JitterSelfSimilar switches between ON periods when the events are
+	// generated and OFF periods when they are not, both of which are drawn
+	// from Pareto distributions. The traffic of many such sources is
+	// self-similar, i.e., it's bursty on all time scales.
+	JitterSelfSimilar = "selfsimilar"
+)
+
+// default attributes of the jitters
+const (
+	defaultParetoAlpha      = 1.5
+	defaultParetoCap        = 100
+	defaultSelfSimilarAlpha = 1.4
+	defaultOnOffPeriod      = 1000
+)
+
+var (
+	errUnknownJitter = errors.New("unknown jitter type")
+	errBadJitter     = errors.New("bad jitter")
+)
+
+// Jitter draws the delays between the events from an arrival model.
 type Jitter interface {
-	// AddJitter accepts a value and returns a new value with a jitter applied.
-	AddJitter(int) int
+	// AddJitter returns a delay drawn from the range [min, max].
+	AddJitter(min, max time.Duration) time.Duration
+}
+
+// jitterSpec is a parsed jitter config, which creates the jitters of the
+// workers as they are not concurrent-safe.
+type jitterSpec struct {
+	Type string `json:"-"`
+	// Alpha is the shape of the Pareto distributions, which must be greater
+	// than 1. The tail is heavier with a smaller alpha.
+	Alpha float64 `json:"alpha"`
+	// Cap caps the Pareto delays at cap times the mean.
+	Cap float64 `json:"cap"`
+	// On and Off are the mean ON and OFF periods in milliseconds of the
+	// self-similar jitter.
+	On  int `json:"on"`
+	Off int `json:"off"`
 }
 
-type interTransJitter struct{}
+// newJitterSpec parses the config, it's the Gaussian or the uniform jitter if
+// the config is nil.
+func newJitterSpec(c *config.Jitter, uniform bool) (*jitterSpec, error) {
+	if c == nil {
+		if uniform {
+			return &jitterSpec{Type: JitterUniform}, nil
+		}
+		return &jitterSpec{Type: JitterGaussian}, nil
+	}
+
+	j := &jitterSpec{Type: c.Type, On: defaultOnOffPeriod, Off: defaultOnOffPeriod}
+	switch c.Type {
+	case JitterGaussian, JitterUniform, JitterPoisson, JitterExponential:
+	case JitterPareto:
+		j.Alpha, j.Cap = defaultParetoAlpha, defaultParetoCap
+	case JitterSelfSimilar:
+		j.Alpha = defaultSelfSimilarAlpha
+	default:
+		return nil, errors.Wrap(errUnknownJitter, c.Type)
+	}
+	if len(c.Attrs) != 0 {
+		if err := json.Unmarshal(c.Attrs, j); err != nil {
+			return nil, errors.Wrap(err, "new jitter")
+		}
+	}
 
-func InterTransJitter() *interTransJitter {
-	return &interTransJitter{}
+	switch {
+	case (j.Type == JitterPareto || j.Type == JitterSelfSimilar) && j.Alpha <= 1:
+		return nil, errors.Wrap(errBadJitter, "alpha must be greater than 1")
+	case j.Type == JitterPareto && j.Cap < 1:
+		return nil, errors.Wrap(errBadJitter, "cap must be at least 1")
+	case j.Type == JitterSelfSimilar && (j.On <= 0 || j.Off < 0):
+		return nil, errors.Wrap(errBadJitter, "on must be positive and off non-negative")
+	}
+	return j, nil
 }
 
-func (j *interTransJitter) AddJitter(val int) int {
-	// TODO
-	return val
+// new creates a jitter with the seed, it's the Gaussian one if j is nil.
+func (j *jitterSpec) new(seed int64) Jitter {
+	r := rand.New(rand.NewSource(seed))
+	if j == nil {
+		return gaussianJitter{replacer.NewTruncatedGaussian(0.5, 0.2)}
+	}
+	switch j.Type {
+	case JitterUniform:
+		return uniformJitter{r}
+	case JitterPoisson:
+		return poissonJitter{r}
+	case JitterExponential:
+		return exponentialJitter{r}
+	case JitterPareto:
+		return paretoJitter{r: r, alpha: j.Alpha, cap: j.Cap}
+	case JitterSelfSimilar:
+		s := &selfSimilarJitter{
+			r:     r,
+			alpha: j.Alpha,
+			on:    time.Duration(j.On) * time.Millisecond,
+			off:   time.Duration(j.Off) * time.Millisecond,
+		}
+		// It starts in an ON period.
+		s.left = time.Duration(pareto(r, s.alpha, float64(s.on)))
+		return s
+	}
+	return gaussianJitter{replacer.NewTruncatedGaussian(0.5, 0.2)}
 }
 
-type intraTransJitter struct{}
+func mean(min, max time.Duration) time.Duration {
+	return min + (max-min)/2
+}
+
+// gaussianJitter draws the delays in milliseconds.
+type gaussianJitter struct {
+	g *replacer.TruncatedGaussian
+}
+
+func (j gaussianJitter) AddJitter(min, max time.Duration) time.Duration {
+	return min + time.Duration(j.g.Next(int((max-min)/time.Millisecond)))*time.Millisecond
+}
+
+type uniformJitter struct {
+	r *rand.Rand
+}
 
-func IntraTransJitter() *intraTransJitter {
-	return &intraTransJitter{}
+func (j uniformJitter) AddJitter(min, max time.Duration) time.Duration {
+	if max <= min {
+		return min
+	}
+	return min + time.Duration(j.r.Int63n(int64(max-min)))
 }
 
-func (j *intraTransJitter) AddJitter(val int) int {
-	// TODO
-	return val
+type poissonJitter struct {
+	r *rand.Rand
+}
+
+func (j poissonJitter) AddJitter(min, max time.Duration) time.Duration {
+	return time.Duration(j.r.ExpFloat64() * float64(mean(min, max)))
+}
+
+type exponentialJitter struct {
+	r *rand.Rand
+}
+
+func (j exponentialJitter) AddJitter(min, max time.Duration) time.Duration {
+	return min + time.Duration(j.r.ExpFloat64()*float64(max-min)/2)
+}
+
+type paretoJitter struct {
+	r     *rand.Rand
+	alpha float64
+	cap   float64
+}
+
+func (j paretoJitter) AddJitter(min, max time.Duration) time.Duration {
+	m := float64(mean(min, max))
+	return time.Duration(math.Min(pareto(j.r, j.alpha, m), j.cap*m))
+}
+
+// pareto draws a number from the Pareto distribution of the shape and the
+// mean.
+func pareto(r *rand.Rand, alpha, mean float64) float64 {
+	// the scale (the minimum value) of the distribution of the mean
+	xm := mean * (alpha - 1) / alpha
+	// 1-Float64() is in (0, 1]
+	return xm / math.Pow(1-r.Float64(), 1/alpha)
+}
+
+// selfSimilarJitter is an ON/OFF source. The events are generated as a Poisson
+// process in the ON periods, at the rate that the average rate including the
+// OFF periods is the one of the range.
+type selfSimilarJitter struct {
+	r       *rand.Rand
+	alpha   float64
+	on, off time.Duration
+	// the time left in the current ON period
+	left time.Duration
+}
+
+func (j *selfSimilarJitter) AddJitter(min, max time.Duration) time.Duration {
+	m := float64(mean(min, max)) * float64(j.on) / float64(j.on+j.off)
+	d := time.Duration(j.r.ExpFloat64() * m)
+
+	j.left -= d
+	if j.left > 0 {
+		return d
+	}
+	// The ON period ends, the next event is after the OFF period.
+	j.left = time.Duration(pareto(j.r, j.alpha, float64(j.on)))
+	if j.off > 0 {
+		d += time.Duration(pareto(j.r, j.alpha, float64(j.off)))
+	}
+	return d
 }
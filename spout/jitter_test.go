@@ -0,0 +1,80 @@
+package spout
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/jiwen624/logspout/config"
+)
+
+func TestJitters(t *testing.T) {
+	const (
+		n        = 100000
+		min, max = 2 * time.Millisecond, 8 * time.Millisecond
+	)
+	for _, typ := range []string{
+		JitterGaussian, JitterUniform, JitterPoisson, JitterExponential, JitterPareto, JitterSelfSimilar,
+	} {
+		spec, err := newJitterSpec(&config.Jitter{Type: typ}, false)
+		assert.Nil(t, err, typ)
+		j := spec.new(1)
+
+		var sum, longest time.Duration
+		shortest := time.Hour
+		for i := 0; i < n; i++ {
+			d := j.AddJitter(min, max)
+			sum += d
+			if d > longest {
+				longest = d
+			}
+			if d < shortest {
+				shortest = d
+			}
+		}
+		// The means are all about the middle of the range.
+		mean := float64(sum) / n / float64(time.Millisecond)
+		assert.InDelta(t, 5, mean, 1, typ)
+
+		switch typ {
+		case JitterGaussian, JitterUniform:
+			assert.True(t, shortest >= min && longest <= max, typ)
+		case JitterExponential:
+			assert.True(t, shortest >= min, typ)
+		case JitterPareto:
+			assert.True(t, longest > 20*5*time.Millisecond, typ)
+			assert.True(t, longest <= 100*5*time.Millisecond, typ)
+		case JitterSelfSimilar:
+			// the OFF periods of about a second
+			assert.True(t, longest > 500*time.Millisecond, typ)
+		}
+	}
+}
+
+func TestNewJitterSpec(t *testing.T) {
+	spec, err := newJitterSpec(nil, false)
+	assert.Nil(t, err)
+	assert.Equal(t, JitterGaussian, spec.Type)
+	spec, err = newJitterSpec(nil, true)
+	assert.Nil(t, err)
+	assert.Equal(t, JitterUniform, spec.Type)
+	assert.IsType(t, gaussianJitter{}, (*jitterSpec)(nil).new(1))
+
+	spec, err = newJitterSpec(&config.Jitter{Type: JitterPareto, Attrs: json.RawMessage(`{"alpha": 2.5}`)}, true)
+	assert.Nil(t, err)
+	assert.Equal(t, 2.5, spec.Alpha)
+	assert.Equal(t, float64(defaultParetoCap), spec.Cap)
+
+	_, err = newJitterSpec(&config.Jitter{Type: "gamma"}, false)
+	assert.Equal(t, errUnknownJitter, errors.Cause(err))
+	for typ, attrs := range map[string]string{
+		JitterPareto:      `{"alpha": 1}`,
+		JitterSelfSimilar: `{"on": 0}`,
+	} {
+		_, err = newJitterSpec(&config.Jitter{Type: typ, Attrs: json.RawMessage(attrs)}, false)
+		assert.Equal(t, errBadJitter, errors.Cause(err), typ)
+	}
+}
@@ -0,0 +1,68 @@
+package spout
+
+import (
+	"time"
+
+	"github.com/jiwen624/logspout/metrics"
+)
+
+// schedule is the intended times of the events of a worker paced by the think
+// times. Each event is intended at the time of the previous one plus the think
+// time, rather than the think time after the previous one is written, so the
+// time taken to generate and write the events doesn't lower the rate.
+type schedule struct {
+	// the intended time of the next event
+	next time.Time
+	// how far the events may fall behind their schedule and still be caught
+	// up with
+	maxLag time.Duration
+	// paced is set if the next event is paced by a think time
+	paced bool
+}
+
+func newSchedule(maxLag time.Duration) *schedule {
+	return &schedule{next: time.Now(), maxLag: maxLag}
+}
+
+// reset reschedules the next event at now, e.g., after the generation is
+// resumed.
+func (s *schedule) reset() {
+	s.next, s.paced = time.Now(), false
+}
+
+// intended returns the intended time of the next event, it's zero if the event
+// is not paced by a think time.
+func (s *schedule) intended() time.Time {
+	if s == nil || !s.paced {
+		return time.Time{}
+	}
+	return s.next
+}
+
+// sleep moves the schedule by the think time and sleeps until the next event
+// is intended, if it's ahead.
+func (s *schedule) sleep(d time.Duration) {
+	s.paced = true
+	s.next = s.next.Add(d)
+	now := time.Now()
+	if lag := now.Sub(s.next); lag > s.maxLag {
+		metrics.AddSkipped(lag - s.maxLag)
+		s.next = now.Add(-s.maxLag)
+	}
+	if d := s.next.Sub(now); d >= minSleep {
+		time.Sleep(d)
+	}
+}
+
+// maxLag returns how far the events may fall behind their schedule by the
+// config in milliseconds, where 0 means the default and a negative one never
+// catching up.
+func maxLag(ms int) time.Duration {
+	switch {
+	case ms == 0:
+		return defaultMaxLag
+	case ms < 0:
+		return 0
+	}
+	return time.Duration(ms) * time.Millisecond
+}
@@ -0,0 +1,46 @@
+package spout
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/jiwen624/logspout/metrics"
+)
+
+func TestSchedule(t *testing.T) {
+	s := newSchedule(defaultMaxLag)
+	assert.True(t, s.intended().IsZero())
+	var nilSchedule *schedule
+	assert.True(t, nilSchedule.intended().IsZero())
+
+	// The time taken by the events is compensated.
+	start := time.Now()
+	for i := 0; i < 10; i++ {
+		time.Sleep(5 * time.Millisecond)
+		s.sleep(10 * time.Millisecond)
+	}
+	elapsed := time.Since(start)
+	assert.True(t, elapsed >= 100*time.Millisecond, elapsed.String())
+	assert.True(t, elapsed < 140*time.Millisecond, elapsed.String())
+	assert.Equal(t, start.Add(100*time.Millisecond).Round(10*time.Millisecond),
+		s.intended().Round(10*time.Millisecond))
+
+	// The lag beyond maxLag is given up.
+	skipped := metrics.Pacing().Skipped
+	s = newSchedule(100 * time.Millisecond)
+	s.next = time.Now().Add(-time.Second)
+	s.sleep(0)
+	assert.InDelta(t, skipped+0.9, metrics.Pacing().Skipped, 0.01)
+	assert.InDelta(t, 100*time.Millisecond, time.Since(s.intended()), float64(10*time.Millisecond))
+
+	s.reset()
+	assert.True(t, s.intended().IsZero())
+}
+
+func TestMaxLag(t *testing.T) {
+	assert.Equal(t, defaultMaxLag, maxLag(0))
+	assert.Equal(t, time.Duration(0), maxLag(-1))
+	assert.Equal(t, 5*time.Second, maxLag(5000))
+}
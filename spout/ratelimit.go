@@ -0,0 +1,187 @@
+package spout
+
+import (
+	"sync"
+	"time"
+
+	"github.com/jiwen624/logspout/metrics"
+)
+
+const (
+	// defaultMaxLag is how far the events may fall behind their schedule by
+	// default, e.g., when an output is slow, the events behind more than it are
+	// not made up for.
+	defaultMaxLag = time.Second
+	// minSleep is the shortest wait, a worker goes ahead of its schedule by
+	// less than it rather than sleeping, which is too coarse to pace the events
+	// one by one at a high rate.
+	minSleep = 100 * time.Microsecond
+	// idleCheck is how often a worker checks the rate when it's scaled to 0.
+	idleCheck = 100 * time.Millisecond
+)
+
+// rateLimiter paces the events of all the workers at a rate in either events
+// or bytes per second. The events are scheduled at the absolute times from when
+// the rate is set rather than with the sleeps between them, so the time taken
+// to generate and write the events is compensated, and the rate is precise
+// even if an event takes less time than a sleep can be.
+//
+// In bytes per second, an event is scheduled at the time the bytes written
+// before it take at the rate, so the sizes of the events, which may vary a lot
+// with the replacers, are measured rather than estimated.
+//
+// The rate is scaled by a factor, which is changed over time by a load profile.
+type rateLimiter struct {
+	mu       sync.Mutex
+	eps, bps float64
+	scale    float64
+	// maxLag is how far the events may fall behind their schedule and still
+	// be caught up with
+	maxLag time.Duration
+	// the events are scheduled at start + n/rate, where n is the number of
+	// events, or bytes written
+	start time.Time
+	n     float64
+}
+
+func newRateLimiter() *rateLimiter {
+	return &rateLimiter{scale: 1, maxLag: defaultMaxLag}
+}
+
+// set sets the rate in events or bytes per second, at most one of them may be
+// non-zero. The rate is unlimited if both of them are 0.
+func (l *rateLimiter) set(eps, bps float64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.eps, l.bps = eps, bps
+	l.start, l.n = time.Now(), 0
+}
+
+// scaleTo scales the rate by the factor. Unlike set, the events ahead of their
+// schedule are not let go at once, so that it can be changed often.
+func (l *rateLimiter) scaleTo(factor float64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	next := now
+	old := l.rate()
+	if old > 0 {
+		next = l.start.Add(time.Duration(l.n * float64(time.Second) / old))
+	}
+	l.scale = factor
+	// The units (events or bytes) reserved ahead are rescheduled at the new
+	// rate, the ones behind are left behind.
+	if ahead := next.Sub(now); ahead > 0 {
+		if rate := l.rate(); rate > 0 {
+			next = now.Add(time.Duration(ahead.Seconds() * old / rate * float64(time.Second)))
+		}
+	}
+	l.start, l.n = next, 0
+}
+
+// rate returns the scaled rate. The caller must hold the lock.
+func (l *rateLimiter) rate() float64 {
+	if l.bps > 0 {
+		return l.bps * l.scale
+	}
+	return l.eps * l.scale
+}
+
+// reset reschedules the events from now, e.g., after the generation is resumed
+// so that the events not generated while it's paused are not made up for.
+func (l *rateLimiter) reset() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.start, l.n = time.Now(), 0
+}
+
+// limited tells if the rate is limited.
+func (l *rateLimiter) limited() bool {
+	if l == nil {
+		return false
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.eps > 0 || l.bps > 0
+}
+
+// setMaxLag sets how far the events may fall behind their schedule and still
+// be caught up with, 0 means never catching up.
+func (l *rateLimiter) setMaxLag(d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.maxLag = d
+}
+
+// reserve takes the next slot of the schedule, it's zero if the rate is not
+// limited. No slot is taken if the rate is scaled to 0, then it returns false.
+func (l *rateLimiter) reserve() (time.Time, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.eps <= 0 && l.bps <= 0 {
+		return time.Time{}, true
+	}
+	rate := l.rate()
+	if rate <= 0 {
+		return time.Time{}, false
+	}
+	now := time.Now()
+	slot := l.start.Add(time.Duration(l.n * float64(time.Second) / rate))
+	if lag := now.Sub(slot); lag > l.maxLag {
+		metrics.AddSkipped(lag - l.maxLag)
+		l.start, l.n = now.Add(-l.maxLag), 0
+		slot = l.start
+	}
+	// The bytes are counted when the event is written.
+	if l.bps <= 0 {
+		l.n++
+	}
+	return slot, true
+}
+
+// written counts the size of an event written, which pushes back the events
+// after it if the rate is in bytes per second.
+func (l *rateLimiter) written(size int) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.bps > 0 {
+		l.n += float64(size)
+	}
+}
+
+// wait blocks until the next slot of the schedule and returns it, which is
+// zero if the rate is not limited. It returns false if any of the channels is
+// closed before that. It never blocks on a nil limiter.
+func (l *rateLimiter) wait(closeChan, quit <-chan struct{}) (time.Time, bool) {
+	if l == nil {
+		return time.Time{}, true
+	}
+	for {
+		slot, ok := l.reserve()
+		d := idleCheck
+		if ok {
+			if d = time.Until(slot); d < minSleep {
+				return slot, true
+			}
+		}
+
+		timer := time.NewTimer(d)
+		select {
+		case <-timer.C:
+			if ok {
+				return slot, true
+			}
+		case <-closeChan:
+			timer.Stop()
+			return time.Time{}, false
+		case <-quit:
+			timer.Stop()
+			return time.Time{}, false
+		}
+	}
+}
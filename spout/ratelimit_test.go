@@ -0,0 +1,148 @@
+package spout
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/jiwen624/logspout/config"
+	"github.com/jiwen624/logspout/metrics"
+	"github.com/jiwen624/logspout/profile"
+)
+
+func TestRateLimiter(t *testing.T) {
+	var nilLimiter *rateLimiter
+	assert.False(t, nilLimiter.limited())
+	slot, ok := nilLimiter.wait(nil, nil)
+	assert.True(t, ok)
+	assert.True(t, slot.IsZero())
+
+	l := newRateLimiter()
+	assert.False(t, l.limited())
+	slot, ok = l.wait(nil, nil)
+	assert.True(t, ok)
+	assert.True(t, slot.IsZero())
+
+	// The events are paced at the rate however long each of them takes.
+	l.set(5000, 0)
+	assert.True(t, l.limited())
+	start := time.Now()
+	for i := 0; i < 1000; i++ {
+		_, ok = l.wait(nil, nil)
+		assert.True(t, ok)
+	}
+	elapsed := time.Since(start)
+	assert.True(t, elapsed > 180*time.Millisecond, elapsed.String())
+	assert.True(t, elapsed < 400*time.Millisecond, elapsed.String())
+
+	// The events behind their schedule by more than maxLag are not made up for.
+	skipped := metrics.Pacing().Skipped
+	l.mu.Lock()
+	l.start = time.Now().Add(-time.Hour)
+	l.mu.Unlock()
+	for i := 0; i < 6000; i++ {
+		l.reserve()
+	}
+	slot, ok = l.reserve()
+	assert.True(t, ok)
+	assert.True(t, time.Until(slot) > 100*time.Millisecond, time.Until(slot).String())
+	assert.InDelta(t, skipped+3599, metrics.Pacing().Skipped, 1)
+
+	// Never catching up, the schedule is moved forward as soon as it's behind.
+	l.setMaxLag(0)
+	l.mu.Lock()
+	l.start = time.Now().Add(-time.Hour)
+	l.mu.Unlock()
+	slot, _ = l.reserve()
+	assert.True(t, time.Since(slot) < time.Millisecond)
+	l.setMaxLag(defaultMaxLag)
+
+	// In bytes per second, the events are pushed back by the sizes written.
+	l.set(0, 1e6)
+	assert.True(t, l.limited())
+	start = time.Now()
+	for i := 0; i < 100; i++ {
+		_, ok = l.wait(nil, nil)
+		assert.True(t, ok)
+		l.written(2000)
+	}
+	elapsed = time.Since(start)
+	assert.True(t, elapsed > 180*time.Millisecond, elapsed.String())
+	assert.True(t, elapsed < 400*time.Millisecond, elapsed.String())
+
+	// The rate is scaled to 0, then back.
+	l.set(1000, 0)
+	l.scaleTo(0)
+	slot, ok = l.reserve()
+	assert.False(t, ok)
+	assert.True(t, slot.IsZero())
+	l.scaleTo(0.5)
+	start = time.Now()
+	for i := 0; i < 100; i++ {
+		_, ok = l.wait(nil, nil)
+		assert.True(t, ok)
+	}
+	elapsed = time.Since(start)
+	assert.True(t, elapsed > 180*time.Millisecond, elapsed.String())
+	assert.True(t, elapsed < 400*time.Millisecond, elapsed.String())
+	l.scaleTo(1)
+
+	// The waits are cancelled.
+	l.set(1, 0)
+	l.reserve()
+	quit := make(chan struct{})
+	close(quit)
+	_, ok = l.wait(nil, quit)
+	assert.False(t, ok)
+	_, ok = l.wait(quit, nil)
+	assert.False(t, ok)
+}
+
+func TestBuildRate(t *testing.T) {
+	s := NewDefault()
+	assert.Nil(t, s.buildRate(&config.SpoutConfig{GBPerDay: 86.4}))
+	assert.Equal(t, 1e6, s.BytesPerSecond)
+	assert.Equal(t, 0.0, s.EPS)
+	assert.True(t, s.limiter.limited())
+
+	assert.Nil(t, s.buildRate(&config.SpoutConfig{EPS: 100}))
+	assert.Equal(t, 100.0, s.EPS)
+	assert.Equal(t, 0.0, s.BytesPerSecond)
+
+	for _, c := range []config.SpoutConfig{
+		{EPS: 1, BytesPerSecond: 1},
+		{EPS: 1, GBPerDay: 1},
+		{BytesPerSecond: 1, GBPerDay: 1},
+	} {
+		assert.Equal(t, errRateConflict, s.buildRate(&c))
+	}
+	assert.Equal(t, ErrBadRate, s.buildRate(&config.SpoutConfig{GBPerDay: -1}))
+
+	ramp := &profile.Config{Type: profile.TypeRamp, Attrs: []byte(`{"from": 0, "to": 1, "seconds": 1}`)}
+	assert.Equal(t, errNoRateToShape, s.buildRate(&config.SpoutConfig{LoadProfile: ramp}))
+	assert.Nil(t, s.buildRate(&config.SpoutConfig{EPS: 1000, LoadProfile: ramp}))
+	assert.Equal(t, profile.TypeRamp, s.LoadProfile.String())
+}
+
+func TestLoadProfile(t *testing.T) {
+	s := testSpout(t)
+	s.EPS = 1000
+	s.limiter.set(s.EPS, 0)
+	p, err := profile.New(profile.Config{Type: profile.TypeStep, Attrs: []byte(`{"levels": [{"factor": 0, "seconds": 1}, {"factor": 2, "seconds": 1}]}`)})
+	assert.Nil(t, err)
+	s.LoadProfile = p
+	s.StartWorkers([][]string{{"hello world"}}, [][]string{{""}})
+	defer s.Shutdown()
+
+	// no events in the first second
+	time.Sleep(500 * time.Millisecond)
+	assert.Equal(t, int64(0), s.sprayed())
+	assert.Equal(t, 0.0, s.Status().LoadFactor)
+	assert.Equal(t, "step", s.Status().LoadProfile)
+
+	time.Sleep(time.Second)
+	assert.Equal(t, 2.0, s.Status().LoadFactor)
+	assert.Equal(t, 2000.0, s.Status().ConfiguredRate)
+	assert.True(t, s.sprayed() > 500, s.sprayed())
+}
@@ -0,0 +1,124 @@
+package spout
+
+import (
+	"github.com/pkg/errors"
+
+	"github.com/jiwen624/logspout/config"
+	"github.com/jiwen624/logspout/log"
+	"github.com/jiwen624/logspout/replacer"
+)
+
+var errNoConfigPath = errors.New("no configuration file to reload")
+
+// ReloadFile reloads the configuration from ConfigPath, see Reload.
+func (s *Spout) ReloadFile() error {
+	if s.ConfigPath == "" {
+		return errNoConfigPath
+	}
+	cfg, err := config.FromFile(s.ConfigPath)
+	if err != nil {
+		return errors.Wrap(err, "reload")
+	}
+	return s.Reload(cfg)
+}
+
+// Reload applies the configuration to the running spout: the replacers, the
+// sample file, the patterns, the outputs, the rate, the intervals and the
+// concurrency. It's checked as a whole before any change is made, so the
+// current configuration is kept if any of them is invalid, and the counters
+// and the metrics are kept. The other settings, e.g., the transaction IDs and
+// the duration, take effect after a restart.
+//
+// The workers swap in the new replacers and seed logs at the start of their
+// next transactions. The new and changed outputs are opened before anything is
+// changed, and the unchanged ones are kept open, see output.Registry.Prepare.
+func (s *Spout) Reload(cfg *config.SpoutConfig) error {
+	if s.closed() {
+		return ErrStopped
+	}
+
+	rm, err := replacer.Build(cfg.Replacement)
+	if err != nil {
+		return errors.Wrap(err, "reload replacers")
+	}
+	r := replacer.Replacers(rm)
+	seedLogs, err := readSeedLogs(cfg.SampleFilePath)
+	if err != nil {
+		return errors.Wrap(err, "reload")
+	}
+	patterns, err := compilePatterns(cfg.Pattern)
+	if err != nil {
+		return errors.Wrap(err, "reload")
+	}
+	matches, names, err := generateTokens(patterns, seedLogs)
+	if err != nil {
+		return errors.Wrap(err, "reload")
+	}
+	st, err := s.reloadSettings(cfg)
+	if err != nil {
+		return errors.Wrap(err, "reload")
+	}
+	// The new outputs are opened before anything is changed.
+	rc, err := s.Output.Prepare(cfg.Output)
+	if err != nil {
+		return errors.Wrap(err, "reload outputs")
+	}
+
+	if s.closed() {
+		rc.Abort()
+		return ErrStopped
+	}
+	// Nothing can fail from now on. The outputs replaced are removed anyway if
+	// they fail to be closed.
+	if err := rc.Commit(); err != nil {
+		log.Warn(errors.Wrap(err, "reload outputs"))
+	}
+
+	s.mu.Lock()
+	s.conf = cfg
+	s.Replacers = r
+	s.kinds.Store(replacerKinds(r))
+	s.SampleFilePath, s.Patterns, s.seedLogs = cfg.SampleFilePath, patterns, seedLogs
+	s.matches, s.names = matches, names
+	s.growCounts(len(seedLogs))
+	for _, w := range s.workers {
+		w.reload(&reloadSet{replacers: r.Copy(), seedLogs: seedLogs, matches: matches, names: names})
+	}
+	s.mu.Unlock()
+
+	// The settings are left alone after the generation is stopped.
+	if err := s.Apply(st); err != nil && err != ErrStopped {
+		return errors.Wrap(err, "reload")
+	}
+	log.Info("The configuration is reloaded.")
+	return nil
+}
+
+// reloadSettings returns the settings of the config which differ from the
+// current ones.
+func (s *Spout) reloadSettings(cfg *config.SpoutConfig) (Settings, error) {
+	var st Settings
+	eps, bps, err := rateFromConfig(cfg)
+	if err != nil {
+		return st, err
+	}
+	if cfg.Concurrency <= 0 {
+		return st, ErrBadConcurrency
+	}
+	if err := checkIntervals(cfg.MinInterval, cfg.MaxInterval); err != nil {
+		return st, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if eps != s.EPS || bps != s.BytesPerSecond {
+		st.EPS, st.BytesPerSecond = &eps, &bps
+	}
+	if cfg.MinInterval != s.MinInterval || cfg.MaxInterval != s.MaxInterval {
+		st.MinInterval, st.MaxInterval = &cfg.MinInterval, &cfg.MaxInterval
+	}
+	if cfg.Concurrency != s.Concurrency {
+		st.Concurrency = &cfg.Concurrency
+	}
+	return st, nil
+}
@@ -0,0 +1,171 @@
+package spout
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/jiwen624/logspout/config"
+	"github.com/jiwen624/logspout/output"
+)
+
+// writeFile writes the file in the directory and returns its path.
+func writeFile(t *testing.T, dir, name, content string) string {
+	path := filepath.Join(dir, name)
+	assert.Nil(t, ioutil.WriteFile(path, []byte(content), 0644))
+	return path
+}
+
+// reloadConfig returns the config of the sample file overridden by s.
+func reloadConfig(t *testing.T, sample, s string) *config.SpoutConfig {
+	c := config.SpoutConfig{
+		Concurrency:    2,
+		MinInterval:    1,
+		MaxInterval:    2,
+		SampleFilePath: sample,
+		Pattern:        []string{`^(\w+ )(?<user>\w+)(\n)$`},
+		Replacement:    json.RawMessage(`{}`),
+	}
+	assert.Nil(t, json.Unmarshal([]byte(`{"output": {"discard": {"type": "discard"}}}`), &c))
+	assert.Nil(t, json.Unmarshal([]byte(s), &c))
+	return &c
+}
+
+func TestReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "reload")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	sample := writeFile(t, dir, "sample.log", "hi bob\n")
+
+	s := testSpout(t)
+	s.StartWorkers([][]string{{"hello ", "bob"}}, [][]string{{"", "user"}})
+	defer s.Shutdown()
+
+	sub, err := newTailSub(url.Values{tailFormatParam: {output.FormatRaw}})
+	assert.Nil(t, err)
+	s.tail.add(sub)
+	defer s.tail.remove(sub)
+	assert.Equal(t, "hello bob", string(<-sub.msgs))
+
+	good := `{
+		"concurrency": 3, "minInterval": 1, "maxInterval": 2, "eps": 1000,
+		"replacement": {"user": {"type": "fixedList", "attrs": {"method": "random", "list": ["alice"]}}}
+	}`
+	for _, bad := range []string{
+		`{"eps": -1}`,
+		`{"concurrency": 0}`,
+		`{"minInterval": 2, "maxInterval": 1}`,
+		`{"replacement": {"user": {"type": "fixedList", "attrs": {"method": "random"}}}}`,
+		`{"output": {"a": {"type": "discard"}, "b": {"type": "discard"}}}`,
+		`{"sampleFile": "nonexist.log"}`,
+		`{"pattern": ["^(hi )(?<user>\\w+"]}`,
+		`{"pattern": ["^(hello )(?<user>\\w+)(\\n)$"]}`,
+		`{"pattern": ["a", "b"]}`,
+	} {
+		assert.NotNil(t, s.Reload(reloadConfig(t, sample, bad)), bad)
+	}
+	assert.Equal(t, 2, s.Status().Concurrency)
+	assert.Equal(t, 0.0, s.Status().EPS)
+
+	events := s.sprayed()
+	assert.Nil(t, s.Reload(reloadConfig(t, sample, good)))
+	st := s.Status()
+	assert.Equal(t, 3, st.Concurrency)
+	assert.Equal(t, 1000.0, st.EPS)
+	assert.True(t, st.Events >= events)
+
+	// the workers swap in the new replacers and seed logs
+	deadline := time.After(5 * time.Second)
+	for {
+		select {
+		case msg := <-sub.msgs:
+			if string(msg) == "hi alice\n" {
+				return
+			}
+		case <-deadline:
+			t.Fatal("the replacers are not reloaded")
+		}
+	}
+}
+
+func TestReloadFile(t *testing.T) {
+	s := testSpout(t)
+	assert.Equal(t, errNoConfigPath, s.ReloadFile())
+
+	dir, err := ioutil.TempDir("", "reload")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	sample := writeFile(t, dir, "sample.log", "hi bob\n")
+	b, err := json.Marshal(reloadConfig(t, sample, `{"maxInterval": 5}`))
+	assert.Nil(t, err)
+
+	s.ConfigPath = writeFile(t, dir, "logspout.json", string(b))
+	assert.Nil(t, s.ReloadFile())
+	assert.Equal(t, 5, s.MaxInterval)
+
+	s.ConfigPath = filepath.Join(dir, "nonexist.json")
+	assert.NotNil(t, s.ReloadFile())
+}
+
+func TestReloadOutputFailure(t *testing.T) {
+	dir, err := ioutil.TempDir("", "reload")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	sample := writeFile(t, dir, "sample.log", "hi bob\n")
+
+	s := testSpout(t)
+	s.StartWorkers([][]string{{"hello ", "bob"}}, [][]string{{"", "user"}})
+	defer s.Shutdown()
+	sub, err := newTailSub(url.Values{tailFormatParam: {output.FormatRaw}})
+	assert.Nil(t, err)
+	s.tail.add(sub)
+	defer s.tail.remove(sub)
+
+	conf := func(user, outputs string) *config.SpoutConfig {
+		return reloadConfig(t, sample, `{
+			"replacement": {"user": {"type": "fixedList", "attrs": {"method": "next", "list": ["`+user+`"]}}},
+			"output": {"file": {"type": "file", "attrs": {"fileName": "a.log", "directory": "`+dir+`"}}`+outputs+`}
+		}`)
+	}
+	assert.Nil(t, s.Reload(conf("alice", "")))
+	waitForEvent(t, sub.msgs, "hi alice\n")
+
+	// the pcap output can't be opened in a directory which doesn't exist
+	bad := `, "pcap": {"type": "pcap", "attrs": {"directory": "` + dir + `/nonexist"}}`
+	assert.NotNil(t, s.Reload(conf("carol", bad)))
+	for i := 0; i < 20; i++ {
+		assert.Equal(t, "hi alice\n", string(<-sub.msgs))
+	}
+
+	var descs []string
+	for _, info := range s.Output.List() {
+		assert.True(t, info.Active, info.Desc)
+		descs = append(descs, info.Desc)
+	}
+	assert.Len(t, descs, 2)
+	for _, d := range descs {
+		assert.NotContains(t, d, "Pcap")
+	}
+
+	// the old output is still written to
+	fi, err := os.Stat(filepath.Join(dir, "a.log"))
+	assert.Nil(t, err)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		cur, err := os.Stat(filepath.Join(dir, "a.log"))
+		assert.Nil(t, err)
+		if cur.Size() > fi.Size() {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the file output is not written to")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
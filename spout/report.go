@@ -0,0 +1,273 @@
+package spout
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/jiwen624/logspout/config"
+	"github.com/jiwen624/logspout/metrics"
+)
+
+// Report is the summary of a run.
+type Report struct {
+	Version   string    `json:"version"`
+	Commit    string    `json:"commit"`
+	StartTime time.Time `json:"startTime"`
+	EndTime   time.Time `json:"endTime"`
+	// Seconds is the duration of the run in seconds
+	Seconds float64 `json:"seconds"`
+	// ConfigHash is the SHA-256 of the configuration the spout is built from,
+	// which tells if two runs are configured the same.
+	ConfigHash string `json:"configHash"`
+	// Events is the number of events generated
+	Events int64 `json:"events"`
+	// Bytes is the total size of the events generated
+	Bytes int64 `json:"bytes"`
+	// Workers are the numbers of events generated by each worker
+	Workers map[string]int64 `json:"workers"`
+	// Seeds are the numbers of events generated from each seed log, in the
+	// order of the seed logs in the sample file. The seed logs reloaded are
+	// counted at the same indices.
+	Seeds []int64 `json:"seeds"`
+	// Rate is the average number of events generated per second
+	Rate float64 `json:"rate"`
+	// TargetRate is the rate in events per second the workers are configured
+	// to generate at, 0 means unlimited.
+	TargetRate float64 `json:"targetRate"`
+	// ByteRate is the average size of the events generated per second
+	ByteRate float64 `json:"byteRate"`
+	// TargetByteRate is the rate in bytes per second the workers are paced
+	// at, 0 means they are not paced in bytes.
+	TargetByteRate float64 `json:"targetByteRate"`
+	// Rates are the percentiles of the numbers of events generated in each
+	// second of the run
+	Rates RatePercentiles `json:"rates"`
+	// Pacing tells how the events kept up with their schedule, and how long
+	// the writes took.
+	Pacing metrics.PacingSnapshot `json:"pacing"`
+	// Outputs are the metrics of the outputs keyed by their descriptions
+	Outputs map[string]metrics.OutputSnapshot `json:"outputs"`
+}
+
+// RatePercentiles are the percentiles of the per-second rates.
+type RatePercentiles struct {
+	// Samples is the number of seconds sampled
+	Samples int     `json:"samples"`
+	Min     float64 `json:"min"`
+	P50     float64 `json:"p50"`
+	P90     float64 `json:"p90"`
+	P99     float64 `json:"p99"`
+	Max     float64 `json:"max"`
+}
+
+// Report returns the report of the run so far.
+func (s *Spout) Report() *Report {
+	s.mu.RLock()
+	start, end := s.startTime, s.endTime
+	conf := s.conf
+	bps := s.BytesPerSecond
+	s.mu.RUnlock()
+	counts := s.counts()
+	seeds := make([]int64, len(counts))
+	var bytes int64
+	for i, c := range counts {
+		seeds[i] = atomic.LoadInt64(&c.events)
+		bytes += atomic.LoadInt64(&c.bytes)
+	}
+
+	if end.IsZero() {
+		end = time.Now()
+	}
+	r := &Report{
+		Version:        Version(),
+		Commit:         Commit(),
+		StartTime:      start,
+		EndTime:        end,
+		ConfigHash:     configHash(conf),
+		Events:         metrics.TotalEvents(),
+		Bytes:          bytes,
+		Workers:        metrics.WorkerEvents(),
+		Seeds:          seeds,
+		TargetRate:     metrics.ConfiguredRate(),
+		TargetByteRate: bps,
+		Rates:          s.rates.percentiles(),
+		Pacing:         metrics.Pacing(),
+		Outputs:        metrics.Outputs(),
+	}
+	if !start.IsZero() {
+		r.Seconds = end.Sub(start).Seconds()
+	}
+	if r.Seconds > 0 {
+		r.Rate = float64(r.Events) / r.Seconds
+		r.ByteRate = float64(r.Bytes) / r.Seconds
+	}
+	return r
+}
+
+// configHash returns the SHA-256 of the configuration in JSON, it's empty if
+// there is no configuration.
+func configHash(c *config.SpoutConfig) string {
+	if c == nil {
+		return ""
+	}
+	b, err := json.Marshal(c)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// WriteFile writes the report to the file in JSON.
+func (r *Report) WriteFile(path string) error {
+	b, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "write report")
+	}
+	return errors.Wrap(ioutil.WriteFile(path, append(b, '\n'), 0644), "write report")
+}
+
+// WriteText writes the report in a human-readable form.
+func (r *Report) WriteText(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+
+	target := "unlimited"
+	if r.TargetRate > 0 {
+		target = fmt.Sprintf("%.1f/s", r.TargetRate)
+	}
+	version := r.Version
+	if r.Commit != "" {
+		version += "+" + r.Commit
+	}
+	fmt.Fprintln(tw, "Logspout report")
+	fmt.Fprintf(tw, "  Version\t%s\n", version)
+	fmt.Fprintf(tw, "  Time\t%s - %s (%.1fs)\n",
+		r.StartTime.Format(time.RFC3339), r.EndTime.Format(time.RFC3339), r.Seconds)
+	fmt.Fprintf(tw, "  Config hash\t%s\n", r.ConfigHash)
+	fmt.Fprintf(tw, "  Events\t%d\n", r.Events)
+	fmt.Fprintf(tw, "  Rate\t%.1f/s achieved, %s target\n", r.Rate, target)
+	fmt.Fprintf(tw, "  Bytes\t%d, %.1f/s achieved", r.Bytes, r.ByteRate)
+	if r.TargetByteRate > 0 {
+		fmt.Fprintf(tw, ", %.1f/s target (%.2f GB/day)", r.TargetByteRate, r.TargetByteRate*secondsPerDay/bytesPerGB)
+	}
+	fmt.Fprintln(tw)
+	fmt.Fprintf(tw, "  Rates per second\tmin %.0f, p50 %.0f, p90 %.0f, p99 %.0f, max %.0f (%d seconds)\n",
+		r.Rates.Min, r.Rates.P50, r.Rates.P90, r.Rates.P99, r.Rates.Max, r.Rates.Samples)
+
+	ms := func(s float64) string { return fmt.Sprintf("%.3fms", s*1000) }
+	if l := r.Pacing.Lateness; l.Count > 0 {
+		fmt.Fprintf(tw, "  Lateness\tp50 %s, p90 %s, p99 %s (%d events), %.1fs skipped\n",
+			ms(l.P50), ms(l.P90), ms(l.P99), l.Count, r.Pacing.Skipped)
+	}
+	if w := r.Pacing.Writes; w.Count > 0 {
+		fmt.Fprintf(tw, "  Writes\tp50 %s, p90 %s, p99 %s\n", ms(w.P50), ms(w.P90), ms(w.P99))
+	}
+
+	workers := make([]string, 0, len(r.Workers))
+	for wk := range r.Workers {
+		workers = append(workers, wk)
+	}
+	sort.Slice(workers, func(i, j int) bool {
+		if len(workers[i]) != len(workers[j]) {
+			return len(workers[i]) < len(workers[j])
+		}
+		return workers[i] < workers[j]
+	})
+	for i, wk := range workers {
+		workers[i] = fmt.Sprintf("%s %d", wk, r.Workers[wk])
+	}
+	fmt.Fprintf(tw, "  Workers\t%s\n", strings.Join(workers, ", "))
+
+	seeds := make([]string, len(r.Seeds))
+	for i, n := range r.Seeds {
+		seeds[i] = fmt.Sprintf("#%d %d", i, n)
+	}
+	fmt.Fprintf(tw, "  Seeds\t%s\n", strings.Join(seeds, ", "))
+
+	names := make([]string, 0, len(r.Outputs))
+	for name := range r.Outputs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		o := r.Outputs[name]
+		fmt.Fprintf(tw, "  Output %s\tevents %d, bytes %d, errors %d, retries %d, drops %d\n",
+			name, o.Events, o.Bytes, o.Errors, o.Retries, o.Drops)
+	}
+	return tw.Flush()
+}
+
+// rateSampler samples the number of events generated in each second.
+type rateSampler struct {
+	mu      sync.Mutex
+	samples []int64
+}
+
+// run samples the rates of the counter until the workers exit or the spout is
+// closed.
+func (r *rateSampler) run(count func() int64, idle, closed <-chan struct{}) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	last := count()
+	for {
+		select {
+		case <-idle:
+			return
+		case <-closed:
+			return
+		case <-ticker.C:
+			n := count()
+			r.add(n - last)
+			last = n
+		}
+	}
+}
+
+func (r *rateSampler) add(n int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.samples = append(r.samples, n)
+}
+
+// percentiles returns the percentiles of the rates sampled.
+func (r *rateSampler) percentiles() RatePercentiles {
+	r.mu.Lock()
+	sorted := append([]int64(nil), r.samples...)
+	r.mu.Unlock()
+
+	if len(sorted) == 0 {
+		return RatePercentiles{}
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	// the nearest-rank method
+	rank := func(q float64) float64 {
+		i := int(math.Ceil(q*float64(len(sorted)))) - 1
+		if i < 0 {
+			i = 0
+		}
+		return float64(sorted[i])
+	}
+	return RatePercentiles{
+		Samples: len(sorted),
+		Min:     float64(sorted[0]),
+		P50:     rank(0.5),
+		P90:     rank(0.9),
+		P99:     rank(0.99),
+		Max:     float64(sorted[len(sorted)-1]),
+	}
+}
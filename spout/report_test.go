@@ -0,0 +1,75 @@
+package spout
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/jiwen624/logspout/config"
+	"github.com/jiwen624/logspout/output"
+)
+
+func TestReport(t *testing.T) {
+	s := testSpout(t)
+	s.conf = &config.SpoutConfig{Concurrency: 2}
+	s.seedLogs = []string{"hello", "world"}
+	s.MaxEvents = 10
+	s.StartWorkers([][]string{{"hello"}, {"world"}}, [][]string{{""}, {""}})
+	s.WaitForWorkers()
+
+	r := s.Report()
+	// the workers share the budget of events
+	assert.Len(t, r.Seeds, 2)
+	assert.Equal(t, int64(10), r.Seeds[0]+r.Seeds[1])
+	assert.Equal(t, s.endTime, r.EndTime)
+	assert.True(t, r.Seconds > 0)
+	assert.Len(t, r.ConfigHash, 64)
+	assert.Equal(t, configHash(&config.SpoutConfig{Concurrency: 2}), r.ConfigHash)
+	assert.NotEqual(t, configHash(&config.SpoutConfig{Concurrency: 3}), r.ConfigHash)
+	// the metrics are shared by the spouts of the tests
+	assert.Contains(t, r.Workers, "worker0")
+	assert.Contains(t, r.Workers, "worker1")
+	assert.Contains(t, r.Outputs, (&output.Discard{}).String())
+
+	var b bytes.Buffer
+	assert.Nil(t, r.WriteText(&b))
+	assert.Regexp(t, `(?m)^  Seeds +#0 \d+, #1 \d+$`, b.String())
+	assert.Regexp(t, `(?m)^  Workers +worker0 \d+, worker1 \d+`, b.String())
+
+	dir, err := ioutil.TempDir("", "report")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "report.json")
+	assert.Nil(t, r.WriteFile(path))
+
+	var got Report
+	data, err := ioutil.ReadFile(path)
+	assert.Nil(t, err)
+	assert.Nil(t, json.Unmarshal(data, &got))
+	assert.Equal(t, r.Seeds, got.Seeds)
+	assert.Equal(t, r.ConfigHash, got.ConfigHash)
+
+	assert.NotNil(t, r.WriteFile(filepath.Join(dir, "nonexist", "report.json")))
+}
+
+func TestRatePercentiles(t *testing.T) {
+	var r rateSampler
+	assert.Equal(t, RatePercentiles{}, r.percentiles())
+
+	for i := 100; i > 0; i-- {
+		r.add(int64(i))
+	}
+	assert.Equal(t, RatePercentiles{
+		Samples: 100,
+		Min:     1,
+		P50:     50,
+		P90:     90,
+		P99:     99,
+		Max:     100,
+	}, r.percentiles())
+}
@@ -4,16 +4,22 @@ import (
 	"fmt"
 	"os"
 	"os/signal"
+	"sort"
 	"syscall"
+
+	"github.com/pkg/errors"
+
+	"github.com/jiwen624/logspout/log"
+	"github.com/jiwen624/logspout/metrics"
 )
 
 // SigMon registers the signal handler and run the handler in a standalone goroutine.
 func (s *Spout) SigMon() {
 	c := make(chan os.Signal, 10)
-	signal.Notify(c,
+	signal.Notify(c, append([]os.Signal{
 		os.Interrupt,    // Ctrl-C
 		syscall.SIGTERM, // kill
-	)
+	}, controlSignals...)...)
 
 	go s.sigHandler(c)
 }
@@ -25,17 +31,64 @@ func (e UnsupportedSignalError) Error() string {
 }
 
 func (s *Spout) sigHandler(c chan os.Signal) {
+	var shuttingDown bool
 	for sig := range c {
 		switch sig {
 		case os.Interrupt:
 			fallthrough
 		case syscall.SIGTERM:
-			signal.Stop(c)
-			// Don't call os.Exit(), wait until all workers are closed.
-			s.Stop()
+			// A second signal exits at once, e.g., when an output hangs.
+			if shuttingDown {
+				log.Warn("Forced to exit.")
+				os.Exit(1)
+			}
+			shuttingDown = true
+			log.Warnf("Shutting down on %s, send it again to exit at once.", sig)
+			// Don't call os.Exit(), wait until all workers are closed and the
+			// outputs are flushed.
+			go s.shutdown()
 		default:
+			if s.controlSignal(sig) {
+				continue
+			}
 			err := UnsupportedSignalError(sig.String())
 			fmt.Fprintln(os.Stderr, err)
 		}
 	}
 }
+
+// shutdown shuts down the spout and logs the error if any, the report is
+// written when Start returns.
+func (s *Spout) shutdown() {
+	if _, err := s.Shutdown(); err != nil {
+		log.Warn(errors.Wrap(err, "shutdown"))
+	}
+}
+
+// logStats writes the current stats to the log. They are logged at the warn
+// level so that they are printed at the default level.
+func (s *Spout) logStats() {
+	st := s.Status()
+	log.Warnw("Stats",
+		"state", st.State,
+		"uptime", st.Uptime,
+		"workers", st.Workers,
+		"concurrency", st.Concurrency,
+		"events", st.Events,
+		"rate", st.Rate,
+		"configuredRate", st.ConfiguredRate,
+		"skipped", metrics.Pacing().Skipped,
+	)
+
+	outputs := metrics.Outputs()
+	names := make([]string, 0, len(outputs))
+	for name := range outputs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		o := outputs[name]
+		log.Warnw("Stats", "output", name, "events", o.Events, "bytes", o.Bytes,
+			"errors", o.Errors, "retries", o.Retries, "drops", o.Drops)
+	}
+}
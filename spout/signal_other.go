@@ -0,0 +1,13 @@
+//go:build !linux && !darwin && !dragonfly && !freebsd && !netbsd && !openbsd
+// +build !linux,!darwin,!dragonfly,!freebsd,!netbsd,!openbsd
+
+package spout
+
+import "os"
+
+// controlSignals are not supported on this platform, use the console instead.
+var controlSignals []os.Signal
+
+func (s *Spout) controlSignal(os.Signal) bool {
+	return false
+}
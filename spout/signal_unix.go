@@ -0,0 +1,39 @@
+//go:build linux || darwin || dragonfly || freebsd || netbsd || openbsd
+// +build linux darwin dragonfly freebsd netbsd openbsd
+
+package spout
+
+import (
+	"os"
+	"syscall"
+
+	"github.com/pkg/errors"
+
+	"github.com/jiwen624/logspout/log"
+)
+
+// controlSignals control the running spout:
+//
+//	SIGHUP   reloads the configuration file, see ReloadFile
+//	SIGUSR1  pauses the generation, or resumes it if it's paused
+//	SIGUSR2  writes the current stats to the log
+var controlSignals = []os.Signal{syscall.SIGHUP, syscall.SIGUSR1, syscall.SIGUSR2}
+
+// controlSignal handles the signal if it's one of the controlSignals.
+func (s *Spout) controlSignal(sig os.Signal) bool {
+	switch sig {
+	case syscall.SIGHUP:
+		if err := s.ReloadFile(); err != nil {
+			log.Error(errors.Wrap(err, "SIGHUP"))
+		}
+	case syscall.SIGUSR1:
+		if err := s.togglePause(); err != nil {
+			log.Warn(errors.Wrap(err, "SIGUSR1"))
+		}
+	case syscall.SIGUSR2:
+		s.logStats()
+	default:
+		return false
+	}
+	return true
+}
@@ -0,0 +1,27 @@
+//go:build linux || darwin || dragonfly || freebsd || netbsd || openbsd
+// +build linux darwin dragonfly freebsd netbsd openbsd
+
+package spout
+
+import (
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestControlSignal(t *testing.T) {
+	s := testSpout(t)
+	s.StartWorkers([][]string{{"hello world"}}, [][]string{{""}})
+	defer s.Shutdown()
+
+	assert.True(t, s.controlSignal(syscall.SIGUSR1))
+	assert.Equal(t, StatePaused, s.State())
+	assert.True(t, s.controlSignal(syscall.SIGUSR1))
+	assert.Equal(t, StateRunning, s.State())
+
+	assert.True(t, s.controlSignal(syscall.SIGUSR2))
+	// there is no file to reload, which is logged
+	assert.True(t, s.controlSignal(syscall.SIGHUP))
+	assert.False(t, s.controlSignal(syscall.SIGWINCH))
+}
@@ -9,6 +9,7 @@ import (
 	"math"
 	"os"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/jiwen624/logspout/console"
@@ -17,8 +18,10 @@ import (
 
 	"github.com/jiwen624/logspout/config"
 	"github.com/jiwen624/logspout/log"
+	"github.com/jiwen624/logspout/metrics"
 	"github.com/jiwen624/logspout/output"
 	"github.com/jiwen624/logspout/pattern"
+	"github.com/jiwen624/logspout/profile"
 	"github.com/jiwen624/logspout/replacer"
 	"github.com/jiwen624/logspout/utils"
 )
@@ -31,27 +34,74 @@ type Spout struct {
 	// MinInterval=MaxInterval=0 instead to achieve the same outcome.
 	BurstMode bool
 
-	// UniformLoad means the workload is uniform
+	// UniformLoad means the workload is uniform, see config.SpoutConfig.
 	UniformLoad bool
 
 	// Duration means how long the logspout program will run for (in seconds)
 	Duration int
 
-	// MaxEvents means the maximum number of events logspout will generate
+	// MaxEvents means the maximum number of events logspout will generate, which
+	// is a budget shared by all the workers.
 	MaxEvents int
 
+	// SplitTransactions lets the last transaction be cut to generate exactly
+	// MaxEvents, see config.SpoutConfig.
+	SplitTransactions bool
+
 	// ConsolePort specifies the port for management console.
 	ConsolePort int
 
+	// ConsoleConfig defines the bind address, the authentication and the TLS
+	// of the management console.
+	ConsoleConfig console.Config
+
+	// StatsD pushes the metrics to a StatsD server if it's set.
+	StatsD *metrics.StatsDConfig
+
 	// Concurrency defines the number of workers to generate logs concurrently.
+	// It may be changed through the console while the workers are running, see
+	// SetConcurrency.
 	Concurrency int
 
+	// WorkerProfile scales Concurrency over time since the workers are
+	// started, it's nil if the concurrency is flat.
+	WorkerProfile profile.Profile
+
 	// MinInterval is the minimum interval between two log entries.
+	// It may be changed through the console while the workers are running, see
+	// SetIntervals.
 	MinInterval int
 
 	// MaxInterval is the maximum interval between two log entries.
+	// It may be changed through the console while the workers are running, see
+	// SetIntervals.
 	MaxInterval int
 
+	// EPS is the rate in events per second of all the workers, which are paced
+	// by a shared limiter and never think if it's set. 0 means unlimited.
+	// It may be changed through the console while the workers are running, see
+	// SetEPS.
+	EPS float64
+
+	// BytesPerSecond is the rate in bytes of the rendered events per second of
+	// all the workers, which is paced in the same way as EPS. At most one of
+	// them is non-zero.
+	// It may be changed through the console while the workers are running, see
+	// SetBytesPerSecond.
+	BytesPerSecond float64
+
+	// LoadProfile scales the rate over time since the workers are started,
+	// including the time they are paused. It's nil if the rate is flat.
+	LoadProfile profile.Profile
+
+	// SchedulePacing gives each event paced by the think times an intended
+	// time on a schedule, see config.Pacing.
+	SchedulePacing bool
+
+	// MaxLag is how far the events may fall behind their schedule and still
+	// be caught up with, 0 means never catching up.
+	MaxLag time.Duration
+
 	// LogType defines the type of the logs, e.g., the application name.
 	LogType string
 
@@ -59,6 +109,11 @@ type Spout struct {
 	// from.
 	SampleFilePath string
 
+	// ConfigPath is the file the configuration is loaded from, which is
+	// reloaded on SIGHUP, see ReloadFile, or when it's changed in watch mode,
+	// see Watch.
+	ConfigPath string
+
 	// TransactionID defines the transaction IDs for transaction mode. Under
 	// transaction mode, if a certain number of logs have the same value in these
 	// keys, they form a transaction.
@@ -68,6 +123,14 @@ type Spout struct {
 	// I need a better name for it).
 	MaxIntraTransLat int
 
+	// DrainTimeout is how long a shutdown waits for the workers to exit before
+	// the outputs are closed, 0 means waiting until they exit.
+	DrainTimeout time.Duration
+
+	// FinishTransactions lets the workers finish the transactions they are in
+	// the middle of when the spout is shut down.
+	FinishTransactions bool
+
 	// seedLogs are the sample logs to be manipulated
 	seedLogs []string
 
@@ -88,9 +151,75 @@ type Spout struct {
 	// of the spout replacers.
 	Replacers replacer.Replacers
 
+	// kinds are the kinds of the values generated by the replacers, which are
+	// attached to the events for the outputs. It's a map[string]output.Kind,
+	// which is swapped when the replacers are reloaded.
+	kinds atomic.Value
+
+	// conf is the configuration the spout is built from
+	conf *config.SpoutConfig
+
+	// tail streams the events to the clients of the tail endpoint
+	tail *tailHub
+
+	// reporter pushes the metrics, it's nil if StatsD is not set
+	reporter *metrics.StatsD
+
+	// seedCounts are the numbers of events and bytes generated from each seed
+	// log. It's a []*seedCount, which grows when more seed logs are reloaded.
+	seedCounts atomic.Value
+	// rates samples the number of events generated in each second
+	rates rateSampler
+	// limiter paces the events of the workers at EPS
+	limiter *rateLimiter
+	// budget is the number of events left to generate of MaxEvents
+	budget *budget
+	// factor is the current factor of the load profile, 1 without it
+	factor float64
+	// workerFactor is the current factor of the worker profile, 1 without it
+	workerFactor float64
+	// the jitters of the delays between and in the transactions, which are
+	// Gaussian if they are nil
+	interJitter, intraJitter *jitterSpec
+
 	// close is the indicator to close the spout
 	close     chan struct{}
 	closeOnce sync.Once
+	// cut is closed to make the workers exit at once, which is before the
+	// spout is closed so that they don't write to the closed outputs.
+	cut     chan struct{}
+	cutOnce sync.Once
+
+	// mu protects Concurrency, MinInterval, MaxInterval and the runtime state
+	// below, which may be changed through the console.
+	mu sync.RWMutex
+	// the workers which are not asked to stop
+	workers []*worker
+	// the number of the workers which haven't exited
+	running int
+	// the index of the next worker to be started
+	nextWorker int
+	// the tokens the workers generate logs from
+	matches, names [][]string
+	// paused is closed when the generation is resumed, it's nil if the
+	// generation is not paused.
+	paused chan struct{}
+	// halted is set when the generation is stopped through the console, the
+	// spout doesn't exit until it's shut down then.
+	halted bool
+	// shuttingDown is set when the spout is being shut down, no worker is
+	// started after that.
+	shuttingDown bool
+	// idle is closed when all the workers have exited
+	idle chan struct{}
+	// when the workers are started
+	startTime time.Time
+	// when all the workers have exited
+	endTime time.Time
+	// shutdowns tracks the shutdown requests being served, so that their
+	// responses are sent before the spout exits. They are added under mu
+	// before the spout is closed, see trackShutdown.
+	shutdowns sync.WaitGroup
 
 	// Used to coordinate between the main goroutine and the workers
 	sync.WaitGroup
@@ -102,7 +231,17 @@ const (
 
 // NewDefault returns the pointer of a new Spout object.
 func NewDefault() *Spout {
-	return &Spout{close: make(chan struct{})}
+	return &Spout{
+		close:        make(chan struct{}),
+		cut:          make(chan struct{}),
+		idle:         make(chan struct{}),
+		tail:         newTailHub(),
+		limiter:      newRateLimiter(),
+		factor:       1,
+		workerFactor: 1,
+		MaxLag:       defaultMaxLag,
+		DrainTimeout: defaultDrainTimeout,
+	}
 }
 
 // setOrFallback returns val if val doesn't equal to init (the initial value),
@@ -118,24 +257,57 @@ func setOrFallback(val int, init int, fallback int) int {
 func Build(cfg *config.SpoutConfig) (*Spout, error) {
 	s := NewDefault()
 
+	s.conf = cfg
 	s.BurstMode = cfg.BurstMode
 	s.UniformLoad = cfg.UniformLoad
 	s.Duration = cfg.Duration
 
 	s.MaxEvents = setOrFallback(cfg.MaxEvents, 0, int(math.MaxInt32))
+	s.SplitTransactions = cfg.SplitTransactions
 	s.ConsolePort = setOrFallback(cfg.ConsolePort, 0, defaultConsolePort)
+	s.ConsoleConfig = cfg.Console
+	s.StatsD = cfg.StatsD
 
+	if cfg.Concurrency <= 0 {
+		return nil, errors.Wrap(ErrBadConcurrency, "build spout")
+	}
 	s.Concurrency = cfg.Concurrency
+	if cfg.WorkerProfile != nil {
+		p, err := profile.New(*cfg.WorkerProfile)
+		if err != nil {
+			return nil, errors.Wrap(err, "build spout: workerProfile")
+		}
+		s.WorkerProfile = p
+	}
 	s.MinInterval = cfg.MinInterval
 	s.MaxInterval = cfg.MaxInterval
+	if err := s.buildRate(cfg); err != nil {
+		return nil, errors.Wrap(err, "build spout")
+	}
+	if err := s.buildJitters(cfg); err != nil {
+		return nil, errors.Wrap(err, "build spout")
+	}
+	s.SchedulePacing = cfg.Pacing.Schedule
+	s.MaxLag = maxLag(cfg.Pacing.MaxLag)
+	s.limiter.setMaxLag(s.MaxLag)
 	s.LogType = cfg.LogType
 	s.SampleFilePath = cfg.SampleFilePath
 	s.TransactionID = cfg.TransactionID
 	s.MaxIntraTransLat = cfg.MaxIntraTransactionLatency
+	s.DrainTimeout = drainTimeout(cfg.Shutdown.DrainTimeout)
+	s.FinishTransactions = cfg.Shutdown.FinishTransactions
 
-	if err := s.loadRawMessage(); err != nil {
+	seedLogs, err := readSeedLogs(s.SampleFilePath)
+	if err != nil {
 		return nil, errors.Wrap(err, "build spout")
 	}
+	s.seedLogs = seedLogs
+	// The transactions are whole unless they can be split.
+	n := len(s.seedLogs)
+	if cfg.MaxEvents != 0 && len(s.TransactionID) != 0 && !s.SplitTransactions && n > 0 && s.MaxEvents%n != 0 {
+		log.Warnf("maxEvents %d is not a multiple of the %d logs of a transaction, %d events will be generated.",
+			s.MaxEvents, n, s.MaxEvents/n*n)
+	}
 
 	op, err := output.RegistryFromConf(cfg.Output)
 	if err != nil {
@@ -146,8 +318,8 @@ func Build(cfg *config.SpoutConfig) (*Spout, error) {
 	}
 	s.Output = op
 
-	for _, ptn := range cfg.Pattern {
-		s.Patterns = append(s.Patterns, pattern.New(ptn))
+	if s.Patterns, err = compilePatterns(cfg.Pattern); err != nil {
+		return nil, errors.Wrap(err, "build spout")
 	}
 
 	r, err := replacer.Build(cfg.Replacement)
@@ -155,10 +327,72 @@ func Build(cfg *config.SpoutConfig) (*Spout, error) {
 		return nil, errors.Wrap(err, "build replacer")
 	}
 	s.Replacers = r
+	s.kinds.Store(replacerKinds(r))
 
 	return s.SanityCheck()
 }
 
+// buildRate sets the rate from the config, in either events or bytes per
+// second.
+func (s *Spout) buildRate(cfg *config.SpoutConfig) error {
+	eps, bps, err := rateFromConfig(cfg)
+	if err != nil {
+		return err
+	}
+	s.EPS, s.BytesPerSecond = eps, bps
+	s.limiter.set(eps, bps)
+
+	if cfg.LoadProfile != nil {
+		if eps == 0 && bps == 0 {
+			return errNoRateToShape
+		}
+		p, err := profile.New(*cfg.LoadProfile)
+		if err != nil {
+			return err
+		}
+		s.LoadProfile = p
+	}
+	return nil
+}
+
+// rateFromConfig returns the rate in events and bytes per second of the config,
+// at most one of which is non-zero.
+func rateFromConfig(cfg *config.SpoutConfig) (float64, float64, error) {
+	bps := cfg.BytesPerSecond
+	if cfg.GBPerDay != 0 {
+		if bps != 0 {
+			return 0, 0, errRateConflict
+		}
+		bps = cfg.GBPerDay * bytesPerGB / secondsPerDay
+	}
+	return cfg.EPS, bps, checkRate(cfg.EPS, bps)
+}
+
+// buildJitters parses the jitters from the config.
+func (s *Spout) buildJitters(cfg *config.SpoutConfig) error {
+	var err error
+	if s.interJitter, err = newJitterSpec(cfg.InterTransJitter, cfg.UniformLoad); err != nil {
+		return errors.Wrap(err, "interTransJitter")
+	}
+	s.intraJitter, err = newJitterSpec(cfg.IntraTransJitter, cfg.UniformLoad)
+	return errors.Wrap(err, "intraTransJitter")
+}
+
+// replacerKinds returns the kinds of the capture groups generated by numeric
+// replacers.
+func replacerKinds(r replacer.Replacers) map[string]output.Kind {
+	kinds := make(map[string]output.Kind)
+	for k, v := range r {
+		switch v.(type) {
+		case *replacer.IntegerReplacer:
+			kinds[k] = output.KindInteger
+		case *replacer.FloatReplacer:
+			kinds[k] = output.KindFloat
+		}
+	}
+	return kinds
+}
+
 type PatternSeedMismatchError string
 
 func (e PatternSeedMismatchError) Error() string {
@@ -180,22 +414,58 @@ func (s *Spout) SanityCheck() (*Spout, error) {
 
 // StartAllOutputs starts all the outputs
 func (s *Spout) StartAllOutputs() error {
-	return s.Output.ForAll(func(o output.Output) error {
-		return o.Activate()
-	})
+	return s.Output.ActivateAll()
 }
 
 // StopAllOutput stops all the outputs
 func (s *Spout) StopAllOutputs() error {
-	return s.Output.ForAll(func(o output.Output) error {
-		return o.Deactivate()
-	})
+	return s.Output.DeactivateAll()
+}
+
+// StartConsole starts the management console in a standalone goroutine unless
+// it's disabled. The outputs can be managed through the console while the
+// workers are running.
+func (s *Spout) StartConsole() error {
+	if s.ConsoleConfig.Disabled {
+		log.Info("The console is disabled.")
+		return nil
+	}
+
+	srv, err := console.NewServer(s.ConsoleConfig, fmt.Sprintf("localhost:%d", s.ConsolePort))
+	if err != nil {
+		return errors.Wrap(err, "console")
+	}
+	metrics.Register(srv)
+	h := output.Handler(s.Output)
+	srv.Handle(output.EndPoint, h)
+	srv.Handle(output.EndPoint+"/", h)
+	srv.Handle(APIEndPoint, APIHandler(s))
+
+	return errors.Wrap(srv.Start(), "console")
+}
+
+// StartReporter starts pushing the metrics if StatsD is set.
+func (s *Spout) StartReporter() error {
+	if s.StatsD == nil {
+		return nil
+	}
+	r, err := metrics.NewStatsD(*s.StatsD)
+	if err != nil {
+		return errors.Wrap(err, "reporter")
+	}
+	r.Start()
+	s.reporter = r
+	return nil
 }
 
-// StartConsole starts the management console in a standalone goroutine.
-func (s *Spout) StartConsole() {
-	host := fmt.Sprintf("localhost:%d", s.ConsolePort)
-	console.Start(host)
+// StopReporter pushes the metrics for the last time and stops the reporter.
+func (s *Spout) StopReporter() {
+	if s.reporter == nil {
+		return
+	}
+	if err := s.reporter.Close(); err != nil {
+		log.Warn(errors.Wrap(err, "stop reporter"))
+	}
 }
 
 // Start kicks off the spout
@@ -204,33 +474,67 @@ func (s *Spout) Start() error {
 		return errors.Wrap(err, "start failed")
 	}
 
-	s.StartConsole()
+	if err := s.StartConsole(); err != nil {
+		return errors.Wrap(err, "start failed")
+	}
+	if err := s.StartReporter(); err != nil {
+		return errors.Wrap(err, "start failed")
+	}
 	s.SigMon()
 
-	return s.ProduceLogs()
+	err := s.ProduceLogs()
+	// The outputs are closed before the last push of the metrics, so that the
+	// events they flush on closing are counted.
+	s.Stop()
+	s.StopReporter()
+	return err
 }
 
-// Stop stops the spout
+// Stop cuts the workers still running, flushes and closes the outputs, then
+// closes the spout. See Shutdown for stopping the workers in order first.
 func (s *Spout) Stop() {
 	s.closeOnce.Do(func() {
 		log.Info("LogSpout is closing.")
 
+		s.cutWorkers()
+		if err := s.Output.FlushAll(); err != nil {
+			log.Error(errors.Wrap(err, "logspout stop"))
+		}
 		if err := s.StopAllOutputs(); err != nil {
 			log.Error(errors.Wrap(err, "logspout stop"))
 		}
+		// It's closed under the lock so that no shutdown request is tracked
+		// after it's closed.
+		s.mu.Lock()
 		close(s.close)
+		s.mu.Unlock()
 	})
 }
 
-func (s *Spout) loadRawMessage() error {
-	file, err := os.Open(s.SampleFilePath)
+// compilePatterns compiles the patterns of the config.
+func compilePatterns(ptns []string) ([]pattern.Pattern, error) {
+	var patterns []pattern.Pattern
+	for _, ptn := range ptns {
+		p, err := pattern.Compile(ptn)
+		if err != nil {
+			return nil, errors.Wrap(err, "pattern")
+		}
+		patterns = append(patterns, p)
+	}
+	return patterns, nil
+}
+
+// readSeedLogs reads the seed logs from the sample file.
+func readSeedLogs(path string) ([]string, error) {
+	file, err := os.Open(path)
 	if err != nil {
-		return errors.Wrap(err, "loadRawMessage")
+		return nil, errors.Wrap(err, "loadRawMessage")
 	}
 	defer file.Close()
 
 	scanner := bufio.NewScanner(file)
 
+	var seedLogs []string
 	var buffer bytes.Buffer
 	var vs string
 	for scanner.Scan() {
@@ -240,7 +544,7 @@ func (s *Spout) loadRawMessage() error {
 			if buffer.Len() == 0 {
 				continue
 			}
-			s.seedLogs = append(s.seedLogs, buffer.String())
+			seedLogs = append(seedLogs, buffer.String())
 			buffer.Reset()
 			continue
 		}
@@ -249,10 +553,10 @@ func (s *Spout) loadRawMessage() error {
 	}
 
 	if buffer.Len() != 0 {
-		s.seedLogs = append(s.seedLogs, buffer.String())
+		seedLogs = append(seedLogs, buffer.String())
 	}
 
-	return nil
+	return seedLogs, nil
 }
 
 // ProduceLogs generate logs based on the replacers configuration. It will block
@@ -272,18 +576,64 @@ func (s *Spout) ProduceLogs() error {
 }
 
 // Spray sprays the generated logs into the predefined destinations.
-func (s *Spout) Spray(log string) error {
-	return s.Output.Write(log)
+func (s *Spout) Spray(e *output.Event) error {
+	e.LogType = s.LogType
+	e.Kinds, _ = s.kinds.Load().(map[string]output.Kind)
+	if counts := s.counts(); e.Seed < len(counts) {
+		atomic.AddInt64(&counts[e.Seed].events, 1)
+		atomic.AddInt64(&counts[e.Seed].bytes, int64(len(e.Raw)))
+	}
+	s.tail.publish(e)
+	return s.Output.WriteEvent(e)
+}
+
+// seedCount is the numbers of events and bytes generated from a seed log.
+type seedCount struct {
+	events, bytes int64
+}
+
+// counts returns the numbers of events and bytes generated from each seed log.
+func (s *Spout) counts() []*seedCount {
+	counts, _ := s.seedCounts.Load().([]*seedCount)
+	return counts
+}
+
+// growCounts makes room for the counts of n seed logs, the counts of the seed
+// logs already there are kept. The caller must hold the lock.
+func (s *Spout) growCounts(n int) {
+	counts := s.counts()
+	for len(counts) < n {
+		counts = append(counts, &seedCount{})
+	}
+	s.seedCounts.Store(counts)
+}
+
+// sprayed returns the number of events sprayed.
+func (s *Spout) sprayed() int64 {
+	var n int64
+	for _, c := range s.counts() {
+		n += atomic.LoadInt64(&c.events)
+	}
+	return n
 }
 
 // GenerateTokens matches the seed logs with the patterns and generate
 // the tokens.
 func (s *Spout) GenerateTokens() ([][]string, [][]string, error) {
+	return generateTokens(s.Patterns, s.seedLogs)
+}
+
+// generateTokens matches the seed logs with the patterns and generate the
+// tokens.
+func generateTokens(patterns []pattern.Pattern, seedLogs []string) ([][]string, [][]string, error) {
+	if len(patterns) != len(seedLogs) {
+		return nil, nil, PatternSeedMismatchError(fmt.Sprintf("%d, %d", len(seedLogs), len(patterns)))
+	}
 	var matches = make([][]string, 0)
 	var names = make([][]string, 0)
 
-	for idx, ptn := range s.Patterns {
-		matches = append(matches, ptn.Matches(s.seedLogs[idx]))
+	for idx, ptn := range patterns {
+		matches = append(matches, ptn.Matches(seedLogs[idx]))
 		names = append(names, ptn.Names())
 
 		if len(matches[idx]) == 0 {
@@ -301,44 +651,256 @@ func (s *Spout) GenerateTokens() ([][]string, [][]string, error) {
 
 // StartWorkers creates and starts all workers.
 func (s *Spout) StartWorkers(matches [][]string, names [][]string) {
-	log.Infof("LogSpout starting up with %d workers.", s.Concurrency)
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-	s.Add(s.Concurrency) // Add them before you start the goroutines.
+	s.startTime = time.Now()
+	if s.LoadProfile != nil {
+		log.Infof("The rate is shaped by the %s load profile.", s.LoadProfile)
+		s.scaleRate(s.LoadProfile.Factor(0))
+	}
+	if s.WorkerProfile != nil {
+		log.Infof("The concurrency is shaped by the %s worker profile.", s.WorkerProfile)
+		s.workerFactor = s.WorkerProfile.Factor(0)
+	}
+	if s.LoadProfile != nil || s.WorkerProfile != nil {
+		go s.runProfile()
+	}
+	log.Infof("LogSpout starting up with %d workers.", s.concurrency())
+	metrics.SetConfiguredRate(s.nominalRate())
+
+	s.matches, s.names = matches, names
+	s.growCounts(len(s.seedLogs))
+	// The events are counted as they are sprayed rather than reported by the
+	// workers every second, which are not in step.
+	go s.rates.run(s.sprayed, s.idle, s.close)
+	s.budget = newBudget(s.MaxEvents)
+	s.startWorkers(s.concurrency())
+	if s.running == 0 {
+		close(s.idle)
+	}
+}
 
-	for i := 0; i < s.Concurrency; i++ {
-		w := NewWorker(workerConfig{
-			Index:            i,
-			MaxEvents:        int(s.MaxEvents / s.Concurrency),
+// startWorkers starts n more workers. The caller must hold the lock.
+func (s *Spout) startWorkers(n int) {
+	s.Add(n) // Add them before you start the goroutines.
+	s.running += n
+	metrics.SetWorkers(s.running)
+
+	for i := 0; i < n; i++ {
+		seed := time.Now().UnixNano() + int64(s.nextWorker)<<32
+		var sched *schedule
+		if s.SchedulePacing {
+			sched = newSchedule(s.MaxLag)
+		}
+		var w *worker
+		w = NewWorker(workerConfig{
+			Index:            s.nextWorker,
+			Budget:           s.budget,
+			SplitTrans:       s.SplitTransactions,
 			Seconds:          s.Duration,
 			Replacers:        s.Replacers.Copy(),
 			TransIDs:         s.TransactionID,
 			SeedLogs:         s.seedLogs,
-			MinInterval:      s.MinInterval,
-			MaxInterval:      s.MaxInterval,
-			UniformLoad:      s.UniformLoad,
+			Intervals:        s.intervals,
+			InterJitter:      s.interJitter.new(seed),
+			IntraJitter:      s.intraJitter.new(seed + 1),
 			MaxIntraTransLat: s.MaxIntraTransLat,
 			WriteTo:          s.Spray,
-			DoneCallback:     s.Done,
-			CloseChan:        s.close,
+			DoneCallback:     func() { s.workerDone(w) },
+			CloseChan:        s.cut,
+			Paused:           s.pausedChan,
+			Limiter:          s.limiter,
+			Schedule:         sched,
 			BurstMode:        s.BurstMode,
 		})
-		go w.start(matches, names, i)
+		s.workers = append(s.workers, w)
+		go w.start(s.matches, s.names)
+		s.nextWorker++
 	}
 }
 
-// WaitForWorkers monitors the timer, stop all workers then and wait for them
-// before exiting.
-func (s *Spout) WaitForWorkers() {
-	if s.Duration != 0 {
+// scaleWorkers starts or stops the workers to run as many as the concurrency
+// scaled by the worker profile. No worker is started once the budget is used
+// up. The caller must hold the lock.
+func (s *Spout) scaleWorkers() {
+	cur, n := len(s.workers), s.concurrency()
+	switch {
+	case n > cur && !s.exhausted():
+		s.startWorkers(n - cur)
+	case n < cur:
+		s.stopWorkers(cur - n)
+	}
+}
+
+// exhausted tells if no more events can be taken from the budget, which has
+// fewer events left than a transaction unless they can be split.
+func (s *Spout) exhausted() bool {
+	left := s.budget.remaining()
+	if len(s.TransactionID) != 0 && !s.SplitTransactions {
+		return left < int64(len(s.seedLogs))
+	}
+	return left <= 0
+}
+
+// concurrency returns the number of the workers to run, which is Concurrency
+// scaled by the worker profile, at least 1 as Concurrency is positive. The
+// caller must hold the lock.
+func (s *Spout) concurrency() int {
+	n := int(math.Round(float64(s.Concurrency) * s.workerFactor))
+	if n < 1 {
+		return 1
+	}
+	return n
+}
+
+// workerDone is called when a worker exits.
+func (s *Spout) workerDone(w *worker) {
+	s.mu.Lock()
+	for i, v := range s.workers {
+		if v == w {
+			s.workers = append(s.workers[:i], s.workers[i+1:]...)
+			break
+		}
+	}
+	s.running--
+	metrics.SetWorkers(s.running)
+	if s.running == 0 {
+		s.endTime = time.Now()
+		close(s.idle)
+	}
+	s.mu.Unlock()
+
+	s.Done()
+}
+
+// runProfile scales the rate by the load profile and the concurrency by the
+// worker profile until the workers exit or the spout is closed.
+func (s *Spout) runProfile() {
+	ticker := time.NewTicker(profileTick)
+	defer ticker.Stop()
+
+	for {
 		select {
-		case <-time.After(time.Second * time.Duration(s.Duration)):
-			log.Debugf("Stopping logspout after: %v sec", s.Duration)
-			s.Stop()
+		case <-s.idle:
+			return
 		case <-s.close:
+			return
+		case <-ticker.C:
+			s.mu.Lock()
+			elapsed := time.Since(s.startTime)
+			if s.LoadProfile != nil {
+				s.scaleRate(s.LoadProfile.Factor(elapsed))
+			}
+			// No worker is started after the generation is stopped.
+			if s.WorkerProfile != nil && s.state() != StateStopped {
+				s.workerFactor = s.WorkerProfile.Factor(elapsed)
+				s.scaleWorkers()
+			}
+			metrics.SetConfiguredRate(s.nominalRate())
+			s.mu.Unlock()
+		}
+	}
+}
+
+// scaleRate scales the rate by the factor. The caller must hold the lock.
+func (s *Spout) scaleRate(factor float64) {
+	s.factor = factor
+	s.limiter.scaleTo(factor)
+}
+
+// nominalRate estimates the rate in events per second of all the workers from
+// the average think times unless the rate is set, it's 0 if the workers never
+// think.
+func (s *Spout) nominalRate() float64 {
+	if s.EPS > 0 {
+		return s.EPS * s.factor
+	}
+	if s.BurstMode || len(s.seedLogs) == 0 {
+		return 0
+	}
+	if s.BytesPerSecond > 0 {
+		// The events are about the size of the seed logs.
+		var size int
+		for _, l := range s.seedLogs {
+			size += len(l)
+		}
+		if size == 0 {
+			return 0
+		}
+		return s.BytesPerSecond * s.factor * float64(len(s.seedLogs)) / float64(size)
+	}
+
+	// The think times are drawn from [0, n) milliseconds by the jitters whose
+	// means are about the middle of the range.
+	mean := func(n int) float64 {
+		if n <= 0 {
+			return 0
+		}
+		return float64(n-1) / 2
+	}
+	var cycle float64
+	if len(s.TransactionID) != 0 {
+		cycle += float64(len(s.seedLogs)) * mean(s.MaxIntraTransLat)
+	}
+	if s.MaxInterval > 0 {
+		cycle += float64(s.MinInterval)
+		if s.MaxInterval > s.MinInterval {
+			cycle += mean(s.MaxInterval - s.MinInterval)
 		}
 	}
+	if cycle == 0 {
+		return 0
+	}
+	return float64(s.concurrency()*len(s.seedLogs)) * 1000 / cycle
+}
+
+// intervals returns the current minimum and maximum intervals.
+func (s *Spout) intervals() (int, int) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.MinInterval, s.MaxInterval
+}
+
+// pausedChan returns a channel which is closed when the generation is resumed
+// if it's paused, otherwise nil.
+func (s *Spout) pausedChan() <-chan struct{} {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.paused == nil {
+		return nil
+	}
+	return s.paused
+}
+
+// WaitForWorkers monitors the timer, stop all workers then and wait for them
+// before exiting. If the generation is stopped through the console, it waits
+// until the spout is shut down.
+func (s *Spout) WaitForWorkers() {
+	var timeout <-chan time.Time
+	if s.Duration != 0 {
+		timeout = time.After(time.Second * time.Duration(s.Duration))
+	}
 
+	select {
+	case <-timeout:
+		log.Debugf("Stopping logspout after: %v sec", s.Duration)
+		if err := s.drain(); err != nil {
+			log.Warn(errors.Wrap(err, "logspout stop"))
+		}
+	case <-s.close:
+	case <-s.idle:
+	}
 	s.Wait()
+
+	s.mu.RLock()
+	halted := s.halted
+	s.mu.RUnlock()
+	if halted {
+		log.Info("Generation is stopped, waiting to be shut down.")
+		<-s.close
+	}
+	s.shutdowns.Wait()
 }
 
 func debugPrintPatterns(matches, names [][]string) {
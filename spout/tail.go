@@ -0,0 +1,219 @@
+package spout
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/jiwen624/logspout/console"
+	"github.com/jiwen624/logspout/log"
+	"github.com/jiwen624/logspout/output"
+)
+
+// The query parameters of the tail endpoint, the others are the filters on the
+// fields of the events as the WebSocket output does, e.g., user=bob&user=alice
+// for the events whose user is either bob or alice.
+const (
+	// the fraction of the matched events to be streamed, in (0, 1]
+	tailSampleParam = "sample"
+	// the index of the seed log (and its pattern) the events are generated from
+	tailSeedParam = "seed"
+	// the format of the events, either json (the default) or raw
+	tailFormatParam = "format"
+)
+
+const (
+	// the number of events buffered for a client
+	tailBufferSize = 256
+	// the interval of the comments sent to keep the connection alive
+	tailHeartbeat = 15 * time.Second
+)
+
+var (
+	errBadSample     = errors.New("sample must be in (0, 1]")
+	errBadTailFormat = errors.New("format must be either json or raw")
+)
+
+// tailHub streams the events generated to the clients of the tail endpoint.
+// The events are published by the workers, a client which can't keep up skips
+// the events rather than slowing down the workers.
+type tailHub struct {
+	// the number of the clients, so that nothing is done without any
+	n    int32
+	mu   sync.RWMutex
+	subs map[*tailSub]struct{}
+}
+
+// tailSub is a client of the tail endpoint.
+type tailSub struct {
+	filters url.Values
+	sample  float64
+	format  string
+	// the number of the matched events, for sampling
+	seen uint64
+	// the number of the events skipped as the buffer is full
+	skipped uint64
+	msgs    chan []byte
+}
+
+func newTailHub() *tailHub {
+	return &tailHub{subs: make(map[*tailSub]struct{})}
+}
+
+// newTailSub creates a client from the query parameters.
+func newTailSub(q url.Values) (*tailSub, error) {
+	sub := &tailSub{sample: 1, format: output.FormatJSON, msgs: make(chan []byte, tailBufferSize)}
+
+	if v := q.Get(tailSampleParam); v != "" {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil || f <= 0 || f > 1 {
+			return nil, errBadSample
+		}
+		sub.sample = f
+	}
+	if v := q.Get(tailFormatParam); v != "" {
+		if v != output.FormatJSON && v != output.FormatRaw {
+			return nil, errBadTailFormat
+		}
+		sub.format = v
+	}
+	if vs, ok := q[tailSeedParam]; ok {
+		q[output.FieldSeed] = vs
+	}
+	q.Del(tailSampleParam)
+	q.Del(tailFormatParam)
+	q.Del(tailSeedParam)
+	sub.filters = q
+	return sub, nil
+}
+
+// sampled tells if the next matched event is in the sample. The events are
+// sampled evenly, e.g., one in every ten for 0.1.
+func (sub *tailSub) sampled() bool {
+	n := atomic.AddUint64(&sub.seen, 1)
+	return uint64(float64(n)*sub.sample) != uint64(float64(n-1)*sub.sample)
+}
+
+// publish sends the event to the clients subscribing to it. The event is
+// encoded here as its capture groups are reused after it returns.
+func (h *tailHub) publish(e *output.Event) {
+	if atomic.LoadInt32(&h.n) == 0 {
+		return
+	}
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	var msgs = make(map[string][]byte, 2)
+	for sub := range h.subs {
+		if !e.Matches(sub.filters) || !sub.sampled() {
+			continue
+		}
+		msg, ok := msgs[sub.format]
+		if !ok {
+			msg = output.EncodeEvent(e, sub.format)
+			msgs[sub.format] = msg
+		}
+
+		select {
+		case sub.msgs <- msg:
+		default:
+			atomic.AddUint64(&sub.skipped, 1)
+		}
+	}
+}
+
+func (h *tailHub) add(sub *tailSub) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.subs[sub] = struct{}{}
+	atomic.AddInt32(&h.n, 1)
+}
+
+func (h *tailHub) remove(sub *tailSub) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	delete(h.subs, sub)
+	atomic.AddInt32(&h.n, -1)
+}
+
+// writeSSE writes an event of the Server-Sent Events, each line of the data is
+// sent in a data field.
+func writeSSE(w http.ResponseWriter, event string, data []byte) error {
+	var b bytes.Buffer
+	if event != "" {
+		fmt.Fprintf(&b, "event: %s\n", event)
+	}
+	for _, l := range strings.Split(strings.TrimSuffix(string(data), "\n"), "\n") {
+		b.WriteString("data: ")
+		b.WriteString(strings.TrimSuffix(l, "\r"))
+		b.WriteByte('\n')
+	}
+	b.WriteByte('\n')
+	_, err := w.Write(b.Bytes())
+	return err
+}
+
+// serveTail streams the events as Server-Sent Events until the client goes
+// away or the spout is closed. A "skipped" event tells the number of events
+// the client has missed since the last one as it didn't keep up.
+func (s *Spout) serveTail(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		console.WriteStatus(w, http.StatusInternalServerError)
+		return
+	}
+	sub, err := newTailSub(r.URL.Query())
+	if err != nil {
+		console.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	s.tail.add(sub)
+	defer s.tail.remove(sub)
+	log.Debugf("Tail client %s connected", r.RemoteAddr)
+	defer log.Debugf("Tail client %s disconnected", r.RemoteAddr)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, ": tailing\n\n")
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(tailHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		var err error
+		select {
+		case msg := <-sub.msgs:
+			if n := atomic.SwapUint64(&sub.skipped, 0); n > 0 {
+				err = writeSSE(w, "skipped", []byte(strconv.FormatUint(n, 10)))
+			}
+			if err == nil {
+				err = writeSSE(w, "", msg)
+			}
+		case <-heartbeat.C:
+			_, err = fmt.Fprint(w, ": heartbeat\n\n")
+		case <-r.Context().Done():
+			return
+		case <-s.close:
+			return
+		}
+		if err != nil {
+			return
+		}
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,121 @@
+package spout
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/jiwen624/logspout/output"
+)
+
+func tailEvent(seed int, user string) *output.Event {
+	return &output.Event{
+		Raw:    "hello " + user + "\n",
+		Seed:   seed,
+		Names:  []string{"", "user"},
+		Values: []string{"hello ", user},
+	}
+}
+
+func TestTailSub(t *testing.T) {
+	for _, q := range []string{"sample=0", "sample=2", "sample=x", "format=xml"} {
+		v, _ := url.ParseQuery(q)
+		_, err := newTailSub(v)
+		assert.NotNil(t, err, q)
+	}
+
+	v, _ := url.ParseQuery("sample=0.25&seed=1&user=bob&user=alice&format=raw")
+	sub, err := newTailSub(v)
+	assert.Nil(t, err)
+	assert.Equal(t, output.FormatRaw, sub.format)
+	assert.Equal(t, url.Values{output.FieldSeed: {"1"}, "user": {"bob", "alice"}}, sub.filters)
+
+	var n int
+	for i := 0; i < 100; i++ {
+		if sub.sampled() {
+			n++
+		}
+	}
+	assert.Equal(t, 25, n)
+
+	h := newTailHub()
+	h.publish(tailEvent(1, "bob"))
+	h.add(sub)
+	sub.sample = 1
+	h.publish(tailEvent(0, "bob"))
+	h.publish(tailEvent(1, "carol"))
+	h.publish(tailEvent(1, "alice"))
+	assert.Equal(t, 1, len(sub.msgs))
+	assert.Equal(t, "hello alice\n", string(<-sub.msgs))
+
+	// a client which doesn't keep up skips the events
+	for i := 0; i < tailBufferSize+2; i++ {
+		h.publish(tailEvent(1, "bob"))
+	}
+	assert.Equal(t, uint64(2), sub.skipped)
+	h.remove(sub)
+	assert.Equal(t, int32(0), h.n)
+}
+
+func TestServeTail(t *testing.T) {
+	s := testSpout(t)
+	srv := httptest.NewServer(APIHandler(s))
+	defer srv.Close()
+
+	rsp, err := http.Get(srv.URL + APIEndPoint + "tail?sample=2")
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusBadRequest, rsp.StatusCode)
+
+	rsp, err = http.Get(srv.URL + APIEndPoint + "tail?user=bob")
+	assert.Nil(t, err)
+	defer rsp.Body.Close()
+	assert.Equal(t, "text/event-stream", rsp.Header.Get("Content-Type"))
+
+	r := bufio.NewReader(rsp.Body)
+	l, _ := r.ReadString('\n')
+	assert.Equal(t, ": tailing\n", l)
+	r.ReadString('\n')
+
+	e := tailEvent(0, "bob")
+	e.Raw = "line1\nline2"
+	s.tail.publish(tailEvent(0, "alice"))
+	s.tail.publish(e)
+
+	var lines []string
+	for len(lines) < 1 {
+		l, err := r.ReadString('\n')
+		assert.Nil(t, err)
+		lines = append(lines, strings.TrimSuffix(l, "\n"))
+	}
+	assert.Equal(t, `data: {"time":"0001-01-01T00:00:00Z","seed":0,"raw":"line1\nline2","fields":{"user":"bob"}}`, lines[0])
+
+	// the stream ends when the spout is closed
+	s.Stop()
+	done := make(chan struct{})
+	go func() {
+		for {
+			if _, err := r.ReadString('\n'); err != nil {
+				close(done)
+				return
+			}
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("stream not ended")
+	}
+}
+
+func TestWriteSSE(t *testing.T) {
+	w := httptest.NewRecorder()
+	assert.Nil(t, writeSSE(w, "skipped", []byte("3")))
+	assert.Nil(t, writeSSE(w, "", []byte("a\r\nb\n")))
+	assert.Equal(t, "event: skipped\ndata: 3\n\ndata: a\ndata: b\n\n", w.Body.String())
+}
@@ -0,0 +1,124 @@
+package spout
+
+import (
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/jiwen624/logspout/config"
+	"github.com/jiwen624/logspout/log"
+	"github.com/jiwen624/logspout/replacer"
+)
+
+// watchInterval is how often the files are checked for changes in watch mode.
+const watchInterval = time.Second
+
+// fileStamp tells if a file is changed, it's the zero value if the file can't
+// be read.
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+// stampFiles returns the stamps of the files.
+func stampFiles(files []string) map[string]fileStamp {
+	stamps := make(map[string]fileStamp, len(files))
+	for _, f := range files {
+		var st fileStamp
+		if fi, err := os.Stat(f); err == nil {
+			st = fileStamp{modTime: fi.ModTime(), size: fi.Size()}
+		}
+		stamps[f] = st
+	}
+	return stamps
+}
+
+// changed tells if any of the files is changed between the stamps.
+func changed(old, cur map[string]fileStamp) bool {
+	if len(old) != len(cur) {
+		return true
+	}
+	for f, st := range cur {
+		if o, ok := old[f]; !ok || !o.modTime.Equal(st.modTime) || o.size != st.size {
+			return true
+		}
+	}
+	return false
+}
+
+// watchedFiles returns the files the config is made of: the config file, the
+// sample file and the list files of the fixedList replacers.
+func (s *Spout) watchedFiles(cfg *config.SpoutConfig) []string {
+	files := []string{s.ConfigPath}
+	if cfg == nil {
+		return files
+	}
+	files = append(files, cfg.SampleFilePath)
+	return append(files, replacer.ListFiles(cfg.Replacement)...)
+}
+
+// Watch watches the config file, the sample file and the list files of the
+// fixedList replacers until the spout is closed, and reloads the configuration
+// when any of them is changed, see Reload. The current configuration is kept
+// if the changes are invalid, and they are applied once they are fixed.
+func (s *Spout) Watch() error {
+	if s.ConfigPath == "" {
+		return errNoConfigPath
+	}
+	s.mu.RLock()
+	files := s.watchedFiles(s.conf)
+	s.mu.RUnlock()
+
+	s.watch(files, watchInterval)
+	log.Infof("Watching %d files for changes.", len(files))
+	return nil
+}
+
+// watch stamps the files and polls them for changes in a standalone
+// goroutine.
+func (s *Spout) watch(files []string, interval time.Duration) {
+	go s.poll(files, stampFiles(files), interval)
+}
+
+func (s *Spout) poll(files []string, stamps map[string]fileStamp, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.close:
+			return
+		case <-ticker.C:
+		}
+
+		cur := stampFiles(files)
+		if !changed(stamps, cur) {
+			continue
+		}
+		stamps = cur
+
+		cfg, err := config.FromFile(s.ConfigPath)
+		if err == nil {
+			// The files the new config is made of are watched even if it's
+			// invalid, so that it's reloaded once they are fixed.
+			// The files already watched keep their stamps, so that the changes
+			// made since then aren't missed.
+			files = s.watchedFiles(cfg)
+			next := stampFiles(files)
+			for f := range next {
+				if st, ok := stamps[f]; ok {
+					next[f] = st
+				}
+			}
+			stamps = next
+			err = s.Reload(cfg)
+		}
+		if err == ErrStopped {
+			return
+		}
+		if err != nil {
+			log.Error(errors.Wrap(err, "the changes are not applied, the current configuration is kept"))
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package spout
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/jiwen624/logspout/output"
+)
+
+// waitForEvent waits until the event is sprayed.
+func waitForEvent(t *testing.T, msgs <-chan []byte, raw string) {
+	deadline := time.After(5 * time.Second)
+	for {
+		select {
+		case msg := <-msgs:
+			if string(msg) == raw {
+				return
+			}
+		case <-deadline:
+			t.Fatalf("%q is not sprayed", raw)
+		}
+	}
+}
+
+func TestWatch(t *testing.T) {
+	s := testSpout(t)
+	assert.Equal(t, errNoConfigPath, s.Watch())
+
+	dir, err := ioutil.TempDir("", "watch")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	sample := writeFile(t, dir, "sample.log", "hi bob\n")
+	list := writeFile(t, dir, "users.sample", "alice\n")
+	writeConfig := func(s string) {
+		c := reloadConfig(t, sample, s)
+		c.Replacement = json.RawMessage(`{"user": {"type": "fixedList", "attrs": {"method": "next", "listFile": "` + list + `"}}}`)
+		b, err := json.Marshal(c)
+		assert.Nil(t, err)
+		writeFile(t, dir, "logspout.json", string(b))
+	}
+	writeConfig(`{}`)
+
+	s.ConfigPath = dir + "/logspout.json"
+	assert.Nil(t, s.ReloadFile())
+	matches, names, err := s.GenerateTokens()
+	assert.Nil(t, err)
+	s.StartWorkers(matches, names)
+	defer s.Shutdown()
+	s.watch(s.watchedFiles(s.conf), 10*time.Millisecond)
+
+	sub, err := newTailSub(url.Values{tailFormatParam: {output.FormatRaw}})
+	assert.Nil(t, err)
+	s.tail.add(sub)
+	defer s.tail.remove(sub)
+	waitForEvent(t, sub.msgs, "hi alice\n")
+
+	// the list file
+	writeFile(t, dir, "users.sample", "caroline\n")
+	waitForEvent(t, sub.msgs, "hi caroline\n")
+
+	// the sample file
+	writeFile(t, dir, "sample.log", "hello bob\n")
+	waitForEvent(t, sub.msgs, "hello caroline\n")
+
+	// the changes are applied once they are fixed
+	writeConfig(`{"concurrency": 0}`)
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, 2, s.Status().Concurrency)
+	writeConfig(`{"concurrency": 3}`)
+	waitForWorkers(t, s, 3)
+}
@@ -3,6 +3,7 @@ package spout
 import (
 	"fmt"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/pkg/errors"
@@ -10,6 +11,7 @@ import (
 	"github.com/jiwen624/logspout/metrics"
 
 	"github.com/jiwen624/logspout/log"
+	"github.com/jiwen624/logspout/output"
 	"github.com/jiwen624/logspout/replacer"
 	"github.com/jiwen624/logspout/utils"
 )
@@ -17,32 +19,53 @@ import (
 type worker struct {
 	// The name of the worker, which is mainly used for logging purpose.
 	name string
-	// The maximum number of events expected, the worker will quit after reaching
-	// this number.
-	// It's calculated via spout's maximum events / concurrency
-	maxEvents int
+	// The budget of events shared by all the workers, the worker will quit
+	// when it's used up.
+	budget *budget
+	// The flag indicates if the last transaction may be cut to use up the
+	// budget, otherwise the events are taken from the budget a transaction at
+	// a time.
+	splitTrans bool
+	// The number of events generated.
+	count int64
 	// The life cycle of this worker.
 	duration time.Duration
 	// The replacers used by the worker to do string substitutions.
 	replacers replacer.Replacers
+	// The replacers and the seed logs reloaded, which are swapped in at the
+	// start of the next transaction.
+	reloaded chan *reloadSet
 	// The transaction ID
 	transIDs []string
 	// The logs to be used for substitutions.
 	seedLogs []string
-	// The minimum interval in milliseconds between logs in the same transaction.
-	minInterval int
-	// The maximum interval in milliseconds between logs in the same transaction.
-	maxInterval int
+	// The minimum and maximum intervals in milliseconds between logs in the
+	// same transaction, which may be changed while the worker is running.
+	intervals func() (min int, max int)
 	// The maximum interval in milliseconds between two adjacent transactions.
 	maxIntraTransLat int
-	// Is the workload (aka TPS) is uniformed or with some jitter
-	uniformLoad bool
+	// The jitters draw the delays between two adjacent transactions and between
+	// two logs in the same transaction.
+	interJitter, intraJitter Jitter
 	// The function to be called to write logs to the output destinations
-	writeTo func(string) error
+	writeTo func(*output.Event) error
 	// The callback function after the worker is finished.
 	doneCallback func()
 	// The channel that indicates the worker should exit when it's closed.
 	closeChan chan struct{}
+	// The channel that indicates this particular worker should exit when it's
+	// closed, e.g., the concurrency is decreased. It finishes the transaction
+	// first.
+	quit chan struct{}
+	// The function returns a channel which is closed when the generation is
+	// resumed if it's paused, otherwise nil.
+	paused func() <-chan struct{}
+	// The limiter which paces the events of all the workers, the worker never
+	// thinks if the rate is limited.
+	limiter *rateLimiter
+	// The schedule of the events paced by the think times, it's nil if the
+	// worker sleeps for the think times after the events are written.
+	schedule *schedule
 	// The random number generator.
 	rand replacer.RandomGenerator
 	// The flag indicates if the workload is in burst mode, where no think time exists.
@@ -52,45 +75,97 @@ type worker struct {
 // See the corresponding comments in fields of the struct worker.
 type workerConfig struct {
 	Index            int
-	MaxEvents        int
+	Budget           *budget
+	SplitTrans       bool
 	Seconds          int
 	Replacers        replacer.Replacers
 	TransIDs         []string
 	SeedLogs         []string
-	MinInterval      int
-	MaxInterval      int
-	UniformLoad      bool
+	Intervals        func() (int, int)
+	InterJitter      Jitter
+	IntraJitter      Jitter
 	MaxIntraTransLat int
-	WriteTo          func(string) error
+	WriteTo          func(*output.Event) error
 	DoneCallback     func()
 	CloseChan        chan struct{}
+	Paused           func() <-chan struct{}
+	Limiter          *rateLimiter
+	Schedule         *schedule
 	BurstMode        bool
 }
 
 func NewWorker(c workerConfig) *worker {
 	w := &worker{
 		name:             fmt.Sprintf("worker%d", c.Index),
-		maxEvents:        c.MaxEvents,
+		budget:           c.Budget,
+		splitTrans:       c.SplitTrans,
 		duration:         time.Second * time.Duration(c.Seconds),
 		replacers:        c.Replacers,
+		reloaded:         make(chan *reloadSet, 1),
 		transIDs:         c.TransIDs,
-		minInterval:      c.MinInterval,
-		maxInterval:      c.MaxInterval,
-		uniformLoad:      c.UniformLoad,
+		intervals:        c.Intervals,
+		interJitter:      c.InterJitter,
+		intraJitter:      c.IntraJitter,
 		maxIntraTransLat: c.MaxIntraTransLat,
 		seedLogs:         c.SeedLogs,
 		writeTo:          c.WriteTo,
 		doneCallback:     c.DoneCallback,
 		closeChan:        c.CloseChan,
+		quit:             make(chan struct{}),
+		paused:           c.Paused,
+		limiter:          c.Limiter,
+		schedule:         c.Schedule,
 		rand:             replacer.NewTruncatedGaussian(0.5, 0.2),
 		burstMode:        c.BurstMode,
 	}
 	return w
 }
 
+// generated returns the number of events generated.
+func (w *worker) generated() int64 {
+	return atomic.LoadInt64(&w.count)
+}
+
+// take takes the events from the budget before the event at evtIdx, which are
+// the whole transaction at its start in transaction mode. It returns the
+// number of events taken, 0 if the budget is used up.
+func (w *worker) take(evtIdx int) int64 {
+	if len(w.transIDs) != 0 && evtIdx == 0 {
+		return w.budget.take(int64(len(w.seedLogs)), !w.splitTrans)
+	}
+	return w.budget.take(1, false)
+}
+
+// reloadSet is what the workers swap in when the configuration is reloaded.
+type reloadSet struct {
+	replacers replacer.Replacers
+	seedLogs  []string
+	// the tokens of the seed logs, which are shared by the workers
+	matches, names [][]string
+}
+
+// reload hands the reload set to the worker, which replaces the one reloaded
+// earlier but not yet swapped in.
+func (w *worker) reload(r *reloadSet) {
+	select {
+	case <-w.reloaded:
+	default:
+	}
+	w.reloaded <- r
+}
+
+// quitting returns the quit channel if the worker may exit before the event at
+// evtIdx, or nil in the middle of a transaction.
+func (w *worker) quitting(evtIdx int) <-chan struct{} {
+	if len(w.transIDs) != 0 && evtIdx != 0 {
+		return nil
+	}
+	return w.quit
+}
+
 // startWorker generates new logs with the replacement policies, in a infinite loop.
-func (w *worker) start(m [][]string, names [][]string, workerID int) {
-	workerName := fmt.Sprintf("worker%d", workerID)
+func (w *worker) start(m [][]string, names [][]string) {
+	workerName := w.name
 
 	log.Infof("%s spawned", workerName)
 	defer log.Infof("%s is exiting.", workerName)
@@ -105,17 +180,72 @@ func (w *worker) start(m [][]string, names [][]string, workerID int) {
 	var evtIdx int
 	// the transaction per second
 	var tps int64
-	// the total count of log events
-	var generatedNum int
+	// the events taken from the budget but not yet generated
+	var taken int64
+	// the events not yet added to the metrics are added when it exits, and the
+	// ones not generated are given back to the budget
+	defer func() {
+		metrics.AddEvents(workerName, tps)
+		metrics.RemoveWorker(workerName)
+		w.budget.refund(taken)
+	}()
 
 	// Does it `think` between two adjacent transactions.
 	sleepIntraTrans := len(w.transIDs) != 0 && !w.burstMode
-	// Does it `think` between two adjacent logs in the same transaction.
-	sleepInterTrans := !w.burstMode && w.maxInterval > 0
 	// The ticker defines the worker metrics flushing interval.
 	cTicker := time.NewTicker(time.Second * 1).C
 
 	for {
+		if resumed := w.paused(); resumed != nil {
+			metrics.AddEvents(workerName, tps)
+			metrics.SetTPS(workerName, 0)
+			tps = 0
+
+			// A retired worker finishes the transaction it has taken from the
+			// budget after it's resumed.
+			select {
+			case <-resumed:
+			case <-w.closeChan:
+				return
+			case <-w.quitting(evtIdx):
+				return
+			}
+			// The events not generated while it's paused are not made up for.
+			if w.schedule != nil {
+				w.schedule.reset()
+			}
+		}
+
+		// The replacers and the seed logs are swapped only between
+		// transactions, so that the events of a transaction are generated by
+		// the same ones.
+		if evtIdx == 0 {
+			select {
+			case r := <-w.reloaded:
+				w.replacers, w.seedLogs = r.replacers, r.seedLogs
+				matches, names = utils.StrSlice2DCopy(r.matches), r.names
+			default:
+			}
+		}
+
+		// Wait for its turn if the rate is limited.
+		intended, ok := w.limiter.wait(w.closeChan, w.quitting(evtIdx))
+		if !ok {
+			return
+		}
+
+		// Exits when the budget is used up. The events are taken only when
+		// they are about to be generated, so that a worker retired before
+		// then doesn't hold any that the others have exited without.
+		if taken == 0 {
+			if taken = w.take(evtIdx); taken == 0 {
+				return
+			}
+		}
+		if intended.IsZero() {
+			intended = w.schedule.intended()
+		}
+
 		// The first message of a transaction
 		for k, v := range w.replacers {
 			idx := utils.StrIndex(names[evtIdx], k)
@@ -131,59 +261,91 @@ func (w *worker) start(m [][]string, names [][]string, workerID int) {
 		}
 
 		// Print to logger streams, you may redirect it to anywhere else you want
-		if err := w.writeTo(strings.Join(matches[evtIdx], "")); err != nil {
+		evt := &output.Event{
+			Raw:    strings.Join(matches[evtIdx], ""),
+			Time:   time.Now(),
+			Seed:   evtIdx,
+			Names:  names[evtIdx],
+			Values: matches[evtIdx],
+		}
+		if !intended.IsZero() {
+			metrics.ObserveLateness(evt.Time.Sub(intended))
+		}
+		if err := w.writeTo(evt); err != nil {
 			log.Warn(errors.Wrap(err, "err writing logs to output"))
 		}
+		metrics.ObserveWrite(time.Since(evt.Time))
+		w.limiter.written(len(evt.Raw))
 
 		tps++
-		// Exits after it exceeds the predefined maximum events.
-		generatedNum++
-		if generatedNum >= w.maxEvents {
+		taken--
+		atomic.AddInt64(&w.count, 1)
+		// Exits at once after the last event rather than after a think time.
+		if taken == 0 && w.budget.remaining() == 0 {
 			return
 		}
 
-		// It never sleeps in burst mode.
-		if sleepIntraTrans {
-			sleepTime := time.Millisecond * time.Duration(w.rand.Next(w.maxIntraTransLat))
-			time.Sleep(sleepTime)
+		// The think time before the next event, it never sleeps in burst mode.
+		var delay time.Duration
+		intra, inter := sleepIntraTrans && !w.limiter.limited(), w.sleepInterTrans()
+		if intra {
+			delay = w.intraJitter.AddJitter(0, time.Duration(w.maxIntraTransLat)*time.Millisecond)
 		}
 
 		evtIdx++
 		if evtIdx >= len(w.seedLogs) {
 			evtIdx = 0
 			// think for a while between transactions
-			if sleepInterTrans {
-				w.think()
+			if inter {
+				delay += w.calculateThinkTime()
 			}
 		}
+		w.think(delay, intra || inter)
 
 		select {
 		case <-w.closeChan:
 			return
+		case <-w.quitting(evtIdx):
+			return
 		case <-cTicker:
 			metrics.SetTPS(workerName, tps)
+			metrics.AddEvents(workerName, tps)
 			tps = 0
 		default:
 		}
 	}
 }
 
-// think calculates the think time and sleep for certain period if time
-func (w *worker) think() {
-	// Sleep for a short while.
-	time.Sleep(w.calculateThinkTime())
+// sleepInterTrans tells if it `thinks` between two adjacent logs in the same
+// transaction.
+func (w *worker) sleepInterTrans() bool {
+	_, max := w.intervals()
+	return !w.burstMode && max > 0 && !w.limiter.limited()
 }
 
-func (w *worker) calculateThinkTime() time.Duration {
-	if w.maxInterval <= w.minInterval {
-		return time.Duration(w.minInterval) * time.Millisecond
+// think sleeps for the think time, or until the next event is intended on the
+// schedule if the events are paced by the think times, even if it's 0 between
+// two events.
+func (w *worker) think(d time.Duration, paced bool) {
+	switch {
+	case w.schedule == nil:
+		if d > 0 {
+			time.Sleep(d)
+		}
+	case paced:
+		w.schedule.sleep(d)
+	default:
+		// The events which are not paced by the think times are not on the
+		// schedule.
+		w.schedule.reset()
 	}
+}
 
-	if w.uniformLoad {
-		d := w.minInterval + w.rand.Next(w.maxInterval-w.minInterval)
-		return time.Duration(d) * time.Millisecond
+func (w *worker) calculateThinkTime() time.Duration {
+	minInterval, maxInterval := w.intervals()
+	if maxInterval < minInterval {
+		maxInterval = minInterval
 	}
-	// TODO: change to stochastic workload
-	d := w.minInterval + w.rand.Next(w.maxInterval-w.minInterval)
-	return time.Duration(d) * time.Millisecond
+	return w.interJitter.AddJitter(time.Duration(minInterval)*time.Millisecond,
+		time.Duration(maxInterval)*time.Millisecond)
 }
@@ -0,0 +1,65 @@
+package tui
+
+import (
+	"io"
+	"strings"
+	"sync"
+)
+
+// logBuffer keeps the latest lines of the logs, which are shown in the
+// dashboard instead of being written to the terminal.
+type logBuffer struct {
+	mu    sync.Mutex
+	size  int
+	lines []string
+	// the incomplete line written last
+	partial string
+	// the logs are written to it once the buffer is flushed
+	w io.Writer
+}
+
+func newLogBuffer(size int) *logBuffer {
+	return &logBuffer{size: size}
+}
+
+// Write appends the lines, the oldest ones are discarded if it's full.
+func (b *logBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.w != nil {
+		return b.w.Write(p)
+	}
+
+	parts := strings.Split(b.partial+string(p), "\n")
+	b.partial = parts[len(parts)-1]
+	b.lines = append(b.lines, parts[:len(parts)-1]...)
+	if over := len(b.lines) - b.size; over > 0 {
+		b.lines = append(b.lines[:0], b.lines[over:]...)
+	}
+	return len(p), nil
+}
+
+// last returns the latest n lines at most.
+func (b *logBuffer) last(n int) []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if n > len(b.lines) {
+		n = len(b.lines)
+	}
+	return append([]string(nil), b.lines[len(b.lines)-n:]...)
+}
+
+// flush writes the lines kept to w, the logs written later go to w directly.
+func (b *logBuffer) flush(w io.Writer) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, l := range b.lines {
+		io.WriteString(w, l+"\n")
+	}
+	if b.partial != "" {
+		io.WriteString(w, b.partial)
+	}
+	b.lines, b.partial, b.w = nil, "", w
+}
@@ -0,0 +1,295 @@
+package tui
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/jiwen624/logspout/metrics"
+	"github.com/jiwen624/logspout/spout"
+)
+
+// maxHistory is the number of the samples kept for a sparkline.
+const maxHistory = 256
+
+// the bars of a sparkline from the lowest to the highest
+var sparkBars = []rune("▁▂▃▄▅▆▇█")
+
+const helpLine = "[p] pause/resume  [+/-] workers  [f/s] faster/slower  [q] quit"
+
+// series is the latest values of a rate.
+type series struct {
+	values []int64
+}
+
+func (s *series) add(v int64) {
+	s.values = append(s.values, v)
+	if len(s.values) > maxHistory {
+		s.values = append(s.values[:0], s.values[len(s.values)-maxHistory:]...)
+	}
+}
+
+func (s *series) last() int64 {
+	if len(s.values) == 0 {
+		return 0
+	}
+	return s.values[len(s.values)-1]
+}
+
+// sparkline draws the latest values of the series in width runes, scaled to the
+// highest one shown.
+func sparkline(values []int64, width int) string {
+	if width <= 0 {
+		return ""
+	}
+	if len(values) > width {
+		values = values[len(values)-width:]
+	}
+
+	var max int64
+	for _, v := range values {
+		if v > max {
+			max = v
+		}
+	}
+
+	var b strings.Builder
+	b.WriteString(strings.Repeat(" ", width-len(values)))
+	for _, v := range values {
+		i := 0
+		if max > 0 && v > 0 {
+			i = int(float64(v) / float64(max) * float64(len(sparkBars)-1))
+		}
+		b.WriteRune(sparkBars[i])
+	}
+	return b.String()
+}
+
+// progress draws a bar of width runes filled by the fraction.
+func progress(frac float64, width int) string {
+	if width <= 0 {
+		return ""
+	}
+	frac = math.Max(0, math.Min(1, frac))
+	n := int(frac * float64(width))
+	return "[" + strings.Repeat("█", n) + strings.Repeat("░", width-n) + "]"
+}
+
+// human formats a number with a unit prefix, e.g., 12.3k.
+func human(v float64) string {
+	switch {
+	case v >= 1e9:
+		return fmt.Sprintf("%.2fG", v/1e9)
+	case v >= 1e6:
+		return fmt.Sprintf("%.2fM", v/1e6)
+	case v >= 1e4:
+		return fmt.Sprintf("%.1fk", v/1e3)
+	}
+	return strconv.FormatFloat(v, 'f', 0, 64)
+}
+
+// humanBytes formats a number of bytes with a binary unit, e.g., 1.5MiB.
+func humanBytes(v int64) string {
+	const unit = 1024
+	if v < unit {
+		return fmt.Sprintf("%dB", v)
+	}
+	div, exp := int64(unit), 0
+	for n := v / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%ciB", float64(v)/float64(div), "KMGTPE"[exp])
+}
+
+func seconds(s float64) string {
+	return time.Duration(s * float64(time.Second)).Round(time.Second).String()
+}
+
+// fit truncates or pads the line to width runes.
+func fit(s string, width int) string {
+	n := utf8.RuneCountInString(s)
+	if n > width {
+		return string([]rune(s)[:width])
+	}
+	return s + strings.Repeat(" ", width-n)
+}
+
+// workerNames returns the names of the workers in their order, without the
+// total.
+func workerNames(history map[string]*series) []string {
+	var names []string
+	for name := range history {
+		if name != metrics.TotalTPS {
+			names = append(names, name)
+		}
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if len(names[i]) != len(names[j]) {
+			return len(names[i]) < len(names[j])
+		}
+		return names[i] < names[j]
+	})
+	return names
+}
+
+// render renders the screen of the size, each line is cleared to its end.
+func (u *UI) render(width, height int) string {
+	st := u.s.Status()
+	top := u.renderStatus(st, width)
+	top = append(top, u.renderRates(width, height-len(top))...)
+	top = append(top, "")
+	top = append(top, u.renderOutputs(width)...)
+
+	var bottom []string
+	if u.message != "" {
+		bottom = append(bottom, u.message)
+	}
+	bottom = append(bottom, helpLine)
+
+	room := height - len(bottom)
+	if room < 0 {
+		room = 0
+	}
+	lines := top
+	if n := room - len(top) - 2; n > 0 {
+		lines = append(lines, "", "Logs")
+		lines = append(lines, u.logs.last(n)...)
+	}
+	if len(lines) > room {
+		lines = lines[:room]
+	}
+	for len(lines) < room {
+		lines = append(lines, "")
+	}
+	lines = append(lines, bottom...)
+
+	var b strings.Builder
+	for i, l := range lines {
+		if i > 0 {
+			b.WriteString("\r\n")
+		}
+		b.WriteString(fit(l, width))
+		b.WriteString(clearLine)
+	}
+	return b.String()
+}
+
+// renderStatus renders the state and the progress of the generation.
+func (u *UI) renderStatus(st spout.Status, width int) []string {
+	name := "Logspout"
+	if st.Version != "" {
+		name += " " + st.Version
+		if st.Commit != "" {
+			name += "+" + st.Commit
+		}
+	}
+	pace := fmt.Sprintf("intervals [%d, %d]ms", st.MinInterval, st.MaxInterval)
+	switch {
+	case st.EPS > 0:
+		pace = "eps " + human(st.EPS)
+	case st.BytesPerSecond > 0:
+		pace = humanBytes(int64(st.BytesPerSecond)) + "/s"
+	}
+	if st.LoadProfile != "" {
+		pace += fmt.Sprintf(" x%.2f %s", st.LoadFactor, st.LoadProfile)
+	}
+	workers := fmt.Sprintf("workers %d/%d", st.Workers, st.Concurrency)
+	if st.WorkerProfile != "" {
+		workers += fmt.Sprintf(" x%.2f %s", st.WorkerFactor, st.WorkerProfile)
+	}
+	lines := []string{
+		fmt.Sprintf("%s  %s  %s  %s",
+			name, strings.ToUpper(string(st.State)), workers, pace),
+		"",
+	}
+
+	barWidth := width - 40
+	if barWidth > 40 {
+		barWidth = 40
+	}
+	if st.Duration > 0 {
+		lines = append(lines, fmt.Sprintf("Time    %s %3.0f%%  %s / %s",
+			progress(st.Uptime/float64(st.Duration), barWidth),
+			math.Min(100, st.Uptime*100/float64(st.Duration)),
+			seconds(st.Uptime), seconds(float64(st.Duration))))
+	} else {
+		lines = append(lines, fmt.Sprintf("Time    %s (no limit)", seconds(st.Uptime)))
+	}
+	if st.MaxEvents > 0 && st.MaxEvents < math.MaxInt32 {
+		frac := float64(st.Events) / float64(st.MaxEvents)
+		lines = append(lines, fmt.Sprintf("Events  %s %3.0f%%  %s / %s",
+			progress(frac, barWidth), math.Min(100, frac*100),
+			human(float64(st.Events)), human(float64(st.MaxEvents))))
+	} else {
+		lines = append(lines, fmt.Sprintf("Events  %s (no limit)", human(float64(st.Events))))
+	}
+
+	configured := "unlimited"
+	if st.ConfiguredRate > 0 {
+		configured = human(st.ConfiguredRate) + "/s"
+	}
+	lines = append(lines, fmt.Sprintf("Rate    %s/s  configured %s", human(float64(st.Rate)), configured))
+	return lines
+}
+
+// renderRates renders the sparklines of the total and the workers rates in the
+// height at most, the workers not fit in are summarized.
+func (u *UI) renderRates(width, height int) []string {
+	const labelWidth, valueWidth = 10, 10
+	sparkWidth := width - labelWidth - valueWidth - 2
+
+	line := func(name string, s *series) string {
+		return fmt.Sprintf("%-*s %s %*s", labelWidth, name, sparkline(s.values, sparkWidth),
+			valueWidth, human(float64(s.last()))+"/s")
+	}
+
+	lines := []string{""}
+	total, ok := u.history[metrics.TotalTPS]
+	if !ok {
+		total = &series{}
+	}
+	lines = append(lines, line("Total", total))
+
+	// Leave the room for the outputs and the logs.
+	names := workerNames(u.history)
+	room := height / 3
+	if room < 1 {
+		room = 1
+	}
+	for i, name := range names {
+		if i == room-1 && len(names) > room {
+			lines = append(lines, fmt.Sprintf("... %d more workers", len(names)-i))
+			break
+		}
+		lines = append(lines, line(name, u.history[name]))
+	}
+	return lines
+}
+
+// renderOutputs renders the counters of the outputs.
+func (u *UI) renderOutputs(width int) []string {
+	const cols = "%10s %10s %8s %8s %8s %10s"
+	nameWidth := width - len(fmt.Sprintf(cols, "", "", "", "", "", "")) - 1
+	if nameWidth < 10 {
+		nameWidth = 10
+	}
+
+	lines := []string{fmt.Sprintf("%-*s "+cols, nameWidth, "OUTPUT", "EVENTS", "BYTES", "ERRORS", "RETRIES", "DROPS", "EVENTS/S")}
+	names := make([]string, 0, len(u.lastOutputs))
+	for name := range u.lastOutputs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		o := u.lastOutputs[name]
+		lines = append(lines, fmt.Sprintf("%-*s "+cols, nameWidth, fit(name, nameWidth),
+			human(float64(o.Events)), humanBytes(o.Bytes), human(float64(o.Errors)),
+			human(float64(o.Retries)), human(float64(o.Drops)), human(u.outputRates[name])))
+	}
+	return lines
+}
@@ -0,0 +1,26 @@
+//go:build !linux && !darwin && !dragonfly && !freebsd && !netbsd && !openbsd
+// +build !linux,!darwin,!dragonfly,!freebsd,!netbsd,!openbsd
+
+package tui
+
+import "github.com/pkg/errors"
+
+var errUnsupported = errors.New("the terminal dashboard is not supported on this platform")
+
+type termState struct{}
+
+func isTerminal(fd int) bool {
+	return false
+}
+
+func makeCbreak(fd int) (*termState, error) {
+	return nil, errUnsupported
+}
+
+func (s *termState) restore() error {
+	return nil
+}
+
+func termSize(fd int) (width, height int, err error) {
+	return 0, 0, errUnsupported
+}
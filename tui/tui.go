@@ -0,0 +1,278 @@
+// Package tui implements a full-screen terminal dashboard of a running spout,
+// which is an alternative to polling the metrics of the console when logspout
+// is run ad hoc on a server. It shows the rates of the workers as sparklines,
+// the counters of the outputs, the progress against the duration and the
+// maximum number of events, and the latest logs, and it takes the keys to
+// control the generation.
+package tui
+
+import (
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/jiwen624/logspout/log"
+	"github.com/jiwen624/logspout/metrics"
+	"github.com/jiwen624/logspout/spout"
+)
+
+// Spout is the spout the dashboard shows and controls, see spout.Spout.
+type Spout interface {
+	Status() spout.Status
+	Pause() error
+	Resume() error
+	Apply(spout.Settings) error
+	// Shutdown stops the spout gracefully as if Ctrl-C is pressed: the workers
+	// are drained before the outputs are flushed and closed.
+	Shutdown() (*spout.Report, error)
+}
+
+var errNotTerminal = errors.New("the terminal dashboard requires a terminal")
+
+// the escape sequences to control the terminal
+const (
+	altScreenOn  = "\x1b[?1049h"
+	altScreenOff = "\x1b[?1049l"
+	cursorOff    = "\x1b[?25l"
+	cursorOn     = "\x1b[?25h"
+	cursorHome   = "\x1b[H"
+	clearLine    = "\x1b[K"
+	clearBelow   = "\x1b[J"
+)
+
+const (
+	// refreshInterval is how often the metrics are sampled and the screen is
+	// redrawn, which matches the interval the workers report their rates.
+	refreshInterval = time.Second
+	// the size of the screen if it can't be told
+	defaultWidth  = 80
+	defaultHeight = 24
+	// the number of the log lines kept
+	logLines = 1000
+)
+
+// UI is the terminal dashboard.
+type UI struct {
+	s    Spout
+	logs *logBuffer
+
+	in  *os.File
+	out io.Writer
+	// where the logs are written after the dashboard is closed
+	errOut io.Writer
+	term   *termState
+	quit   chan struct{}
+	done   chan struct{}
+	close  sync.Once
+
+	// history keeps the latest rates of the workers and the total for the
+	// sparklines
+	history map[string]*series
+	// the output counters of the last sample, to tell the rates
+	lastOutputs map[string]metrics.OutputSnapshot
+	lastSample  time.Time
+	outputRates map[string]float64
+	// message is the feedback of the last key pressed
+	message string
+}
+
+// New creates a dashboard of the spout.
+func New(s Spout) *UI {
+	return &UI{
+		s:           s,
+		logs:        newLogBuffer(logLines),
+		in:          os.Stdin,
+		out:         os.Stdout,
+		errOut:      os.Stderr,
+		quit:        make(chan struct{}),
+		done:        make(chan struct{}),
+		history:     make(map[string]*series),
+		outputRates: make(map[string]float64),
+	}
+}
+
+// Start takes over the terminal and shows the dashboard in a standalone
+// goroutine until Close is called. The logs are shown at the bottom of the
+// dashboard rather than written to the terminal, so it must be called before
+// the logs are written concurrently, see log.SetOutput. It fails if the stdin
+// is not a terminal.
+func (u *UI) Start() error {
+	if !isTerminal(int(u.in.Fd())) {
+		return errNotTerminal
+	}
+	term, err := makeCbreak(int(u.in.Fd()))
+	if err != nil {
+		return errors.Wrap(err, "start tui")
+	}
+	u.term = term
+	log.SetOutput(u.logs)
+	io.WriteString(u.out, altScreenOn+cursorOff)
+
+	keys := make(chan byte)
+	go u.readKeys(keys)
+	go u.loop(keys)
+	return nil
+}
+
+// Close restores the terminal, then the logs kept are written to the stderr
+// so that they are not lost with the screen, as are the ones written later.
+func (u *UI) Close() {
+	u.close.Do(func() {
+		close(u.quit)
+		<-u.done
+
+		io.WriteString(u.out, cursorOn+altScreenOff)
+		u.term.restore()
+		u.logs.flush(u.errOut)
+	})
+}
+
+// readKeys sends the keys pressed to the channel. It blocks on the stdin and
+// exits with the program.
+func (u *UI) readKeys(keys chan<- byte) {
+	buf := make([]byte, 16)
+	for {
+		n, err := u.in.Read(buf)
+		if err != nil {
+			return
+		}
+		for _, b := range buf[:n] {
+			select {
+			case keys <- b:
+			case <-u.quit:
+				return
+			}
+		}
+	}
+}
+
+// loop samples the metrics and redraws the screen until it's closed.
+func (u *UI) loop(keys <-chan byte) {
+	defer close(u.done)
+
+	ticker := time.NewTicker(refreshInterval)
+	defer ticker.Stop()
+
+	u.sample(time.Now())
+	u.draw()
+	for {
+		select {
+		case <-u.quit:
+			return
+		case k := <-keys:
+			u.handleKey(k)
+			u.draw()
+		case now := <-ticker.C:
+			u.sample(now)
+			u.draw()
+		}
+	}
+}
+
+// handleKey takes the action of the key pressed.
+func (u *UI) handleKey(k byte) {
+	var err error
+	switch k {
+	case 'p', 'P', ' ':
+		if err = u.s.Pause(); err == spout.ErrNotRunning {
+			err = u.s.Resume()
+		}
+	case '+', '=':
+		err = u.setConcurrency(1)
+	case '-', '_':
+		err = u.setConcurrency(-1)
+	case 'f', 'F':
+		err = u.scaleRate(false)
+	case 's', 'S':
+		err = u.scaleRate(true)
+	case 'q', 'Q':
+		u.message = "Stopping..."
+		// The keys are still handled while the workers are drained.
+		go func() {
+			if _, err := u.s.Shutdown(); err != nil {
+				log.Warn(errors.Wrap(err, "shutdown"))
+			}
+		}()
+		return
+	default:
+		return
+	}
+
+	if err != nil {
+		u.message = err.Error()
+	} else {
+		u.message = ""
+	}
+}
+
+// setConcurrency adds delta to the concurrency.
+func (u *UI) setConcurrency(delta int) error {
+	n := u.s.Status().Concurrency + delta
+	if n <= 0 {
+		return spout.ErrBadConcurrency
+	}
+	return u.s.Apply(spout.Settings{Concurrency: &n})
+}
+
+// scaleRate halves the rate to slow down the generation or doubles it to speed
+// it up, or scales the intervals the other way if the rate is not set.
+func (u *UI) scaleRate(slower bool) error {
+	st := u.s.Status()
+	factor := 2.0
+	if slower {
+		factor = 0.5
+	}
+	switch {
+	case st.EPS > 0:
+		eps := st.EPS * factor
+		return u.s.Apply(spout.Settings{EPS: &eps})
+	case st.BytesPerSecond > 0:
+		bps := st.BytesPerSecond * factor
+		return u.s.Apply(spout.Settings{BytesPerSecond: &bps})
+	}
+
+	min, max := st.MinInterval, st.MaxInterval
+	if slower {
+		min, max = min*2, max*2
+		if max == 0 {
+			max = 1
+		}
+	} else {
+		min, max = min/2, max/2
+	}
+	return u.s.Apply(spout.Settings{MinInterval: &min, MaxInterval: &max})
+}
+
+// sample records the rates of the workers and the outputs.
+func (u *UI) sample(now time.Time) {
+	for name, v := range metrics.Rates() {
+		h, ok := u.history[name]
+		if !ok {
+			h = &series{}
+			u.history[name] = h
+		}
+		h.add(v)
+	}
+
+	outputs := metrics.Outputs()
+	if u.lastOutputs != nil {
+		if secs := now.Sub(u.lastSample).Seconds(); secs > 0 {
+			for name, o := range outputs {
+				u.outputRates[name] = float64(o.Events-u.lastOutputs[name].Events) / secs
+			}
+		}
+	}
+	u.lastOutputs, u.lastSample = outputs, now
+}
+
+// draw redraws the screen.
+func (u *UI) draw() {
+	width, height, err := termSize(int(u.in.Fd()))
+	if err != nil || width <= 0 || height <= 0 {
+		width, height = defaultWidth, defaultHeight
+	}
+	io.WriteString(u.out, cursorHome+u.render(width, height)+clearBelow)
+}
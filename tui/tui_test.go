@@ -0,0 +1,213 @@
+package tui
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/jiwen624/logspout/metrics"
+	"github.com/jiwen624/logspout/spout"
+)
+
+// fakeSpout records the controls.
+type fakeSpout struct {
+	status  spout.Status
+	stopped chan struct{}
+}
+
+func (f *fakeSpout) Status() spout.Status { return f.status }
+
+func (f *fakeSpout) Pause() error {
+	if f.status.State != spout.StateRunning {
+		return spout.ErrNotRunning
+	}
+	f.status.State = spout.StatePaused
+	return nil
+}
+
+func (f *fakeSpout) Resume() error {
+	if f.status.State != spout.StatePaused {
+		return spout.ErrNotPaused
+	}
+	f.status.State = spout.StateRunning
+	return nil
+}
+
+func (f *fakeSpout) Apply(st spout.Settings) error {
+	if st.Concurrency != nil {
+		f.status.Concurrency = *st.Concurrency
+	}
+	if st.MinInterval != nil {
+		f.status.MinInterval = *st.MinInterval
+	}
+	if st.MaxInterval != nil {
+		f.status.MaxInterval = *st.MaxInterval
+	}
+	if st.EPS != nil {
+		f.status.EPS = *st.EPS
+	}
+	if st.BytesPerSecond != nil {
+		f.status.BytesPerSecond = *st.BytesPerSecond
+	}
+	return nil
+}
+
+func (f *fakeSpout) Shutdown() (*spout.Report, error) {
+	close(f.stopped)
+	return &spout.Report{}, nil
+}
+
+func newFakeSpout() *fakeSpout {
+	return &fakeSpout{stopped: make(chan struct{}), status: spout.Status{
+		State:       spout.StateRunning,
+		Concurrency: 2,
+		MinInterval: 4,
+		MaxInterval: 8,
+		Duration:    100,
+		Uptime:      25,
+		MaxEvents:   1000,
+		Events:      500,
+	}}
+}
+
+func TestHandleKey(t *testing.T) {
+	f := newFakeSpout()
+	u := New(f)
+
+	u.handleKey('p')
+	assert.Equal(t, spout.StatePaused, f.status.State)
+	u.handleKey('p')
+	assert.Equal(t, spout.StateRunning, f.status.State)
+
+	u.handleKey('+')
+	assert.Equal(t, 3, f.status.Concurrency)
+	u.handleKey('-')
+	u.handleKey('-')
+	u.handleKey('-')
+	assert.Equal(t, 1, f.status.Concurrency)
+	assert.Equal(t, spout.ErrBadConcurrency.Error(), u.message)
+
+	u.handleKey('f')
+	assert.Equal(t, 2, f.status.MinInterval)
+	assert.Equal(t, 4, f.status.MaxInterval)
+	assert.Equal(t, "", u.message)
+	u.handleKey('s')
+	u.handleKey('s')
+	assert.Equal(t, 8, f.status.MinInterval)
+	assert.Equal(t, 16, f.status.MaxInterval)
+
+	// the EPS is scaled instead of the intervals if it's set
+	f.status.EPS = 1000
+	u.handleKey('f')
+	assert.Equal(t, 2000.0, f.status.EPS)
+	u.handleKey('s')
+	u.handleKey('s')
+	assert.Equal(t, 500.0, f.status.EPS)
+	assert.Equal(t, 16, f.status.MaxInterval)
+
+	f.status.EPS, f.status.BytesPerSecond = 0, 1<<20
+	u.handleKey('s')
+	assert.Equal(t, float64(1<<19), f.status.BytesPerSecond)
+
+	u.handleKey('x')
+	select {
+	case <-f.stopped:
+		t.Fatal("stopped by an unknown key")
+	default:
+	}
+	u.handleKey('q')
+	select {
+	case <-f.stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("not shut down")
+	}
+}
+
+func TestRender(t *testing.T) {
+	metrics.SetTPS("worker0", 10)
+	metrics.SetTPS("worker1", 20)
+	metrics.Output("tui-output").Write(5, nil, time.Millisecond)
+
+	u := New(newFakeSpout())
+	u.logs.Write([]byte("first log\nsecond log\n"))
+	now := time.Now()
+	u.sample(now)
+	metrics.Output("tui-output").Write(5, nil, time.Millisecond)
+	u.sample(now.Add(time.Second))
+
+	screen := u.render(100, 40)
+	lines := strings.Split(screen, "\r\n")
+	assert.Len(t, lines, 40)
+	for _, l := range lines {
+		assert.True(t, strings.HasSuffix(l, clearLine))
+	}
+	assert.Contains(t, screen, "RUNNING")
+	assert.Contains(t, screen, " 25%  25s / 1m40s")
+	assert.Contains(t, screen, " 50%  500 / 1000")
+	assert.Contains(t, screen, "worker0")
+	assert.Contains(t, screen, "worker1")
+	assert.Contains(t, screen, "tui-output")
+	assert.Contains(t, screen, "second log")
+	assert.Contains(t, lines[len(lines)-1], helpLine)
+	assert.Equal(t, float64(1), u.outputRates["tui-output"])
+
+	// too small to show anything but the help
+	assert.Equal(t, fit(helpLine, 20)+clearLine, u.render(20, 1))
+	assert.NotPanics(t, func() { u.render(5, 0) })
+}
+
+func TestSparkline(t *testing.T) {
+	assert.Equal(t, "  ▁▄█", sparkline([]int64{0, 4, 8}, 5))
+	assert.Equal(t, "▁▁", sparkline([]int64{0, 0, 0}, 2))
+	assert.Equal(t, "", sparkline([]int64{1}, 0))
+
+	var s series
+	for i := 0; i < maxHistory+10; i++ {
+		s.add(int64(i))
+	}
+	assert.Len(t, s.values, maxHistory)
+	assert.Equal(t, int64(maxHistory+9), s.last())
+}
+
+func TestFormat(t *testing.T) {
+	assert.Equal(t, "[██░░]", progress(0.5, 4))
+	assert.Equal(t, "[████]", progress(2, 4))
+	assert.Equal(t, "9999", human(9999))
+	assert.Equal(t, "12.3k", human(12345))
+	assert.Equal(t, "1.50M", human(1.5e6))
+	assert.Equal(t, "512B", humanBytes(512))
+	assert.Equal(t, "1.5KiB", humanBytes(1536))
+	assert.Equal(t, "2.0MiB", humanBytes(2<<20))
+	assert.Equal(t, "ab", fit("abc", 2))
+	assert.Equal(t, "▁▂  ", fit("▁▂", 4))
+}
+
+func TestLogBuffer(t *testing.T) {
+	b := newLogBuffer(2)
+	b.Write([]byte("a\nb\nc"))
+	b.Write([]byte("d\n"))
+	assert.Equal(t, []string{"b", "cd"}, b.last(5))
+	assert.Equal(t, []string{"cd"}, b.last(1))
+
+	var out bytes.Buffer
+	b.flush(&out)
+	b.Write([]byte("e\n"))
+	assert.Equal(t, "b\ncd\ne\n", out.String())
+	assert.Empty(t, b.last(1))
+}
+
+func TestStartNotTerminal(t *testing.T) {
+	f, err := ioutil.TempFile("", "tui")
+	assert.Nil(t, err)
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	u := New(newFakeSpout())
+	u.in = f
+	assert.NotNil(t, u.Start())
+}